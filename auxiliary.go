@@ -163,8 +163,12 @@ func findField(l *State, objectIndex, level int) bool {
 func pushGlobalFunctionName(l *State, f Frame) bool {
 	top := l.Top()
 	Info(l, "f", f) // push function
-	l.PushGlobalTable()
+	l.Field(RegistryIndex, "_LOADED")
 	if findField(l, top+1, 2) {
+		if name, _ := l.ToString(-1); strings.HasPrefix(name, "_G.") { // name of a global function?
+			l.PushString(name[3:])
+			l.Remove(-2)
+		}
 		l.Copy(-1, top+1) // move name to proper place
 		l.Pop(2)          // remove pushed values
 		return true
@@ -482,7 +486,13 @@ func skipComment(r *bufio.Reader) (bool, error) {
 }
 
 func LoadFile(l *State, fileName, mode string) error {
-	var f File
+	return loadFile(l, fileName, mode, func(name string) (io.ReadCloser, error) {
+		return openFile(l, name, os.O_RDONLY, 0)
+	})
+}
+
+func loadFile(l *State, fileName, mode string, open func(string) (io.ReadCloser, error)) error {
+	var f io.ReadCloser
 	var r io.Reader
 	fileNameIndex := l.Top() + 1
 	fileError := func(what string) error {
@@ -497,7 +507,7 @@ func LoadFile(l *State, fileName, mode string) error {
 	} else {
 		l.PushString("@" + fileName)
 		var err error
-		if f, err = openFile(l, fileName, os.O_RDONLY, 0); err != nil {
+		if f, err = open(fileName); err != nil {
 			return fileError("open")
 		}
 		r = f
@@ -2,7 +2,6 @@ package lua
 
 import (
 	"io"
-	"runtime"
 	"strconv"
 	"strings"
 )
@@ -124,16 +123,15 @@ var baseLibrary = []RegistryFunction{
 	{"collectgarbage", func(l *State) int {
 		switch opt, _ := OptString(l, 1, "collect"), OptInteger(l, 2, 0); opt {
 		case "collect":
-			runtime.GC()
+			l.collectGarbage()
 			l.PushInteger(0)
 		case "step":
-			runtime.GC()
+			l.collectGarbage()
 			l.PushBoolean(true)
 		case "count":
-			var stats runtime.MemStats
-			runtime.ReadMemStats(&stats)
-			l.PushNumber(float64(stats.HeapAlloc >> 10))
-			l.PushInteger(int(stats.HeapAlloc & 0x3ff))
+			n := l.global.allocated
+			l.PushNumber(float64(n) / 1024)
+			l.PushInteger(n & 0x3ff)
 			return 2
 		default:
 			l.PushInteger(-1)
@@ -199,7 +197,7 @@ var baseLibrary = []RegistryFunction{
 		CheckAny(l, 1)
 		l.PushNil()
 		l.Insert(1) // create space for status result
-		return finishProtectedCall(l, nil == l.ProtectedCallWithContinuation(l.Top()-2, MultipleReturns, 0, 0, protectedCallContinuation))
+		return finishProtectedCall(l, nil == l.protectedCallWithContinuation(l.Top()-2, MultipleReturns, 0, 0, protectedCallContinuation))
 	}},
 	{"print", func(l *State) int {
 		n := l.Top()
@@ -279,8 +277,8 @@ var baseLibrary = []RegistryFunction{
 	}},
 	{"tonumber", func(l *State) int {
 		if l.IsNoneOrNil(2) { // standard conversion
-			if n, ok := l.ToNumber(1); ok {
-				l.PushNumber(n)
+			if n, ok := l.toNumeric(l.indexToValue(1)); ok {
+				l.apiPush(n)
 				return 1
 			}
 			CheckAny(l, 1)
@@ -289,7 +287,7 @@ var baseLibrary = []RegistryFunction{
 			base := CheckInteger(l, 2)
 			ArgumentCheck(l, 2 <= base && base <= 36, 2, "base out of range")
 			if i, err := strconv.ParseInt(strings.TrimSpace(s), base, 64); err == nil {
-				l.PushNumber(float64(i))
+				l.PushInteger64(i)
 				return 1
 			}
 		}
@@ -312,7 +310,7 @@ var baseLibrary = []RegistryFunction{
 		l.PushValue(1) // exchange function and error handler
 		l.Copy(2, 1)
 		l.Replace(2)
-		return finishProtectedCall(l, nil == l.ProtectedCallWithContinuation(n-2, MultipleReturns, 1, 0, protectedCallContinuation))
+		return finishProtectedCall(l, nil == l.protectedCallWithContinuation(n-2, MultipleReturns, 1, 0, protectedCallContinuation))
 	}},
 }
 
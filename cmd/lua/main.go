@@ -0,0 +1,371 @@
+// Command lua is a standalone interpreter for go-lua, mirroring the lua
+// program of the reference implementation:
+//
+//	usage: lua [options] [script [args]]
+//	Available options are:
+//	  -e stat  execute string 'stat'
+//	  -i       enter interactive mode after executing 'script'
+//	  -l name  require library 'name'
+//	  -v       show version information
+//	  -E       ignore environment variables
+//	  --       stop handling options
+//	  -        stop handling options and execute stdin
+//
+// Unless -E is given, the LUA_INIT_5_2 environment variable, or else
+// LUA_INIT, is run before the arguments: if it starts with '@', as a file
+// name, and otherwise as Lua code. The script arguments are passed in the
+// global table arg, with the script name at index 0.
+//
+// In interactive mode, each line is run as a statement, or evaluated as an
+// expression whose values are printed, as is a line starting with '='.
+// Incomplete statements continue on the next lines, prompted with _PROMPT2.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/hoxbio/go-lua"
+)
+
+const (
+	prompt  = "> "
+	prompt2 = ">> "
+	usage   = `usage: %s [options] [script [args]]
+Available options are:
+  -e stat  execute string 'stat'
+  -i       enter interactive mode after executing 'script'
+  -l name  require library 'name'
+  -v       show version information
+  -E       ignore environment variables
+  --       stop handling options
+  -        stop handling options and execute stdin
+`
+)
+
+// eofMark ends the message of syntax errors caused by incomplete input.
+const eofMark = "<eof>"
+
+type interpreter struct {
+	l        *lua.State
+	progName string
+	stdin    *bufio.Reader
+	stdout   io.Writer
+	stderr   io.Writer
+	tty      bool
+}
+
+func main() {
+	stat, err := os.Stdin.Stat()
+	tty := err == nil && stat.Mode()&os.ModeCharDevice != 0
+	os.Exit(run(os.Args, os.Stdin, os.Stdout, os.Stderr, tty))
+}
+
+// options are the options collected from the command line.
+type options struct {
+	execute, interactive, version, noEnv bool
+	script                               int // index of the script in args, or 0 if none
+}
+
+// collectArgs checks the options in args, returning the index of the first
+// invalid one, or of an option missing its argument, if any.
+func collectArgs(args []string) (o options, bad int) {
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			o.script = i
+			return
+		}
+		switch arg {
+		case "--":
+			if i+1 < len(args) {
+				o.script = i + 1
+			}
+			return
+		case "-":
+			o.script = i
+			return
+		case "-E":
+			o.noEnv = true
+		case "-i":
+			o.interactive, o.version = true, true
+		case "-v":
+			o.version = true
+		default:
+			if arg[1] != 'e' && arg[1] != 'l' {
+				return o, i
+			}
+			o.execute = o.execute || arg[1] == 'e'
+			if len(arg) == 2 { // no concatenated argument?
+				if i++; i >= len(args) || strings.HasPrefix(args[i], "-") {
+					return o, i - 1
+				}
+			}
+		}
+	}
+	return
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer, tty bool) int {
+	in := &interpreter{progName: "lua", stdin: bufio.NewReader(stdin), stdout: stdout, stderr: stderr, tty: tty}
+	if len(args) > 0 && args[0] != "" {
+		in.progName = args[0]
+	}
+	o, bad := collectArgs(args)
+	if bad > 0 {
+		in.printUsage(args[bad])
+		return 1
+	}
+	in.l = lua.NewState()
+	in.l.SetStdin(in.stdin)
+	in.l.SetStdout(stdout)
+	in.l.SetStderr(stderr)
+	if o.version {
+		in.printVersion()
+	}
+	if o.noEnv {
+		in.l.PushBoolean(true) // signal for libraries to ignore environment variables
+		in.l.SetField(lua.RegistryIndex, "LUA_NOENV")
+	}
+	lua.OpenLibraries(in.l)
+	if !o.noEnv && !in.handleInit() {
+		return 1
+	}
+	end := len(args)
+	if o.script > 0 {
+		end = o.script
+	}
+	if !in.runArgs(args[:end]) {
+		return 1
+	}
+	if o.script > 0 && !in.handleScript(args, o.script) {
+		return 1
+	}
+	if o.interactive {
+		in.repl()
+	} else if o.script == 0 && !o.execute && !o.version {
+		if in.tty {
+			in.printVersion()
+			in.repl()
+		} else if !in.report(lua.LoadFile(in.l, "", ""), true) {
+			return 1
+		}
+	}
+	return 0
+}
+
+func (in *interpreter) printUsage(badOption string) {
+	if badOption[1] == 'e' || badOption[1] == 'l' {
+		fmt.Fprintf(in.stderr, "%s: '%s' needs argument\n", in.progName, badOption)
+	} else {
+		fmt.Fprintf(in.stderr, "%s: unrecognized option '%s'\n", in.progName, badOption)
+	}
+	fmt.Fprintf(in.stderr, usage, in.progName)
+}
+
+func (in *interpreter) printVersion() {
+	fmt.Fprintln(in.stdout, lua.VersionString+" (go-lua)  Copyright (C) 1994-2015 Lua.org, PUC-Rio")
+}
+
+// message prints msg to standard error, prefixed with the program name
+// unless running interactively.
+func (in *interpreter) message(msg string) {
+	if in.progName != "" {
+		fmt.Fprintf(in.stderr, "%s: ", in.progName)
+	}
+	fmt.Fprintln(in.stderr, msg)
+}
+
+// report prints the error message on the top of the stack if err is not nil,
+// and otherwise calls the loaded chunk if call is true. It returns whether
+// there was no error.
+func (in *interpreter) report(err error, call bool) bool {
+	if err == nil && call {
+		err = in.call(0, 0)
+	}
+	if err != nil {
+		msg, ok := in.l.ToString(-1)
+		if !ok {
+			msg = "(error object is not a string)"
+		}
+		in.message(msg)
+		in.l.Pop(1)
+	}
+	return err == nil
+}
+
+// traceback is the message handler of calls, adding a traceback to string
+// messages.
+func traceback(l *lua.State) int {
+	if msg, ok := l.ToString(1); ok {
+		lua.Traceback(l, l, msg, 1)
+	} else if !l.IsNoneOrNil(1) && !lua.CallMeta(l, 1, "__tostring") {
+		l.PushString("(no error message)")
+	}
+	return 1
+}
+
+// call calls the function below its args arguments with the traceback
+// message handler.
+func (in *interpreter) call(args, results int) error {
+	base := in.l.Top() - args
+	in.l.PushGoFunction(traceback)
+	in.l.Insert(base)
+	err := in.l.ProtectedCall(args, results, base)
+	in.l.Remove(base)
+	return err
+}
+
+func (in *interpreter) handleInit() bool {
+	name := "=LUA_INIT_5_2"
+	init, ok := os.LookupEnv("LUA_INIT_5_2")
+	if !ok {
+		name = "=LUA_INIT"
+		if init, ok = os.LookupEnv("LUA_INIT"); !ok {
+			return true
+		}
+	}
+	if strings.HasPrefix(init, "@") {
+		return in.report(lua.LoadFile(in.l, init[1:], ""), true)
+	}
+	return in.report(lua.LoadBuffer(in.l, init, name, ""), true)
+}
+
+// runArgs runs the -e and -l options in args, which end before the script.
+func (in *interpreter) runArgs(args []string) bool {
+	for i := 1; i < len(args); i++ {
+		if len(args[i]) < 2 || (args[i][1] != 'e' && args[i][1] != 'l') {
+			continue
+		}
+		option, arg := args[i][1], args[i][2:]
+		if arg == "" {
+			i++
+			arg = args[i]
+		}
+		if option == 'e' {
+			if !in.report(lua.LoadBuffer(in.l, arg, "=(command line)", ""), true) {
+				return false
+			}
+		} else {
+			in.l.Global("require")
+			in.l.PushString(arg)
+			if err := in.call(1, 1); !in.report(err, false) {
+				return false
+			}
+			in.l.SetGlobal(arg)
+		}
+	}
+	return true
+}
+
+// handleScript sets the global arg to the command line arguments, indexed
+// relative to the script, then runs the script with the arguments following
+// it.
+func (in *interpreter) handleScript(args []string, script int) bool {
+	in.l.CreateTable(len(args)-script-1, script+1)
+	for i, arg := range args {
+		in.l.PushString(arg)
+		in.l.RawSetInt(-2, i-script)
+	}
+	in.l.SetGlobal("arg")
+	name := args[script]
+	if name == "-" && args[script-1] != "--" {
+		name = "" // stdin
+	}
+	if err := lua.LoadFile(in.l, name, ""); err != nil {
+		return in.report(err, false)
+	}
+	for _, arg := range args[script+1:] {
+		in.l.PushString(arg)
+	}
+	return in.report(in.call(len(args)-script-1, 0), false)
+}
+
+// readLine prompts for and reads a line of input, without its end of line.
+func (in *interpreter) readLine(first bool) (string, bool) {
+	p := prompt
+	if !first {
+		p = prompt2
+	}
+	if first {
+		in.l.Global("_PROMPT")
+	} else {
+		in.l.Global("_PROMPT2")
+	}
+	if s, ok := in.l.ToString(-1); ok {
+		p = s
+	}
+	in.l.Pop(1)
+	fmt.Fprint(in.stdout, p)
+	line, err := in.stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", false
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), true
+}
+
+// incomplete reports whether err is a syntax error caused by the input
+// ending early, popping its message if so.
+func (in *interpreter) incomplete(err error) bool {
+	if err == lua.SyntaxError {
+		if msg, _ := in.l.ToString(-1); strings.HasSuffix(msg, eofMark) || strings.HasSuffix(msg, "'"+eofMark+"'") {
+			in.l.Pop(1)
+			return true
+		}
+	}
+	return false
+}
+
+// loadLine reads a statement, or an expression to evaluate, over as many
+// lines as needed, and loads it. It returns false at the end of input.
+func (in *interpreter) loadLine() (bool, error) {
+	in.l.SetTop(0)
+	line, ok := in.readLine(true)
+	if !ok {
+		return false, nil
+	}
+	if strings.HasPrefix(line, "=") {
+		line = "return " + line[1:]
+	} else if err := lua.LoadBuffer(in.l, "return "+line, "=stdin", ""); err == nil {
+		return true, nil
+	} else {
+		in.l.Pop(1)
+	}
+	for {
+		err := lua.LoadBuffer(in.l, line, "=stdin", "")
+		if !in.incomplete(err) {
+			return true, err
+		}
+		more, ok := in.readLine(false)
+		if !ok {
+			return false, nil
+		}
+		line += "\n" + more
+	}
+}
+
+// repl reads, evaluates and prints lines of input until its end.
+func (in *interpreter) repl() {
+	progName := in.progName
+	in.progName = ""
+	for ok, err := in.loadLine(); ok; ok, err = in.loadLine() {
+		if err == nil {
+			err = in.call(0, lua.MultipleReturns)
+		}
+		if in.report(err, false) && in.l.Top() > 0 { // any result to print?
+			lua.CheckStackWithMessage(in.l, lua.MinStack, "too many results to print")
+			in.l.Global("print")
+			in.l.Insert(1)
+			if in.l.ProtectedCall(in.l.Top()-1, 0, 0) != nil {
+				msg, _ := in.l.ToString(-1)
+				in.message(fmt.Sprintf("error calling 'print' (%s)", msg))
+			}
+		}
+	}
+	in.l.SetTop(0)
+	fmt.Fprintln(in.stdout)
+	in.progName = progName
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func runLua(t *testing.T, stdin string, args ...string) (status int, stdout, stderr string) {
+	t.Helper()
+	var out, err bytes.Buffer
+	status = run(append([]string{"lua"}, args...), strings.NewReader(stdin), &out, &err, false)
+	return status, out.String(), err.String()
+}
+
+func TestREPL(t *testing.T) {
+	input := "x = 20\n= x + 1\nx * 2\nfor i = 1, 2 do\nprint(i)\nend\nerror('boom')\n_PROMPT = '$ '\n"
+	status, stdout, stderr := runLua(t, input, "-i")
+	if status != 0 {
+		t.Fatalf("unexpected status %d: %s", status, stderr)
+	}
+	expected := "> > 21\n> 40\n> >> >> 1\n2\n> > $ \n"
+	if !strings.HasSuffix(stdout, expected) {
+		t.Errorf("expected output ending in %q but found %q", expected, stdout)
+	}
+	if !strings.HasPrefix(stdout, "Lua 5.2") {
+		t.Errorf("expected version in %q", stdout)
+	}
+	if !strings.HasPrefix(stderr, "stdin:1: boom\nstack traceback:\n") {
+		t.Errorf("unexpected error output %q", stderr)
+	}
+}
+
+func TestScript(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "script.lua")
+	if err := os.WriteFile(script, []byte("print(prefix, arg[0] == ..., arg[-1], select('#', ...), ...)"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LUA_INIT", "prefix = 'init'")
+	status, stdout, stderr := runLua(t, "", "-e", "x = 1", script, script, "b")
+	if status != 0 || stdout != "init\ttrue\tx = 1\t2\t"+script+"\tb\n" {
+		t.Errorf("unexpected result %d %q %q", status, stdout, stderr)
+	}
+	if _, stdout, _ = runLua(t, "", "-E", script); !strings.HasPrefix(stdout, "nil\t") {
+		t.Errorf("expected -E to ignore LUA_INIT, found %q", stdout)
+	}
+	if _, stdout, _ = runLua(t, "print('stdin', ...)", "-", "a"); stdout != "stdin\ta\n" {
+		t.Errorf("unexpected output %q", stdout)
+	}
+	if _, stdout, _ = runLua(t, "print('piped')"); stdout != "piped\n" {
+		t.Errorf("unexpected output %q", stdout)
+	}
+	if _, stdout, _ = runLua(t, "", "-lstring", "-e", "print(string == require('string'))"); stdout != "true\n" {
+		t.Errorf("unexpected output %q", stdout)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, c := range []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"-x"}, "lua: unrecognized option '-x'\nusage: lua [options] [script [args]]\n"},
+		{[]string{"-e"}, "lua: '-e' needs argument\n"},
+		{[]string{"-e", "x ="}, "lua: (command line):1: unexpected symbol near <eof>\n"},
+		{[]string{"-e", "error({})"}, "lua: (no error message)\n"},
+		{[]string{"-e", "error('boom')"}, "lua: (command line):1: boom\nstack traceback:\n\t[Go]: in function 'error'\n"},
+		{[]string{"missing.lua"}, "lua: cannot open missing.lua\n"},
+	} {
+		status, _, stderr := runLua(t, "", c.args...)
+		if status != 1 || !strings.HasPrefix(stderr, c.expected) {
+			t.Errorf("%v: expected error %q but found %d %q", c.args, c.expected, status, stderr)
+		}
+	}
+}
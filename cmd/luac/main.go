@@ -0,0 +1,197 @@
+// Command luac is the go-lua compiler, mirroring the luac program of the
+// reference implementation:
+//
+//	usage: luac [options] [filenames]
+//	Available options are:
+//	  -l       list
+//	  -o name  output to file 'name' (default is "luac.out")
+//	  -p       parse only
+//	  -s       strip debug information
+//	  -v       show version information
+//	  --       stop handling options
+//	  -        stop handling options and process stdin
+//
+// Given -l twice, the listing includes the constants, locals and up values of
+// each function. Unlike the reference luac, which combines several files into
+// a single chunk, luac only lists or parses several files, and writes a
+// binary chunk for one file only.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/hoxbio/go-lua"
+)
+
+const (
+	output = "luac.out" // default output file
+	usage  = `usage: %s [options] [filenames]
+Available options are:
+  -l       list
+  -o name  output to file 'name' (default is "%s")
+  -p       parse only
+  -s       strip debug information
+  -v       show version information
+  --       stop handling options
+  -        stop handling options and process stdin
+`
+)
+
+type compiler struct {
+	progName           string
+	output             string // output file, or "" for stdout
+	listing            int
+	dumping, stripping bool
+	stdin              io.Reader
+	stdout, stderr     io.Writer
+	version            int
+}
+
+// errUsage reports a bad command line, after which the usage is printed.
+type errUsage string
+
+func (e errUsage) Error() string { return string(e) }
+
+func main() {
+	os.Exit(run(os.Args, os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	c := &compiler{progName: "luac", output: output, dumping: true, stdin: stdin, stdout: stdout, stderr: stderr}
+	if len(args) > 0 && args[0] != "" {
+		c.progName = args[0]
+	}
+	files, err := c.parseArgs(args)
+	if err == nil && files == nil {
+		return 0
+	}
+	if err == nil {
+		err = c.compile(files)
+	}
+	if u, ok := err.(errUsage); ok {
+		if u[0] == '-' {
+			fmt.Fprintf(stderr, "%s: unrecognized option '%s'\n", c.progName, u)
+		} else {
+			fmt.Fprintf(stderr, "%s: %s\n", c.progName, u)
+		}
+		fmt.Fprintf(stderr, usage, c.progName, output)
+		return 1
+	} else if err != nil {
+		fmt.Fprintf(stderr, "%s: %s\n", c.progName, err)
+		return 1
+	}
+	return 0
+}
+
+// parseArgs handles the options in args, returning the files to compile, or
+// nil if there is nothing to do after showing the version.
+func (c *compiler) parseArgs(args []string) ([]string, error) {
+	i := 1
+	for ; i < len(args); i++ {
+		arg := args[i]
+		if arg == "" || arg[0] != '-' || arg == "-" { // end of options; keep it
+			break
+		} else if arg == "--" { // end of options; skip it
+			i++
+			if c.version > 0 {
+				c.version++
+			}
+			break
+		}
+		switch arg {
+		case "-l":
+			c.listing++
+		case "-o":
+			if i++; i >= len(args) || args[i] == "" || (args[i][0] == '-' && args[i] != "-") {
+				return nil, errUsage("'-o' needs argument")
+			}
+			if c.output = args[i]; c.output == "-" {
+				c.output = ""
+			}
+		case "-p":
+			c.dumping = false
+		case "-s":
+			c.stripping = true
+		case "-v":
+			c.version++
+		default:
+			return nil, errUsage(arg)
+		}
+	}
+	files := args[i:]
+	if len(files) == 0 && (c.listing > 0 || !c.dumping) {
+		c.dumping = false
+		files = []string{output}
+	}
+	if c.version > 0 {
+		fmt.Fprintln(c.stdout, lua.VersionString+" (go-lua)  Copyright (C) 1994-2015 Lua.org, PUC-Rio")
+		if c.version == len(args)-1 {
+			return nil, nil
+		}
+	}
+	if len(files) == 0 {
+		return nil, errUsage("no input files given")
+	}
+	return files, nil
+}
+
+func (c *compiler) compile(files []string) error {
+	if c.dumping && len(files) > 1 {
+		return fmt.Errorf("cannot combine %d files into one chunk; compile them separately", len(files))
+	}
+	l := lua.NewState()
+	l.SetStdin(c.stdin)
+	for _, file := range files {
+		if file == "-" {
+			file = "" // stdin
+		}
+		if err := lua.LoadFile(l, file, ""); err != nil {
+			msg, _ := l.ToString(-1)
+			return fmt.Errorf("%s", msg)
+		}
+		if c.listing > 0 {
+			if err := l.List(c.stdout, c.listing > 1); err != nil {
+				return err
+			}
+		}
+	}
+	if c.dumping {
+		return c.dump(l)
+	}
+	return nil
+}
+
+// dump writes the binary chunk of the function on the top of the stack to
+// the output file.
+func (c *compiler) dump(l *lua.State) error {
+	dump := l.Dump
+	if c.stripping {
+		dump = l.DumpStripped
+	}
+	if c.output == "" {
+		w := bufio.NewWriter(c.stdout)
+		if err := dump(w); err != nil {
+			return fmt.Errorf("cannot write (stdout): %s", err)
+		}
+		return w.Flush()
+	}
+	f, err := os.Create(c.output)
+	if err != nil {
+		return fmt.Errorf("cannot %s", err)
+	}
+	w := bufio.NewWriter(f)
+	if err = dump(w); err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("cannot write %s: %s", c.output, err)
+	}
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("cannot close %s: %s", c.output, err)
+	}
+	return nil
+}
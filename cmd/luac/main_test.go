@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hoxbio/go-lua"
+)
+
+func runLuac(t *testing.T, stdin string, args ...string) (status int, stdout, stderr string) {
+	t.Helper()
+	var out, err bytes.Buffer
+	status = run(append([]string{"luac"}, args...), strings.NewReader(stdin), &out, &err)
+	return status, out.String(), err.String()
+}
+
+func TestCompile(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "fib.out")
+	for _, strip := range []bool{false, true} {
+		args := []string{"-o", output, filepath.Join("..", "..", "fixtures", "fib.lua")}
+		if strip {
+			args = append([]string{"-s"}, args...)
+		}
+		if status, _, stderr := runLuac(t, "", args...); status != 0 {
+			t.Fatalf("unexpected status %d: %s", status, stderr)
+		}
+		l := lua.NewState()
+		lua.OpenLibraries(l)
+		var b bytes.Buffer
+		l.SetStdout(&b)
+		if err := lua.LoadFile(l, output, "b"); err != nil {
+			t.Fatalf("error: %s", err)
+		}
+		l.Call(0, 0)
+		if b.String() != "6765\n6765\n6765\n" {
+			t.Errorf("unexpected output %q", b.String())
+		}
+		l.Global("fib")
+		if d, _ := lua.Info(l, ">S", nil); (d.Source == "=?") != strip {
+			t.Errorf("unexpected source %q when strip is %v", d.Source, strip)
+		}
+	}
+	if status, stdout, _ := runLuac(t, "return 1", "-o", "-", "-"); status != 0 || !strings.HasPrefix(stdout, lua.Signature) {
+		t.Errorf("expected a binary chunk on stdout, found %d %q", status, stdout)
+	}
+	if _, err := os.Stat("luac.out"); err == nil {
+		t.Error("expected no luac.out in the working directory")
+	}
+}
+
+func TestListing(t *testing.T) {
+	status, stdout, stderr := runLuac(t, "local t = {'a'}\nfunction f(x) return x + t[1] * 2 end", "-l", "-l", "-p", "-")
+	if status != 0 {
+		t.Fatalf("unexpected status %d: %s", status, stderr)
+	}
+	for _, s := range []string{
+		"\nmain <stdin:0,0> (6 instructions at ",
+		"0+ params, 2 slots, 1 upvalue, 1 local, 2 constants, 1 function\n",
+		"\t1\t[1]\tNEWTABLE \t0 1 0\n",
+		"\t2\t[1]\tLOADK    \t1 -1\t; \"a\"\n",
+		"\t5\t[2]\tSETTABUP \t0 -2 1\t; _ENV \"f\"\n",
+		"constants (2) for ",
+		"\t2\t\"f\"\n",
+		"locals (1) for ",
+		"\t0\tt\t4\t7\n",
+		"upvalues (1) for ",
+		"\t0\t_ENV\t1\t0\n",
+		"\nfunction <stdin:2,2> (5 instructions at ",
+		"1 param, 2 slots, 1 upvalue, 1 local, 2 constants, 0 functions\n",
+		"\tGETTABUP \t1 0 -1\t; t 1\n",
+		"\tMUL      \t1 1 -2\t; - 2\n",
+		"\t0\tt\t1\t0\n",
+	} {
+		if !strings.Contains(stdout, s) {
+			t.Errorf("expected %q in listing:\n%s", s, stdout)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, c := range []struct {
+		args     []string
+		expected string
+	}{
+		{nil, "luac: no input files given\nusage: luac [options] [filenames]\n"},
+		{[]string{"-x"}, "luac: unrecognized option '-x'\n"},
+		{[]string{"-o"}, "luac: '-o' needs argument\n"},
+		{[]string{"-p", "missing.lua"}, "luac: cannot open missing.lua\n"},
+		{[]string{"-p", "-"}, "luac: stdin:1: unexpected symbol near +\n"},
+		{[]string{"a.lua", "b.lua"}, "luac: cannot combine 2 files into one chunk"},
+	} {
+		status, _, stderr := runLuac(t, "return +", c.args...)
+		if status != 1 || !strings.HasPrefix(stderr, c.expected) {
+			t.Errorf("%v: expected error %q but found %d %q", c.args, c.expected, status, stderr)
+		}
+	}
+}
@@ -9,6 +9,7 @@ const (
 	oprMinus = iota
 	oprNot
 	oprLength
+	oprBitwiseNot
 	oprNoUnary
 )
 
@@ -25,6 +26,12 @@ const (
 	oprDiv
 	oprMod
 	oprPow
+	oprFloorDivide
+	oprBitwiseAnd
+	oprBitwiseOr
+	oprBitwiseXor
+	oprShiftLeft
+	oprShiftRight
 	oprConcat
 	oprEq
 	oprLT
@@ -43,7 +50,7 @@ const (
 	kindTrue
 	kindFalse
 	kindConstant       // info = index of constant
-	kindNumber         // value = numerical value
+	kindNumber         // value = numerical value, a float64 or an int64
 	kindNonRelocatable // info = result register
 	kindLocal          // info = local register
 	kindUpValue        // info = index of upvalue
@@ -78,7 +85,7 @@ type exprDesc struct {
 	tableType int // whether 'table' is register (kindLocal) or upvalue (kindUpValue)
 	info      int
 	t, f      int // patch lists for 'exit when true/false'
-	value     float64
+	value     value
 }
 
 type assignmentTarget struct {
@@ -97,6 +104,7 @@ type block struct {
 	firstLabel, firstGoto int
 	activeVariableCount   int
 	hasUpValue, isLoop    bool
+	insideToBeClosed      bool // in the scope of a <close> variable
 }
 
 type function struct {
@@ -129,6 +137,7 @@ func (f *function) CloseFunction() exprDesc {
 func (f *function) EnterBlock(isLoop bool) {
 	// TODO www.lua.org uses a trick here to stack allocate the block, and chain blocks in the stack
 	f.block = &block{previous: f.block, firstLabel: len(f.p.activeLabels), firstGoto: len(f.p.pendingGotos), activeVariableCount: f.activeVariableCount, isLoop: isLoop}
+	f.block.insideToBeClosed = f.block.previous != nil && f.block.previous.insideToBeClosed
 	f.assert(f.freeRegisterCount == f.activeVariableCount)
 }
 
@@ -166,6 +175,30 @@ func (f *function) MakeLocalVariable(name string) {
 	f.p.activeVariables = append(f.p.activeVariables, r)
 }
 
+// MarkToBeClosed marks the active local variable level as a <close>
+// variable, to be closed when its block is left.
+func (f *function) MarkToBeClosed(level int) {
+	f.block.hasUpValue, f.block.insideToBeClosed = true, true
+	f.EncodeABC(opToBeClosed, level, 0, 0)
+}
+
+func (f *function) checkReadOnly(v exprDesc) {
+	var name string
+	switch v.kind {
+	case kindLocal:
+		if l := f.LocalVariable(v.info); l.readOnly {
+			name = l.name
+		}
+	case kindUpValue:
+		if u := f.f.upValues[v.info]; u.readOnly {
+			name = u.name
+		}
+	}
+	if name != "" {
+		f.semanticError(fmt.Sprintf("attempt to assign to const variable '%s'", name))
+	}
+}
+
 func (f *function) MakeGoto(name string, line, pc int) {
 	f.p.pendingGotos = append(f.p.pendingGotos, label{name: name, line: line, pc: pc, activeVariableCount: f.activeVariableCount})
 	f.findLabel(len(f.p.pendingGotos) - 1)
@@ -379,7 +412,7 @@ func (f *function) SetMultipleReturns(e exprDesc) { f.setReturns(e, MultipleRetu
 
 func (f *function) Return(e exprDesc, resultCount int) {
 	if e.hasMultipleReturns() {
-		if f.SetMultipleReturns(e); e.kind == kindCall && resultCount == 1 {
+		if f.SetMultipleReturns(e); e.kind == kindCall && resultCount == 1 && !f.block.insideToBeClosed {
 			f.Instruction(e).setOpCode(opTailCall)
 			f.assert(f.Instruction(e).a() == f.activeVariableCount)
 		}
@@ -537,9 +570,9 @@ func (f *function) addConstant(k, v value) int {
 	return index
 }
 
-func (f *function) NumberConstant(n float64) int {
-	if n == 0.0 || math.IsNaN(n) {
-		return f.addConstant(math.Float64bits(n), n)
+func (f *function) NumberConstant(n value) int {
+	if x, ok := n.(float64); ok && (x == 0.0 || math.IsNaN(x)) {
+		return f.addConstant(math.Float64bits(x), n)
 	}
 	return f.addConstant(n, n)
 }
@@ -732,6 +765,7 @@ func (f *function) expressionToRegisterOrConstant(e exprDesc) (exprDesc, int) {
 }
 
 func (f *function) StoreVariable(v, e exprDesc) {
+	f.checkReadOnly(v)
 	switch v.kind {
 	case kindLocal:
 		f.freeExpression(e)
@@ -850,22 +884,37 @@ func (f *function) Indexed(t, k exprDesc) (r exprDesc) {
 	return
 }
 
-func foldConstants(op opCode, e1, e2 exprDesc) (exprDesc, bool) {
+func arithOperator(op opCode) Operator {
+	if op >= opFloorDivide {
+		return Operator(op-opFloorDivide) + OpFloorDivide
+	}
+	return Operator(op-opAdd) + OpAdd
+}
+
+func (f *function) foldConstants(op opCode, e1, e2 exprDesc) (exprDesc, bool) {
 	if !e1.isNumeral() || !e2.isNumeral() {
 		return e1, false
-	} else if (op == opDiv || op == opMod) && e2.value == 0.0 {
+	} else if (op == opDiv || op == opMod || op == opFloorDivide) && toFloat(e2.value) == 0.0 {
 		return e1, false
+	} else if o := arithOperator(op); isBitwise(o) {
+		i1, ok1 := numberToInteger(e1.value)
+		i2, ok2 := numberToInteger(e2.value)
+		if !ok1 || !ok2 {
+			return e1, false // leave the error to run time
+		}
+		e1.value = f.p.integer(integerArith(o, i1, i2))
+		return e1, true
 	}
-	e1.value = arith(Operator(op-opAdd)+OpAdd, e1.value, e2.value)
+	e1.value = numberArith(arithOperator(op), e1.value, e2.value)
 	return e1, true
 }
 
 func (f *function) encodeArithmetic(op opCode, e1, e2 exprDesc, line int) exprDesc {
-	if e, folded := foldConstants(op, e1, e2); folded {
+	if e, folded := f.foldConstants(op, e1, e2); folded {
 		return e
 	}
 	o2 := 0
-	if op != opUnaryMinus && op != opLength {
+	if op != opUnaryMinus && op != opLength && op != opBitwiseNot {
 		e2, o2 = f.expressionToRegisterOrConstant(e2)
 	}
 	e1, o1 := f.expressionToRegisterOrConstant(e1)
@@ -885,7 +934,7 @@ func (f *function) Prefix(op int, e exprDesc, line int) exprDesc {
 	switch op {
 	case oprMinus:
 		if e.isNumeral() {
-			e.value = -e.value
+			e.value = numberArith(OpUnaryMinus, e.value, e.value)
 			return e
 		}
 		return f.encodeArithmetic(opUnaryMinus, f.ExpressionToAnyRegister(e), makeExpression(kindNumber, 0), line)
@@ -893,6 +942,11 @@ func (f *function) Prefix(op int, e exprDesc, line int) exprDesc {
 		return f.encodeNot(e)
 	case oprLength:
 		return f.encodeArithmetic(opLength, f.ExpressionToAnyRegister(e), makeExpression(kindNumber, 0), line)
+	case oprBitwiseNot:
+		if e, folded := f.foldConstants(opBitwiseNot, e, e); folded {
+			return e
+		}
+		return f.encodeArithmetic(opBitwiseNot, f.ExpressionToAnyRegister(e), makeExpression(kindNumber, 0), line)
 	}
 	panic("unreachable")
 }
@@ -905,7 +959,8 @@ func (f *function) Infix(op int, e exprDesc) exprDesc {
 		e = f.GoIfFalse(e)
 	case oprConcat:
 		e = f.ExpressionToNextRegister(e)
-	case oprAdd, oprSub, oprMul, oprDiv, oprMod, oprPow:
+	case oprAdd, oprSub, oprMul, oprDiv, oprMod, oprPow, oprFloorDivide,
+		oprBitwiseAnd, oprBitwiseOr, oprBitwiseXor, oprShiftLeft, oprShiftRight:
 		if !e.isNumeral() {
 			e, _ = f.expressionToRegisterOrConstant(e)
 		}
@@ -948,6 +1003,8 @@ func (f *function) Postfix(op int, e1, e2 exprDesc, line int) exprDesc {
 		return f.encodeArithmetic(opConcat, e1, f.ExpressionToNextRegister(e2), line)
 	case oprAdd, oprSub, oprMul, oprDiv, oprMod, oprPow:
 		return f.encodeArithmetic(opCode(op-oprAdd)+opAdd, e1, e2, line)
+	case oprFloorDivide, oprBitwiseAnd, oprBitwiseOr, oprBitwiseXor, oprShiftLeft, oprShiftRight:
+		return f.encodeArithmetic(opCode(op-oprFloorDivide)+opFloorDivide, e1, e2, line)
 	case oprEq, oprLT, oprLE:
 		return f.encodeComparison(opCode(op-oprEq)+opEqual, 1, e1, e2)
 	case oprNE, oprGT, oprGE:
@@ -1019,7 +1076,15 @@ func (f *function) AdjustAssignment(variableCount, expressionCount int, e exprDe
 
 func (f *function) makeUpValue(name string, e exprDesc) int {
 	f.p.checkLimit(len(f.f.upValues)+1, maxUpValue, "upvalues")
-	f.f.upValues = append(f.f.upValues, upValueDesc{name: name, isLocal: e.kind == kindLocal, index: e.info})
+	u := upValueDesc{name: name, isLocal: e.kind == kindLocal, index: e.info}
+	if f.previous == nil { // _ENV of the main function
+		u.readOnly = false
+	} else if u.isLocal {
+		u.readOnly = f.previous.LocalVariable(e.info).readOnly
+	} else {
+		u.readOnly = f.previous.f.upValues[e.info].readOnly
+	}
+	f.f.upValues = append(f.f.upValues, u)
 	return len(f.f.upValues) - 1
 }
 
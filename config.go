@@ -15,6 +15,18 @@ const (
 	apiCheck          = false
 	internalCheck     = false
 	pathListSeparator = ';'
+	maxCaptures       = 32
 )
 
-var defaultPath = "./?.lua" // TODO "${LUA_LDIR}?.lua;${LUA_LDIR}?/init.lua;./?.lua"
+const (
+	luaRoot = "/usr/local/"
+	luaLDir = luaRoot + "share/lua/5.2/"
+	luaCDir = luaRoot + "lib/lua/5.2/"
+)
+
+// DefaultPath is the search path used by require for Lua modules, unless it
+// is overridden by PackageOptions or the LUA_PATH_5_2 and LUA_PATH
+// environment variables.
+const DefaultPath = luaLDir + "?.lua;" + luaLDir + "?/init.lua;" +
+	luaCDir + "?.lua;" + luaCDir + "?/init.lua;" +
+	"./?.lua;./?/init.lua"
@@ -0,0 +1,107 @@
+package lua
+
+func getCoroutine(l *State) *State {
+	co := l.ToThread(1)
+	ArgumentCheck(l, co != nil, 1, "coroutine expected")
+	return co
+}
+
+func resumeHelper(l, co *State, argCount int) int {
+	if !co.CheckStack(argCount) {
+		l.PushString("too many arguments to resume")
+		return -1 // error flag
+	}
+	if !co.shouldYield && co.error == nil && co.Top() == 0 {
+		l.PushString("cannot resume dead coroutine")
+		return -1 // error flag
+	}
+	XMove(l, co, argCount)
+	if _, err := co.resumeFrom(l, argCount); err != nil {
+		XMove(co, l, 1) // move error message
+		if b := l.global.budget; b != nil && b.err != nil {
+			l.throw(b.err) // an exhausted budget stops the resumer too
+		}
+		return -1 // error flag
+	}
+	resultCount := co.Top()
+	if !l.CheckStack(resultCount + 1) {
+		co.Pop(resultCount) // remove results anyway
+		l.PushString("too many results to resume")
+		return -1 // error flag
+	}
+	XMove(co, l, resultCount) // move yielded values
+	return resultCount
+}
+
+func createCoroutine(l *State) int {
+	CheckType(l, 1, TypeFunction)
+	co := l.NewThread()
+	l.PushValue(1)  // move function to top
+	XMove(l, co, 1) // move function from l to co
+	return 1
+}
+
+var coroutineLibrary = []RegistryFunction{
+	{"create", createCoroutine},
+	{"resume", func(l *State) int {
+		co := getCoroutine(l)
+		r := resumeHelper(l, co, l.Top()-1)
+		if r < 0 {
+			l.PushBoolean(false)
+			l.Insert(-2)
+			return 2 // return false + error message
+		}
+		l.PushBoolean(true)
+		l.Insert(-(r + 1))
+		return r + 1 // return true + 'resume' returns
+	}},
+	{"running", func(l *State) int {
+		isMain := l.PushThread()
+		l.PushBoolean(isMain)
+		return 2
+	}},
+	{"status", func(l *State) int {
+		co := getCoroutine(l)
+		switch {
+		case l == co:
+			l.PushString("running")
+		case co.shouldYield:
+			l.PushString("suspended")
+		case co.error != nil: // some error occurred
+			l.PushString("dead")
+		default:
+			if _, ok := Stack(co, 0); ok { // does it have frames?
+				l.PushString("normal") // it is running
+			} else if co.Top() == 0 {
+				l.PushString("dead")
+			} else {
+				l.PushString("suspended") // initial state
+			}
+		}
+		return 1
+	}},
+	{"wrap", func(l *State) int {
+		createCoroutine(l)
+		l.PushGoClosure(func(l *State) int {
+			co := l.ToThread(UpValueIndex(1))
+			r := resumeHelper(l, co, l.Top())
+			if r < 0 {
+				if l.IsString(-1) { // error object is a string?
+					Where(l, 1) // get extra info
+					l.Insert(-2)
+					l.Concat(2)
+				}
+				l.Error() // propagate error
+			}
+			return r
+		}, 1)
+		return 1
+	}},
+	{"yield", func(l *State) int { return l.Yield(l.Top()) }},
+}
+
+// CoroutineOpen opens the coroutine library. Usually passed to Require.
+func CoroutineOpen(l *State) int {
+	NewLibrary(l, coroutineLibrary)
+	return 1
+}
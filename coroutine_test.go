@@ -0,0 +1,159 @@
+package lua
+
+import "testing"
+
+func TestCoroutineGenerator(t *testing.T) {
+	testString(t, `
+	local function range(n)
+		return coroutine.wrap(function() for i = 1, n do coroutine.yield(i) end end)
+	end
+	local sum = 0
+	for i in range(10) do sum = sum + i end
+	assert(sum == 55, 'got ' .. sum .. '; want 55')
+	`)
+}
+
+func TestCoroutineResumeAndStatus(t *testing.T) {
+	testString(t, `
+	local co = coroutine.create(function(a, b)
+		assert(coroutine.status(coroutine.running()) == "running")
+		local c = coroutine.yield(a + b)
+		local d, e = coroutine.yield(c * 2)
+		return d + e
+	end)
+	assert(coroutine.status(co) == "suspended")
+	local ok, v = coroutine.resume(co, 1, 2)
+	assert(ok and v == 3)
+	ok, v = coroutine.resume(co, 10)
+	assert(ok and v == 20)
+	ok, v = coroutine.resume(co, 3, 4)
+	assert(ok and v == 7)
+	assert(coroutine.status(co) == "dead")
+	ok, v = coroutine.resume(co)
+	assert(not ok and v == "cannot resume dead coroutine")
+
+	co = coroutine.create(function() error("boom") end)
+	ok, v = coroutine.resume(co)
+	assert(not ok and v:find("boom"))
+	assert(coroutine.status(co) == "dead")
+
+	local main, isMain = coroutine.running()
+	assert(type(main) == "thread" and isMain)
+	`)
+}
+
+func TestCoroutineYieldInsideProtectedCall(t *testing.T) {
+	testString(t, `
+	local co = coroutine.wrap(function()
+		local ok, err = pcall(function() error("e" .. coroutine.yield(1)) end)
+		assert(not ok and err:find("e5"), err)
+		local ok, v = pcall(function() return coroutine.yield(2) end)
+		assert(ok and v == 9)
+		return "done"
+	end)
+	assert(co() == 1)
+	assert(co(5) == 2)
+	assert(co(9) == "done")
+	`)
+}
+
+func TestCoroutineYieldInsideMetamethods(t *testing.T) {
+	testString(t, `
+	local mt = {
+		__add = function(a, b) return coroutine.yield("add") end,
+		__index = function(t, k) return coroutine.yield("index") end,
+		__lt = function(a, b) return coroutine.yield("lt") end,
+		__concat = function(a, b) return coroutine.yield("concat") end,
+	}
+	local co = coroutine.wrap(function()
+		local t = setmetatable({}, mt)
+		return t + 1, t.foo, t < t, "a" .. "b" .. t .. "c"
+	end)
+	assert(co() == "add")
+	assert(co(10) == "index")
+	assert(co(20) == "lt")
+	assert(co(false) == "concat")
+	local a, b, c, d = co("x")
+	assert(a == 10 and b == 20 and c == false and d == "abx")
+	`)
+}
+
+func TestCoroutineYieldErrors(t *testing.T) {
+	testString(t, `
+	local ok, err = pcall(coroutine.yield, 1)
+	assert(not ok and err:find("outside a coroutine"), err)
+	local co = coroutine.create(function()
+		table.sort({3, 2, 1}, function(a, b) coroutine.yield() return a < b end)
+	end)
+	ok, err = coroutine.resume(co)
+	assert(not ok and err:find("Go-call boundary", 1, true), err)
+	`)
+}
+
+func TestResumeWithContinuation(t *testing.T) {
+	l := NewState()
+	OpenLibraries(l)
+	l.Register("produce", func(l *State) int {
+		l.PushString("produced")
+		return l.YieldWithContinuation(1, 7, func(l *State) int {
+			context, shouldYield, _ := l.Context()
+			l.PushInteger(context)
+			l.PushBoolean(shouldYield)
+			return 3
+		})
+	})
+	co := l.NewThread()
+	if err := LoadString(co, "return produce()"); err != nil {
+		t.Fatal(err)
+	}
+	if shouldYield, err := co.Resume(l, 0); err != nil || !shouldYield {
+		t.Fatalf("Resume() = %t, %v; want true, nil", shouldYield, err)
+	}
+	if s, _ := co.ToString(-1); s != "produced" {
+		t.Errorf("yielded %q; want %q", s, "produced")
+	}
+	co.Pop(1)
+	co.PushString("consumed")
+	if shouldYield, err := co.Resume(l, 1); err != nil || shouldYield {
+		t.Fatalf("Resume() = %t, %v; want false, nil", shouldYield, err)
+	}
+	if n := co.Top(); n != 3 {
+		t.Fatalf("got %d results; want 3", n)
+	}
+	if s, _ := co.ToString(1); s != "consumed" {
+		t.Errorf("got %q; want %q", s, "consumed")
+	}
+	if context, _ := co.ToInteger(2); context != 7 {
+		t.Errorf("got context %d; want 7", context)
+	}
+	if !co.ToBoolean(3) {
+		t.Error("continuation did not observe yield status")
+	}
+}
+
+func TestYieldInsideHook(t *testing.T) {
+	l := NewState()
+	OpenLibraries(l)
+	co := l.NewThread()
+	if err := LoadString(co, "local s = 0 for i = 1, 100 do s = s + i end return s"); err != nil {
+		t.Fatal(err)
+	}
+	SetDebugHook(co, func(l *State, _ Debug) { l.Yield(0) }, MaskCount, 10)
+	yields := 0
+	for {
+		shouldYield, err := co.Resume(l, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !shouldYield {
+			break
+		}
+		yields++
+	}
+	if yields == 0 {
+		t.Error("hook never yielded")
+	}
+	if s, _ := co.ToInteger(-1); s != 5050 {
+		t.Errorf("got %d; want 5050", s)
+	}
+}
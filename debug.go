@@ -6,7 +6,7 @@ import (
 )
 
 // A Frame is a token representing an activation record. It is returned by
-// Stack and passed to Info.
+// Stack and passed to Info, Local and SetLocal.
 type Frame *callInfo
 
 func (l *State) resetHookCount() { l.hookCount = l.baseHookCount }
@@ -14,7 +14,10 @@ func (l *State) prototype(ci *callInfo) *prototype {
 	return l.stack[ci.function].(*luaClosure).prototype
 }
 func (l *State) currentLine(ci *callInfo) int {
-	return int(l.prototype(ci).lineInfo[ci.savedPC - 1])
+	if p := l.prototype(ci); len(p.lineInfo) > 0 { // stripped chunks have no line information
+		return int(p.lineInfo[ci.savedPC - 1])
+	}
+	return 0
 }
 
 func chunkID(source string) string {
@@ -100,15 +103,22 @@ func (l *State) arithError(v1, v2 value) {
 	l.typeError(v2, "perform arithmetic on")
 }
 
+func (l *State) bitwiseError(v1, v2 value) {
+	if isNumber(v1) && isNumber(v2) {
+		l.runtimeError("number has no integer representation")
+	} else if _, ok := l.toNumeric(v1); !ok {
+		v2 = v1
+	}
+	l.typeError(v2, "perform bitwise operation on")
+}
+
 func (l *State) concatError(v1, v2 value) {
 	_, isString := v1.(string)
-	_, isNumber := v1.(float64)
-	if isString || isNumber {
+	if isString || isNumber(v1) {
 		v1 = v2
 	}
 	_, isString = v1.(string)
-	_, isNumber = v1.(float64)
-	l.assert(!isString && !isNumber)
+	l.assert(!isString && !isNumber(v1))
 	l.typeError(v1, "concatenate")
 }
 
@@ -118,7 +128,72 @@ func (l *State) assert(cond bool) {
 	}
 }
 
+// A rawFrame is an active function as captured when an error is raised. It
+// holds what a StackFrame is resolved from, should the error reach the host,
+// so that errors caught by pcall cost no more than a walk of the stack.
+type rawFrame struct {
+	function value      // nil for the frames left out of very deep stacks
+	pc       pc         // saved pc, of a Lua function
+	caller   *prototype // calling Lua function, if the function's name can be found from it
+	callerPC pc
+}
+
+// captureFrames returns the active functions of l, leaving out the middle
+// frames of very deep stacks, as Traceback does.
+func (l *State) captureFrames() (frames []rawFrame) {
+	const levels1, levels2 = 12, 10
+	levels, mark := 0, -1
+	for ci := l.callInfo; ci != &l.baseCallInfo; ci = ci.previous {
+		levels++
+	}
+	if levels > levels1+levels2 {
+		mark = levels1
+	}
+	for level, ci := 0, l.callInfo; ci != &l.baseCallInfo; level, ci = level+1, ci.previous {
+		if mark >= 0 && level >= mark && level < levels-levels2 {
+			if level == mark {
+				frames = append(frames, rawFrame{})
+			}
+			continue
+		}
+		f := rawFrame{function: l.stack[ci.function]}
+		if ci.isLua() {
+			f.pc = ci.savedPC
+		}
+		if prev := ci.previous; !ci.isCallStatus(callStatusTail) && prev != &l.baseCallInfo && prev.isLua() {
+			f.caller, f.callerPC = l.prototype(prev), prev.savedPC
+		}
+		frames = append(frames, f)
+	}
+	return
+}
+
+func (f rawFrame) resolve() StackFrame {
+	if f.function == nil {
+		return StackFrame{Source: "..."}
+	}
+	c, _ := f.function.(closure)
+	d := functionInfo(Debug{}, c)
+	frame := StackFrame{Source: d.ShortSource, Line: -1}
+	if lc, ok := c.(*luaClosure); ok {
+		frame.Line = 0
+		if p := lc.prototype; len(p.lineInfo) > 0 { // stripped chunks have no line information
+			frame.Line = int(p.lineInfo[f.pc-1])
+		}
+	}
+	if f.caller != nil {
+		if frame.Function, frame.Kind = calledFunctionName(f.caller, f.callerPC); frame.Kind == "" {
+			frame.Function = ""
+		}
+	}
+	if frame.Kind == "" && d.What == "main" {
+		frame.Kind = "main"
+	}
+	return frame
+}
+
 func (l *State) errorMessage() {
+	value, frames := l.stack[l.top-1], l.captureFrames()
 	if l.errorFunction != 0 { // is there an error handling function?
 		errorFunction := l.stack[l.errorFunction]
 		switch errorFunction.(type) {
@@ -132,7 +207,11 @@ func (l *State) errorMessage() {
 		l.top++
 		l.call(l.top-2, 1, false)
 	}
-	l.throw(RuntimeError(CheckString(l, -1)))
+	message, ok := l.valueToString(l.stack[l.top-1])
+	if !ok {
+		message = fmt.Sprintf("(error object is a %s value)", l.valueToType(l.stack[l.top-1]))
+	}
+	l.throw(&Error{Value: value, Message: message, frames: frames})
 }
 
 // SetDebugHook sets the debugging hook function.
@@ -225,13 +304,11 @@ func functionInfo(p Debug, f closure) (d Debug) {
 	return
 }
 
-func (l *State) functionName(ci *callInfo) (name, kind string) {
-	if ci == &l.baseCallInfo {
-		return
-	}
+// calledFunctionName returns the name of the function called by the
+// instruction before savedPC in p.
+func calledFunctionName(p *prototype, savedPC pc) (name, kind string) {
 	var tm tm
-	p := l.prototype(ci)
-	pc := ci.savedPC
+	pc := savedPC - 1 // the instruction calling the function
 	switch i := p.code[pc]; i.opCode() {
 	case opCall, opTailCall:
 		return p.objectName(i.a(), pc)
@@ -257,6 +334,20 @@ func (l *State) functionName(ci *callInfo) (name, kind string) {
 		tm = tmPow
 	case opUnaryMinus:
 		tm = tmUnaryMinus
+	case opFloorDivide:
+		tm = tmFloorDivide
+	case opBitwiseAnd:
+		tm = tmBitwiseAnd
+	case opBitwiseOr:
+		tm = tmBitwiseOr
+	case opBitwiseXor:
+		tm = tmBitwiseXor
+	case opShiftLeft:
+		tm = tmShiftLeft
+	case opShiftRight:
+		tm = tmShiftRight
+	case opBitwiseNot:
+		tm = tmBitwiseNot
 	case opLength:
 		tm = tmLen
 	case opLessThan:
@@ -313,7 +404,7 @@ func (l *State) collectValidLines(f closure) {
 func Info(l *State, what string, where Frame) (d Debug, ok bool) {
 	var f closure
 	var fun value
-	if what[0] == '>' {
+	if strings.HasPrefix(what, ">") {
 		where = nil
 		fun = l.stack[l.top-1]
 		switch fun := fun.(type) {
@@ -364,8 +455,8 @@ func Info(l *State, what string, where Frame) (d Debug, ok bool) {
 			d.IsTailCall = where != nil && ci.isCallStatus(callStatusTail)
 		case 'n':
 			// calling function is a known Lua function?
-			if where != nil && !ci.isCallStatus(callStatusTail) && where.previous.isLua() {
-				d.Name, d.NameKind = l.functionName(where.previous)
+			if where != nil && !ci.isCallStatus(callStatusTail) && where.previous != &l.baseCallInfo && where.previous.isLua() {
+				d.Name, d.NameKind = calledFunctionName(l.prototype(where.previous), where.previous.savedPC)
 			} else {
 				d.NameKind = ""
 			}
@@ -382,7 +473,7 @@ func Info(l *State, what string, where Frame) (d Debug, ok bool) {
 		}
 	}
 	if hasF {
-		l.apiPush(f)
+		l.apiPush(fun)
 	}
 	if hasL {
 		l.collectValidLines(f)
@@ -390,6 +481,86 @@ func Info(l *State, what string, where Frame) (d Debug, ok bool) {
 	return d, ok
 }
 
+// findLocal returns the name and stack index of the local variable n of the
+// activation record ci, following the numbering described in Local.
+func (l *State) findLocal(ci *callInfo, n int) (name string, index int, ok bool) {
+	var base int
+	if ci.isLua() {
+		if n < 0 { // access to variable arguments?
+			p := l.prototype(ci)
+			if -n >= ci.base()-ci.function-p.parameterCount {
+				return "", 0, false
+			}
+			return "(*vararg)", ci.function + p.parameterCount - n, true
+		}
+		base = ci.base()
+		name, ok = l.prototype(ci).localName(n, ci.savedPC-1)
+	} else {
+		base = ci.function + 1
+	}
+	if !ok {
+		limit := l.top
+		if ci != l.callInfo {
+			limit = ci.next.function
+		}
+		if limit-base < n || n <= 0 { // is n outside of the activation record?
+			return "", 0, false
+		} else if name = "(*Go temporary)"; ci.isLua() {
+			name = "(*temporary)"
+		}
+	}
+	return name, base + n - 1, true
+}
+
+// Local gets information about a local variable of the activation record f,
+// which must have been returned by Stack or given as an argument to a hook.
+// It pushes the variable's value onto the stack and returns its name.
+//
+// The first parameter or active local variable has index 1, and so on, until
+// the last active variable. Negative indices refer to the variable arguments
+// of the function: -1 is the first one. Names starting with '(' represent
+// variables with no known names, e.g. internal loop control variables and
+// temporaries of Go functions.
+//
+// If f is nil, the function on top of the stack is inspected instead, and
+// only the names of its parameters are returned. Nothing is pushed.
+//
+// Returns an empty string and false, pushing nothing, if index is greater
+// than the number of active local variables.
+//
+// http://www.lua.org/manual/5.2/manual.html#lua_getlocal
+func Local(l *State, f Frame, index int) (name string, ok bool) {
+	if f == nil { // information about a non-active function?
+		if c, isLua := l.stack[l.top-1].(*luaClosure); isLua { // consider live variables at function start (parameters)
+			name, ok = c.prototype.localName(index, 0)
+		}
+		return
+	}
+	var i int
+	if name, i, ok = l.findLocal(f, index); ok {
+		l.apiPush(l.stack[i])
+	}
+	return
+}
+
+// SetLocal sets the value of a local variable of the activation record f. It
+// assigns the value at the top of the stack to the variable and returns its
+// name. It also pops the value from the stack. f and index are as in Local.
+//
+// Returns an empty string and false if index is greater than the number of
+// active local variables.
+//
+// http://www.lua.org/manual/5.2/manual.html#lua_setlocal
+func SetLocal(l *State, f Frame, index int) (name string, ok bool) {
+	l.checkElementCount(1)
+	var i int
+	if name, i, ok = l.findLocal(f, index); ok {
+		l.stack[i] = l.stack[l.top-1]
+	}
+	l.top--
+	return
+}
+
 func upValueHelper(f func(*State, int, int) (string, bool), returnValueCount int) Function {
 	return func(l *State) int {
 		CheckType(l, 1, TypeFunction)
@@ -412,6 +583,18 @@ func (l *State) checkUpValue(f, upValueCount int) int {
 	return n
 }
 
+// treatStackOption moves the value pushed by Info onto l1 for an option of
+// debug.getinfo to the field name of the table on top of l.
+func treatStackOption(l, l1 *State, name string) {
+	if l == l1 {
+		l.PushValue(-2)
+		l.Remove(-3)
+	} else {
+		XMove(l1, l, 1)
+	}
+	l.SetField(-2, name)
+}
+
 func threadArg(l *State) (int, *State) {
 	if l.IsThread(1) {
 		return 1, l.ToThread(1)
@@ -465,7 +648,26 @@ func stringToMask(s string, maskCount bool) (mask byte) {
 }
 
 var debugLibrary = []RegistryFunction{
-	// {"debug", db_debug},
+	{"debug", func(l *State) int {
+		for {
+			fmt.Fprint(l.global.stderr, "lua_debug> ")
+			// Read like the io library, so that input after "cont" is left
+			// for io.read.
+			if !readLineHelper(l, l.global.stdin, true) {
+				return 0
+			}
+			line, _ := l.ToString(-1)
+			l.Pop(1)
+			if strings.TrimRight(line, "\r") == "cont" {
+				return 0
+			}
+			if LoadBuffer(l, line, "=(debug command)", "") != nil || l.ProtectedCall(0, 0, 0) != nil {
+				s, _ := l.ToString(-1)
+				fmt.Fprintln(l.global.stderr, s)
+			}
+			l.SetTop(0) // remove eventual returns
+		}
+	}},
 	{"getuservalue", func(l *State) int {
 		if l.TypeOf(1) != TypeUserData {
 			l.PushNil()
@@ -477,22 +679,114 @@ var debugLibrary = []RegistryFunction{
 	{"gethook", func(l *State) int {
 		_, l1 := threadArg(l)
 		hooker, mask := DebugHook(l1), DebugHookMask(l1)
-		if hooker != nil && !l.internalHook {
+		if hooker == nil { // no hook?
+			l.PushNil()
+		} else if !l1.internalHook { // external hook?
 			l.PushString("external hook")
 		} else {
 			hookTable(l)
 			l1.PushThread()
-			//			XMove(l1, l, 1)
-			panic("XMove not implemented yet")
-			l.RawGet(-2)
+			XMove(l1, l, 1)
+			l.RawGet(-2) // get hook
 			l.Remove(-2)
 		}
 		l.PushString(maskToString(mask))
 		l.PushInteger(DebugHookCount(l1))
 		return 3
 	}},
-	// {"getinfo", db_getinfo},
-	// {"getlocal", db_getlocal},
+	{"getinfo", func(l *State) int {
+		i, l1 := threadArg(l)
+		options := OptString(l, i+2, "flnStu")
+		ArgumentCheck(l, !strings.HasPrefix(options, ">"), i+2, "invalid option")
+		var frame Frame
+		if l.IsNumber(i + 1) {
+			var ok bool
+			if frame, ok = Stack(l1, CheckInteger(l, i+1)); !ok {
+				l.PushNil() // level out of range
+				return 1
+			}
+		} else if l.IsFunction(i + 1) {
+			options = ">" + options
+			l.PushValue(i + 1)
+			XMove(l, l1, 1)
+		} else {
+			ArgumentError(l, i+1, "function or level expected")
+		}
+		d, ok := Info(l1, options, frame)
+		if !ok {
+			ArgumentError(l, i+2, "invalid option")
+		}
+		l.CreateTable(0, 2)
+		if strings.ContainsRune(options, 'S') {
+			l.PushString(d.Source)
+			l.SetField(-2, "source")
+			l.PushString(d.ShortSource)
+			l.SetField(-2, "short_src")
+			l.PushInteger(d.LineDefined)
+			l.SetField(-2, "linedefined")
+			l.PushInteger(d.LastLineDefined)
+			l.SetField(-2, "lastlinedefined")
+			l.PushString(d.What)
+			l.SetField(-2, "what")
+		}
+		if strings.ContainsRune(options, 'l') {
+			l.PushInteger(d.CurrentLine)
+			l.SetField(-2, "currentline")
+		}
+		if strings.ContainsRune(options, 'u') {
+			l.PushInteger(d.UpValueCount)
+			l.SetField(-2, "nups")
+			l.PushInteger(d.ParameterCount)
+			l.SetField(-2, "nparams")
+			l.PushBoolean(d.IsVarArg)
+			l.SetField(-2, "isvararg")
+		}
+		if strings.ContainsRune(options, 'n') {
+			if d.Name != "" {
+				l.PushString(d.Name)
+				l.SetField(-2, "name")
+			}
+			l.PushString(d.NameKind)
+			l.SetField(-2, "namewhat")
+		}
+		if strings.ContainsRune(options, 't') {
+			l.PushBoolean(d.IsTailCall)
+			l.SetField(-2, "istailcall")
+		}
+		if strings.ContainsRune(options, 'L') {
+			treatStackOption(l, l1, "activelines")
+		}
+		if strings.ContainsRune(options, 'f') {
+			treatStackOption(l, l1, "func")
+		}
+		return 1
+	}},
+	{"getlocal", func(l *State) int {
+		i, l1 := threadArg(l)
+		n := CheckInteger(l, i+2)
+		if l.IsFunction(i + 1) { // function argument?
+			l.PushValue(i + 1)
+			if name, ok := Local(l, nil, n); ok {
+				l.PushString(name)
+			} else {
+				l.PushNil()
+			}
+			return 1
+		}
+		frame, ok := Stack(l1, CheckInteger(l, i+1))
+		if !ok {
+			ArgumentError(l, i+1, "level out of range")
+		}
+		name, ok := Local(l1, frame, n)
+		if !ok {
+			l.PushNil() // no name (nor value)
+			return 1
+		}
+		XMove(l1, l, 1)
+		l.PushString(name)
+		l.PushValue(-2)
+		return 2
+	}},
 	{"getregistry", func(l *State) int { l.PushValue(RegistryIndex); return 1 }},
 	{"getmetatable", func(l *State) int {
 		CheckAny(l, 1)
@@ -543,15 +837,29 @@ var debugLibrary = []RegistryFunction{
 			l.SetMetaTable(-2)
 		}
 		l1.PushThread()
-		//	 	XMove(l1, l, 1)
-		panic("XMove not yet implemented")
+		XMove(l1, l, 1)
 		l.PushValue(i + 1)
 		l.RawSet(-3)
 		SetDebugHook(l1, hook, mask, count)
 		l1.internalHook = true
 		return 0
 	}},
-	// {"setlocal", db_setlocal},
+	{"setlocal", func(l *State) int {
+		i, l1 := threadArg(l)
+		frame, ok := Stack(l1, CheckInteger(l, i+1))
+		if !ok {
+			ArgumentError(l, i+1, "level out of range")
+		}
+		CheckAny(l, i+3)
+		l.SetTop(i + 3)
+		XMove(l, l1, 1)
+		if name, ok := SetLocal(l1, frame, CheckInteger(l, i+2)); ok {
+			l.PushString(name)
+		} else {
+			l.PushNil()
+		}
+		return 1
+	}},
 	{"setmetatable", func(l *State) int {
 		t := l.TypeOf(2)
 		ArgumentCheck(l, t == TypeNil || t == TypeTable, 2, "nil or table expected")
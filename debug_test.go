@@ -0,0 +1,172 @@
+package lua
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDebugLibrary(t *testing.T) {
+	l := NewState()
+	OpenLibraries(l)
+	err := DoString(l, `
+		local function f(a, b, ...)
+			local c = a + b
+			local info = debug.getinfo(1)
+			assert(info.func == f and info.what == "Lua" and info.currentline == 4)
+			assert(info.nparams == 2 and info.isvararg and info.nups == 2 and info.name == "f" and info.namewhat == "local")
+			assert(info.short_src == "[string \"\"]" and info.source:find("local function f"))
+			assert(debug.getinfo(1, "t").istailcall == false and debug.getinfo(1, "l").func == nil)
+
+			assert(select(1, debug.getlocal(1, 1)) == "a" and select(2, debug.getlocal(1, 1)) == 1)
+			local name, value = debug.getlocal(1, 3)
+			assert(name == "c" and value == 3)
+			assert(debug.getlocal(1, -1) == "(*vararg)" and select(2, debug.getlocal(1, -2)) == "y")
+			assert(debug.getlocal(1, -3) == nil and debug.getlocal(1, 10) == nil)
+			assert(debug.setlocal(1, 3, 10) == "c" and c == 10)
+			assert(debug.setlocal(1, 10, 1) == nil)
+			assert(debug.setlocal(2, 2, "changed") == "x")
+			return c
+		end
+		local x = 0
+		assert(f(1, 2, "x", "y") == 10 and x == "changed")
+
+		assert(debug.getlocal(f, 1) == "a" and debug.getlocal(f, 2) == "b" and debug.getlocal(f, 3) == nil)
+		assert(debug.getlocal(print, 1) == nil)
+
+		local info = debug.getinfo(f, "SLu")
+		assert(info.what == "Lua" and info.linedefined == 2 and info.lastlinedefined == 19 and info.currentline == nil)
+		assert(info.activelines[3] and not info.activelines[1])
+		info = debug.getinfo(print)
+		assert(info.what == "Go" and info.short_src == "[Go]" and info.func == print)
+		assert(debug.getinfo(100) == nil)
+		assert(not pcall(debug.getinfo, 1, ">"))
+		assert(not pcall(debug.getinfo, {}))
+		assert(not pcall(debug.getlocal, 100, 1))
+
+		local co = coroutine.create(function(p) local q = p * 2; coroutine.yield() end)
+		coroutine.resume(co, 21)
+		assert(debug.getinfo(co, 1, "l").currentline == 36)
+		assert(select(2, debug.getlocal(co, 1, 2)) == 42 and debug.setlocal(co, 1, 2, 0) == "q")
+		assert(select(2, debug.getlocal(co, 1, 2)) == 0)
+
+		local lines = {}
+		debug.sethook(function(event, line)
+			local name, value = debug.getlocal(2, 1)
+			if name == "n" then lines[#lines + 1] = value end
+		end, "l")
+		local function g(n)
+			return n
+		end
+		g(7)
+		debug.sethook()
+		assert(lines[1] == 7)
+	`)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+}
+
+func TestLocal(t *testing.T) {
+	l := NewState()
+	OpenLibraries(l)
+	l.Register("inspect", func(l *State) int {
+		frame, _ := Stack(l, 1)
+		var names []string
+		for i := 1; ; i++ {
+			name, ok := Local(l, frame, i)
+			if !ok {
+				break
+			}
+			value, _ := ToStringMeta(l, -1)
+			names = append(names, name+"="+value)
+			l.Pop(2)
+		}
+		l.PushString("replaced")
+		SetLocal(l, frame, 1)
+		l.PushString(strings.Join(names, " "))
+		return 1
+	})
+	err := DoString(l, `
+		local s, t = "a", 2
+		do local u = true end
+		local names = inspect()
+		assert(names == "s=a t=2", names)
+		assert(s == "replaced")
+	`)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+}
+
+func TestErrorFrames(t *testing.T) {
+	l := NewState()
+	OpenLibraries(l)
+	source := `local function f(x)
+			if x then error(x) end
+			return x.field
+		end
+		local t = {}
+		local ok, e = pcall(error, t)
+		assert(not ok and e == t)
+		f(...)`
+	check := func(push func()) *Error {
+		if err := LoadString(l, source); err != nil {
+			t.Fatalf("error: %s", err)
+		}
+		push()
+		err := l.ProtectedCall(1, 0, 0)
+		var e *Error
+		if !errors.As(err, &e) {
+			t.Fatalf("expected *Error but found %#v", err)
+		}
+		var r RuntimeError
+		if !errors.As(err, &r) || string(r) != e.Message {
+			t.Errorf("expected RuntimeError %q but found %q", e.Message, r)
+		}
+		l.Pop(1)
+		return e
+	}
+
+	e := check(l.PushNil)
+	expected := []StackFrame{
+		{Source: `[string "local function f(x)"]`, Line: 3, Function: "f", Kind: "local"},
+		{Source: `[string "local function f(x)"]`, Line: 8, Kind: "main"},
+	}
+	if !reflect.DeepEqual(e.Frames, expected) {
+		t.Errorf("expected frames %+v but found %+v", expected, e.Frames)
+	}
+	if s, ok := e.Value.(string); !ok || !strings.HasSuffix(s, "attempt to index local 'x' (a nil value)") {
+		t.Errorf("unexpected error value %v", e.Value)
+	}
+	traceback := `[string "local function f(x)"]:3: attempt to index local 'x' (a nil value)
+stack traceback:
+	[string "local function f(x)"]:3: in function 'f'
+	[string "local function f(x)"]:8: in main chunk`
+	if s := e.Traceback(); s != traceback {
+		t.Errorf("expected traceback %q but found %q", traceback, s)
+	}
+
+	e = check(l.NewTable)
+	if e.Frames[0] != (StackFrame{Source: "[Go]", Line: -1, Function: "error", Kind: "global"}) {
+		t.Errorf("unexpected frame %+v", e.Frames[0])
+	}
+	if _, ok := e.Value.(*table); !ok || e.Message != "(error object is a table value)" {
+		t.Errorf("unexpected error %#v", e)
+	}
+}
+
+func TestDebugDebug(t *testing.T) {
+	l := NewState()
+	OpenLibraries(l)
+	l.SetStdin(strings.NewReader("x = 1\nerror('boom')\ncont\nhello\n"))
+	var stderr strings.Builder
+	l.SetStderr(&stderr)
+	if err := DoString(l, `debug.debug() assert(x == 1 and io.read() == "hello")`); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if s := stderr.String(); s != "lua_debug> lua_debug> (debug command):1: boom\nlua_debug> " {
+		t.Errorf("unexpected debug output %q", s)
+	}
+}
@@ -0,0 +1,65 @@
+package lua
+
+// A Dialect is a set of opt-in language extensions beyond Lua 5.2. The zero
+// Dialect is plain Lua 5.2, which is the default.
+type Dialect uint
+
+const (
+	// Integers selects the Lua 5.3 number model. Numbers have an integer
+	// subtype holding an int64 alongside the float64 subtype: numerals
+	// without a decimal point or exponent are integers, and +, -, *, %, unary
+	// minus and the new floor division operator // produce integers when both
+	// operands are integers, while / and ^ always produce floats. Integer
+	// arithmetic wraps around on overflow. Floats which are integral are
+	// converted to strings with a ".0" suffix, e.g. 3.0, and the math library
+	// gains math.type, math.tointeger, math.maxinteger and math.mininteger.
+	Integers Dialect = 1 << iota
+
+	// Bitwise adds the Lua 5.3 bitwise operators: & (and), | (or), binary ~
+	// (exclusive or), << and >> (logical shifts) and unary ~ (not), with the
+	// __band, __bor, __bxor, __shl, __shr and __bnot metamethods. Operands are
+	// converted to integers, raising an error for numbers without an exact
+	// integer representation, and results are integers. Without Integers
+	// the results are converted to floats, so they are exact only up to 2^53.
+	Bitwise
+
+	// LocalAttributes adds the Lua 5.4 attributes of local variables. A
+	// variable declared as local x <const> cannot be assigned to, which is
+	// checked at compile time. A variable declared as local x <close> is
+	// also constant, and its value, unless nil or false, must have a __close
+	// metamethod. The metamethod is called with the value and nil when the
+	// variable goes out of scope, whether by reaching the end of its block or
+	// by break, goto or return, or with the value and the error object when
+	// an error unwinds the stack through the variable's scope.
+	LocalAttributes
+)
+
+// SetDialect selects the language extensions used to compile chunks loaded
+// into l, and to run them on l and every thread sharing its global state. It
+// should be called before any chunk is loaded, and before opening the
+// standard libraries.
+func (l *State) SetDialect(d Dialect) { l.global.dialect = d }
+
+// Dialect returns the language extensions selected by SetDialect.
+func (l *State) Dialect() Dialect { return l.global.dialect }
+
+func (l *State) integers() bool { return l.global.dialect&Integers != 0 }
+func (l *State) bitwise() bool  { return l.global.dialect&Bitwise != 0 }
+
+// integer returns the Lua number for i in the chunk being compiled, as
+// State.integer does at run time.
+func (s *scanner) integer(i int64) value {
+	if s.integers() {
+		return i
+	}
+	return float64(i)
+}
+
+// integer returns the Lua number for i: an integer if integers are enabled,
+// and a float otherwise.
+func (l *State) integer(i int64) value {
+	if l.integers() {
+		return i
+	}
+	return float64(i)
+}
@@ -0,0 +1,244 @@
+package lua
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func newIntegerState() *State {
+	l := NewState()
+	l.SetDialect(Integers)
+	OpenLibraries(l)
+	return l
+}
+
+func TestIntegers(t *testing.T) {
+	l := newIntegerState()
+	err := DoString(l, `
+		assert(math.type(1) == "integer" and math.type(1.0) == "float" and math.type("1") == nil)
+		assert(math.type(0x10) == "integer" and math.type(1e2) == "float")
+		assert(math.type(1 + 2) == "integer" and math.type(1 + 2.0) == "float")
+		assert(math.type(6 / 2) == "float" and math.type(2 ^ 2) == "float")
+		assert(7 // 2 == 3 and -7 // 2 == -4 and 7.0 // 2 == 3.0 and math.type(7.0 // 2) == "float")
+		assert(7 % -3 == -2 and -7 % 3 == 2)
+		assert(tostring(3) == "3" and tostring(3.0) == "3.0" and tostring(-0.5) == "-0.5")
+		assert(1 == 1.0 and 1 < 1.5 and 9007199254740993 > 2^53 and 2^53 == 9007199254740992)
+		assert(math.maxinteger + 1 == math.mininteger)
+		assert(9007199254740993 ~= 9007199254740992)
+		assert(tostring(9007199254740993) == "9007199254740993")
+		assert(math.tointeger(3.0) == 3 and math.tointeger(3.5) == nil)
+		assert(math.type(math.floor(3.7)) == "integer" and math.floor(-3.5) == -4)
+		assert(math.abs(math.mininteger) == math.mininteger and math.abs(-2) == 2)
+		assert(math.type(tonumber("10")) == "integer" and math.type(tonumber("10.0")) == "float")
+		assert(tonumber("ff", 16) == 255)
+		assert(string.format("%d", 9007199254740993) == "9007199254740993")
+		assert(not pcall(function() return 1 // 0 end))
+		assert(not pcall(function() return 1 % 0 end))
+		assert(1 // 0.0 == 1 / 0)
+
+		local t = {}
+		t[1.0] = "a"
+		assert(t[1] == "a" and #t == 1)
+		t[2] = "b"
+		for k in pairs(t) do assert(math.type(k) == "integer") end
+
+		local n = 0
+		for i = 1, 3 do assert(math.type(i) == "integer"); n = n + i end
+		assert(n == 6)
+		for i = 1, 2, 0.5 do assert(math.type(i) == "float") end
+		assert(not pcall(function() for i = 1, 10, 0 do end end))
+	`)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+}
+
+func TestIntegersAPI(t *testing.T) {
+	l := newIntegerState()
+	const id = 1<<53 + 1
+	l.PushInteger64(id)
+	if i, ok := l.ToInteger64(-1); !ok || i != id {
+		t.Errorf("expected %d but found %d, %v", int64(id), i, ok)
+	}
+	if s, _ := l.ToString(-1); s != "9007199254740993" {
+		t.Errorf("expected 9007199254740993 but found %s", s)
+	}
+	l.PushNumber(1.5)
+	if _, ok := l.ToInteger64(-1); ok {
+		t.Error("expected 1.5 to have no integer representation")
+	}
+}
+
+func TestIntegersDump(t *testing.T) {
+	l := newIntegerState()
+	if err := LoadString(l, "return 9007199254740993, 2.0"); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	var b bytes.Buffer
+	if err := l.Dump(&b); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if err := l.Load(&b, "dump", "b"); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	l.Call(0, 2)
+	if i, _ := l.ToInteger64(-2); i != 9007199254740993 {
+		t.Errorf("expected 9007199254740993 but found %d", i)
+	}
+	if s, _ := l.ToString(-1); s != "2.0" {
+		t.Errorf("expected 2.0 but found %s", s)
+	}
+}
+
+func TestDefaultDialect(t *testing.T) {
+	l := NewState()
+	OpenLibraries(l)
+	err := DoString(l, `
+		assert(math.type == nil and math.maxinteger == nil)
+		assert(tostring(3) == "3" and tostring(3.0) == "3")
+		assert(2^53 == 2^53 + 1)
+		assert(not load("return 7 // 2"))
+		local t = {}
+		t[1] = "a"
+		assert(t[1.0] == "a")
+	`)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+}
+
+func TestBitwise(t *testing.T) {
+	l := NewState()
+	l.SetDialect(Integers | Bitwise)
+	OpenLibraries(l)
+	err := DoString(l, `
+		assert(5 & 3 == 1 and 5 | 3 == 7 and 5 ~ 3 == 6 and ~0 == -1)
+		assert(1 << 4 == 16 and 256 >> 4 == 16 and 1 << 64 == 0 and 1 << -1 == 0)
+		assert(-1 >> 63 == 1 and 2 >> -1 == 4)
+		assert(1 << 63 == math.mininteger)
+		assert(3.0 & 1 == 1 and math.type(3.0 & 1) == "integer" and "3" & 1 == 1)
+		local x, y = 12, 10
+		assert(x & y == 8 and x | y == 14 and x ~ y == 6 and ~x == -13 and x << 1 == 24)
+		assert(1 | 2 ~ 3 & 4 << 1 == 3) -- 1 | (2 ~ (3 & (4 << 1)))
+		assert(1 + 1 << 1 == 4 and "1" .. 2 << 1 == 24)
+
+		local ok, err = pcall(function() return x & 1.5 end)
+		assert(not ok and err:find("number has no integer representation"))
+		ok, err = pcall(function() return x | {} end)
+		assert(not ok and err:find("attempt to perform bitwise operation on a table value"))
+
+		local mt = {__band = function(a, b) return "band" end, __bnot = function(a) return "bnot" end,
+			__shl = function(a, b) return "shl" end}
+		local v = setmetatable({}, mt)
+		assert(v & 1 == "band" and 1 & v == "band" and ~v == "bnot" and v << 1 == "shl")
+	`)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	l.PushInteger64(6)
+	l.PushInteger64(3)
+	l.Arith(OpBitwiseXor)
+	if i, _ := l.ToInteger64(-1); i != 5 {
+		t.Errorf("expected 5 but found %d", i)
+	}
+}
+
+func TestBitwiseWithoutIntegers(t *testing.T) {
+	l := NewState()
+	l.SetDialect(Bitwise)
+	OpenLibraries(l)
+	if err := DoString(l, `local x = 6; assert(x & 3 == 2 and ~x == -7 and tostring(1 << 4) == "16")`); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	l.SetDialect(0)
+	for _, s := range []string{"return 1 & 2", "return ~1", "return 1 << 2"} {
+		if err := LoadString(l, s); err == nil {
+			t.Errorf("expected syntax error for %q in Lua 5.2", s)
+		}
+	}
+}
+
+func TestLocalAttributes(t *testing.T) {
+	l := newIntegerState()
+	source := `
+		local log = {}
+		local function closable(name)
+			return setmetatable({}, {__close = function(v, err)
+				log[#log + 1] = name .. ":" .. tostring(err)
+			end})
+		end
+		local x <const>, y = 1, 2
+		y = x + y
+		assert(y == 3)
+
+		do
+			local a <close> = closable("a")
+			local b <close> = closable("b")
+			local c <close> = nil
+		end
+		assert(table.concat(log, ",") == "b:nil,a:nil")
+
+		log = {}
+		for i = 1, 3 do
+			local v <close> = closable("loop" .. i)
+			if i == 2 then break end
+		end
+		assert(table.concat(log, ",") == "loop1:nil,loop2:nil")
+
+		log = {}
+		local function f()
+			local v <close> = closable("f")
+			return "result"
+		end
+		assert(f() == "result" and log[1] == "f:nil")
+
+		log = {}
+		local ok, err = pcall(function()
+			local v <close> = closable("error")
+			error("boom", 0)
+		end)
+		assert(not ok and err == "boom" and log[1] == "error:boom")
+
+		ok, err = pcall(function()
+			local v <close> = setmetatable({}, {__close = function() error("in close", 0) end})
+			error("boom", 0)
+		end)
+		assert(not ok and err == "in close")
+
+		ok, err = pcall(function() local v <close> = {} end)
+		assert(not ok and err:find("variable 'v' got a non%-closable value"))
+
+		local n = 0
+		for i = math.maxinteger - 1, math.maxinteger do n = n + 1 end
+		assert(n == 2)
+		for i = math.mininteger, math.mininteger + 2, -1 do n = n + 1 end
+		assert(n == 2)
+	`
+	if err := l.LoadDialect(strings.NewReader(source), "attributes", "t", Integers|LocalAttributes); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if err := l.ProtectedCall(0, 0, 0); err != nil {
+		t.Fatalf("error: %s", l.ToValue(-1))
+	}
+
+	for _, c := range []struct{ source, expected string }{
+		{"local x <const> = 1; x = 2", "attempt to assign to const variable 'x'"},
+		{"local x <close> = nil; x = 2", "attempt to assign to const variable 'x'"},
+		{"local x <const> = 1; return function() x = 2 end", "attempt to assign to const variable 'x'"},
+		{"local x <const> = 1; function x() end", "attempt to assign to const variable 'x'"},
+		{"local x <foo> = 1", "unknown attribute 'foo'"},
+		{"local x <close>, y <close> = 1, 2", "multiple to-be-closed variables in local list"},
+	} {
+		if err := l.LoadDialect(strings.NewReader(c.source), "=test", "t", LocalAttributes); err == nil {
+			t.Errorf("%s: expected an error", c.source)
+		} else if msg, _ := l.ToString(-1); !strings.Contains(msg, c.expected) {
+			t.Errorf("%s: expected error %q but found %q", c.source, c.expected, msg)
+		}
+		l.Pop(1)
+	}
+	if err := LoadString(l, "local x <const> = 1"); err == nil {
+		t.Error("expected attributes to be rejected without LocalAttributes")
+	}
+}
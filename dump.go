@@ -2,25 +2,34 @@ package lua
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 )
 
+var errUnsupportedFormat = errors.New("lua: unsupported binary chunk format")
+
 type dumpState struct {
-	l     *State
-	out   io.Writer
-	order binary.ByteOrder
-	err   error
+	l      *State
+	out    io.Writer
+	format ChunkFormat
+	strip  bool
+	err    error
 }
 
 func (d *dumpState) write(data interface{}) {
 	if d.err == nil {
-		d.err = binary.Write(d.out, d.order, data)
+		d.err = binary.Write(d.out, d.format.ByteOrder, data)
 	}
 }
 
 func (d *dumpState) writeInt(i int) {
-	d.write(int32(i))
+	if d.format.IntSize == 4 {
+		d.write(int32(i))
+	} else {
+		d.write(int64(i))
+	}
 }
 
 func (d *dumpState) writePC(p pc) {
@@ -44,15 +53,37 @@ func (d *dumpState) writeBool(b bool) {
 	}
 }
 
+// writeNumber writes f as a lua_Number, which fails if the number format is
+// integral and f is not an integer in its range.
 func (d *dumpState) writeNumber(f float64) {
-	d.write(f)
+	switch format := d.format; {
+	case format.IntegralNumber:
+		bits := format.NumberSize * 8
+		if f != math.Trunc(f) || f < -math.Ldexp(1, bits-1) || f >= math.Ldexp(1, bits-1) {
+			if d.err == nil {
+				d.err = fmt.Errorf("lua: number %s not representable in a %d-bit integral chunk format", numberToString(f), bits)
+			}
+		} else if bits == 32 {
+			d.write(int32(f))
+		} else {
+			d.write(int64(f))
+		}
+	case format.NumberSize == 4:
+		d.write(float32(f))
+	default:
+		d.write(f)
+	}
 }
 
 func (d *dumpState) writeConstants(p *prototype) {
 	d.writeInt(len(p.constants))
 
 	for _, o := range p.constants {
-		d.writeByte(byte(d.l.valueToType(o)))
+		if _, ok := o.(int64); ok {
+			d.writeByte(typeInteger)
+		} else {
+			d.writeByte(byte(d.l.valueToType(o)))
+		}
 
 		switch o := o.(type) {
 		case nil:
@@ -60,6 +91,8 @@ func (d *dumpState) writeConstants(p *prototype) {
 			d.writeBool(o)
 		case float64:
 			d.writeNumber(o)
+		case int64:
+			d.write(o)
 		case string:
 			d.writeString(o)
 		default:
@@ -91,13 +124,10 @@ func (d *dumpState) writeString(s string) {
 	if size > 0 {
 		size++ //accounts for 0 byte at the end
 	}
-	switch header.PointerSize {
-	case 8:
-		d.write(uint64(size))
-	case 4:
+	if d.format.SizeTSize == 4 {
 		d.write(uint32(size))
-	default:
-		panic(fmt.Sprintf("unsupported pointer size (%d)", header.PointerSize))
+	} else {
+		d.write(uint64(size))
 	}
 	if size > 0 {
 		d.write(ba)
@@ -116,9 +146,22 @@ func (d *dumpState) writeLocalVariables(p *prototype) {
 }
 
 func (d *dumpState) writeDebug(p *prototype) {
+	if d.strip {
+		d.writeString("") // source
+		d.writeInt(0)     // line info
+		d.writeInt(0)     // local variables
+		d.writeInt(0)     // up value names
+		return
+	}
 	d.writeString(p.source)
 	d.writeInt(len(p.lineInfo))
-	d.write(p.lineInfo)
+	if d.format.IntSize == 4 {
+		d.write(p.lineInfo)
+	} else {
+		for _, line := range p.lineInfo {
+			d.writeInt(int(line))
+		}
+	}
 	d.writeLocalVariables(p)
 
 	d.writeInt(len(p.upValues))
@@ -141,13 +184,17 @@ func (d *dumpState) dumpFunction(p *prototype) {
 	d.writeDebug(p)
 }
 
-func (d *dumpState) dumpHeader() {
-	d.err = binary.Write(d.out, d.order, header)
+func (d *dumpState) dumpHeader(h chunkHeader) {
+	d.err = binary.Write(d.out, d.format.ByteOrder, h)
 }
 
-func (l *State) dump(p *prototype, w io.Writer) error {
-	d := dumpState{l: l, out: w, order: endianness()}
-	d.dumpHeader()
+func (l *State) dump(p *prototype, w io.Writer, strip bool, format ChunkFormat) error {
+	h, ok := format.header()
+	if !ok {
+		return errUnsupportedFormat
+	}
+	d := dumpState{l: l, out: w, format: format, strip: strip}
+	d.dumpHeader(h)
 	d.dumpFunction(p)
 
 	return d.err
@@ -0,0 +1,99 @@
+package lua
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// FSRoot adapts a read-only fs.FS, such as an embed.FS, to implement Root.
+// Files can only be opened for reading; writing, removing, and renaming fail
+// with fs.ErrPermission.
+type FSRoot struct{ fsys fs.FS }
+
+// NewFSRoot wraps an fs.FS so it satisfies the Root interface.
+func NewFSRoot(fsys fs.FS) *FSRoot { return &FSRoot{fsys} }
+
+type fsFile struct{ fs.File }
+
+func (f fsFile) Write([]byte) (int, error) { return 0, fs.ErrPermission }
+
+func (f fsFile) Seek(offset int64, whence int) (int64, error) {
+	if s, ok := f.File.(io.Seeker); ok {
+		return s.Seek(offset, whence)
+	}
+	return 0, errors.New("seek not supported")
+}
+
+func (f fsFile) Sync() error { return nil }
+
+// fsName converts an operating system style file name, as found in Lua
+// scripts and package.path, to a name accepted by fs.FS.
+func fsName(op, name string) (string, error) {
+	n := path.Clean(filepath.ToSlash(name))
+	if !fs.ValidPath(n) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return n, nil
+}
+
+func (r *FSRoot) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	n, err := fsName("open", name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := r.fsys.Open(n)
+	if err != nil {
+		return nil, err
+	}
+	return fsFile{f}, nil
+}
+
+func (r *FSRoot) Remove(name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+}
+
+func (r *FSRoot) Rename(oldname, newname string) error {
+	return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: fs.ErrPermission}
+}
+
+func (r *FSRoot) Stat(name string) (os.FileInfo, error) {
+	n, err := fsName("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Stat(r.fsys, n)
+}
+
+// FSSearcher returns a package searcher that looks for Lua modules in fsys,
+// using the templates in package.path, e.g. "./?.lua;./?/init.lua". Install
+// it with AddSearcher:
+//
+//	//go:embed lua
+//	var modules embed.FS
+//	...
+//	sub, _ := fs.Sub(modules, "lua")
+//	lua.AddSearcher(l, lua.FSSearcher(sub))
+func FSSearcher(fsys fs.FS) Function {
+	root := NewFSRoot(fsys)
+	return func(l *State) int {
+		name := CheckString(l, 1)
+		filename, err := findFile(l, name, "path", "/", func(f string) bool {
+			info, err := root.Stat(f)
+			return err == nil && !info.IsDir()
+		})
+		if err != nil {
+			l.PushString(err.Error())
+			return 1 // Module not found in this path.
+		}
+		return checkLoad(l, loadFile(l, filename, "", func(name string) (io.ReadCloser, error) {
+			return root.OpenFile(name, os.O_RDONLY, 0)
+		}) == nil, filename)
+	}
+}
@@ -0,0 +1,142 @@
+package lua
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestFSRoot(t *testing.T) {
+	fsys := fstest.MapFS{
+		"data.txt":    {Data: []byte("embedded")},
+		"lib/run.lua": {Data: []byte("ran = (ran or 0) + 1")},
+	}
+	l := NewState()
+	l.SetRoot(NewFSRoot(fsys))
+	OpenLibraries(l)
+
+	err := DoString(l, `
+		local f = assert(io.open("data.txt"))
+		assert(f:read("*a") == "embedded")
+		f:close()
+		assert(io.open("./lib/../data.txt")):close()
+		assert(io.open("data.txt", "w") == nil, "expected writing to fail")
+		assert(io.open("../data.txt") == nil, "expected parent traversal to fail")
+		assert(not os.remove("data.txt"), "expected remove to fail")
+		assert(not os.rename("data.txt", "other.txt"), "expected rename to fail")
+		dofile("lib/run.lua")
+		assert(loadfile("lib/run.lua"))()
+		assert(ran == 2)
+	`)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+}
+
+func TestFSSearcher(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a/b.lua":       {Data: []byte("return {name = ..., file = select(2, ...)}")},
+		"json/init.lua": {Data: []byte("return 'json'")},
+		"broken.lua":    {Data: []byte("return +")},
+	}
+	l := NewState()
+	OpenLibraries(l)
+	AddSearcher(l, FSSearcher(fsys))
+
+	err := DoString(l, `
+		package.path = "./?.lua;./?/init.lua"
+		local m = require("a.b")
+		assert(m.name == "a.b" and m.file == "./a/b.lua", m.file)
+		assert(require("json") == "json")
+		local ok, err = pcall(require, "missing")
+		assert(not ok and err:find("no file './missing/init.lua'", 1, true), err)
+		ok, err = pcall(require, "broken")
+		assert(not ok and err:find("error loading module 'broken'", 1, true), err)
+	`)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+}
+
+func TestPackagePath(t *testing.T) {
+	path := func(options PackageOptions, noEnv bool) string {
+		l := NewState()
+		if noEnv {
+			l.PushBoolean(true)
+			l.SetField(RegistryIndex, "LUA_NOENV")
+		}
+		OpenLibrariesWith(l, options)
+		l.Global("package")
+		l.Field(-1, "path")
+		s, _ := l.ToString(-1)
+		return s
+	}
+	t.Setenv("LUA_PATH", "ignored")
+	t.Setenv("LUA_PATH_5_2", "./lib/?.lua;;")
+	if s := path(PackageOptions{}, false); s != "./lib/?.lua;"+DefaultPath+";" {
+		t.Errorf("unexpected path %q", s)
+	}
+	if s := path(PackageOptions{Path: "?.lua"}, false); s != "./lib/?.lua;?.lua;" {
+		t.Errorf("unexpected path %q", s)
+	}
+	if s := path(PackageOptions{Path: "?.lua", NoEnvironment: true}, false); s != "?.lua" {
+		t.Errorf("unexpected path %q", s)
+	}
+	if s := path(PackageOptions{}, true); s != DefaultPath {
+		t.Errorf("unexpected path %q", s)
+	}
+
+	fsys := fstest.MapFS{"mod/init.lua": {Data: []byte("return 'init'")}}
+	l := NewState()
+	OpenLibrariesWith(l, PackageOptions{Path: "./?.lua;./?/init.lua", NoEnvironment: true})
+	AddSearcher(l, FSSearcher(fsys))
+	if err := DoString(l, `assert(require("mod") == "init")`); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+}
+
+func TestGoModules(t *testing.T) {
+	module := func(value string) Function {
+		return func(l *State) int {
+			l.NewTable()
+			l.PushString(value)
+			l.SetField(-2, "value")
+			l.PushValue(1)
+			l.SetField(-2, "name")
+			return 1
+		}
+	}
+	RegisterModule("test_json.encode", module("global"))
+	RegisterModule("test_shadowed", module("global"))
+	t.Cleanup(func() {
+		delete(goModules.m, "test_json.encode")
+		delete(goModules.m, "test_shadowed")
+	})
+	l := NewState()
+	OpenLibraries(l)
+	AddModule(l, "test_shadowed", module("state"))
+	err := DoString(l, `
+		local m = require("test_json.encode")
+		assert(m.value == "global" and m.name == "test_json.encode")
+		assert(require("test_shadowed").value == "state")
+		local ok, err = pcall(require, "test_missing")
+		assert(not ok and err:find("no Go module 'test_missing'", 1, true), err)
+
+		local f = assert(package.loadlib("json.so", "luaopen_test_json_encode"))
+		assert(f("x").name == "x")
+		assert(package.loadlib("", "test_shadowed")().value == "state")
+		local f, err, where = package.loadlib("json.so", "luaopen_missing")
+		assert(f == nil and where == "init", err)
+		f, err, where = package.loadlib("json.so", "*")
+		assert(f == nil and where == "absent", err)
+	`)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected RegisterModule to panic on a duplicate name")
+		}
+	}()
+	RegisterModule("test_shadowed", module("again"))
+}
@@ -0,0 +1,295 @@
+package lua
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"weak"
+)
+
+// Table modes. A table with a mode keeps all its entries in the hash part,
+// with weak keys or values wrapped in weak references, and the copy of a
+// finalizable table passed to its __gc metamethod shares that hash part.
+const (
+	weakKeys byte = 1 << iota
+	weakValues
+	finalizable
+)
+
+// A weakReference refers to a table, userdata, function or thread without
+// keeping it alive. References to the same object compare equal, even once
+// it has been collected, so they can be used as keys of the hash part.
+type weakReference[T any] struct{ pointer weak.Pointer[T] }
+
+type weakValue interface{ strong() value }
+
+func (r weakReference[T]) strong() value {
+	if p := r.pointer.Value(); p != nil {
+		return p
+	}
+	return nil
+}
+
+// weaken returns a weak reference to v if it is collectable, and v otherwise.
+func weaken(v value) value {
+	switch v := v.(type) {
+	case *table:
+		return weakReference[table]{weak.Make(v)}
+	case *userData:
+		return weakReference[userData]{weak.Make(v)}
+	case *luaClosure:
+		return weakReference[luaClosure]{weak.Make(v)}
+	case *goClosure:
+		return weakReference[goClosure]{weak.Make(v)}
+	case *goFunction:
+		return weakReference[goFunction]{weak.Make(v)}
+	case *State:
+		return weakReference[State]{weak.Make(v)}
+	}
+	return v
+}
+
+// strengthen returns the object v refers to, or nil if it was collected, if
+// v is a weak reference, and v otherwise.
+func strengthen(v value) value {
+	if r, ok := v.(weakValue); ok {
+		return r.strong()
+	}
+	return v
+}
+
+// weakMode returns the weak mode selected by the __mode field of mt. As the
+// mode is read when the metatable is set, later changes to __mode have no
+// effect.
+func weakMode(mt *table) (mode byte) {
+	if mt != nil {
+		if s, ok := mt.atString(eventNames[tmMode]).(string); ok {
+			if strings.ContainsRune(s, 'k') {
+				mode |= weakKeys
+			}
+			if strings.ContainsRune(s, 'v') {
+				mode |= weakValues
+			}
+		}
+	}
+	return
+}
+
+// setMode changes the mode of t, moving its entries to the hash part if it
+// has a mode. The hash part is updated in place, so that it stays shared
+// with the finalizer's copy of t.
+func (t *table) setMode(mode byte) {
+	if mode == t.mode {
+		return
+	}
+	var keys, values []value
+	for i, v := range t.array {
+		if v != nil {
+			keys, values = append(keys, float64(i+1)), append(values, v)
+		}
+	}
+	for k, v := range t.hash {
+		if k, v = strengthen(k), strengthen(v); k != nil && v != nil {
+			keys, values = append(keys, k), append(values, v)
+		}
+	}
+	for k := range t.hash {
+		delete(t.hash, k)
+	}
+	t.array, t.iterationKeys, t.mode, t.sweepAt = nil, nil, mode, 0
+	if t.finalizer != nil {
+		t.finalizer.mode = mode &^ finalizable
+	}
+	for i, k := range keys {
+		t.put(nil, k, values[i])
+	}
+}
+
+// weakKey returns the hash key for k in a table with a mode.
+func (t *table) weakKey(k value) value {
+	if n, ok := k.(int64); ok {
+		if f, ok := floatKey(n); ok {
+			return f
+		}
+	} else if t.mode&weakKeys != 0 {
+		return weaken(k)
+	}
+	return k
+}
+
+// weakAt is the variant of at for tables with a mode.
+func (t *table) weakAt(k value) value { return strengthen(t.hash[t.weakKey(k)]) }
+
+// weakPut is the variant of put for tables with a mode.
+func (t *table) weakPut(l *State, k, v value) {
+	switch n := k.(type) {
+	case nil:
+		l.runtimeError("table index is nil")
+	case float64:
+		if n != n {
+			l.runtimeError("table index is NaN")
+		}
+	}
+	if k = t.weakKey(k); v == nil {
+		delete(t.hash, k)
+		return
+	} else if t.mode&weakValues != 0 {
+		v = weaken(v)
+	}
+	if _, ok := t.hash[k]; !ok {
+		t.iterationKeys = nil // invalidate iterations when adding an entry
+		if len(t.hash) >= t.sweepAt && t.mode&(weakKeys|weakValues) != 0 {
+			t.sweep()
+			t.sweepAt = max(2*len(t.hash), 8)
+		}
+	}
+	t.hash[k] = v
+}
+
+// sweep removes the entries of a weak table whose key or value has been
+// collected. It is called as the table grows, so that collected entries do
+// not accumulate.
+func (t *table) sweep() {
+	for k, v := range t.hash {
+		if strengthen(k) == nil || strengthen(v) == nil {
+			delete(t.hash, k)
+		}
+	}
+}
+
+// strong returns the key and value of a hash entry of t, which are nil if
+// either was collected.
+func (t *table) strong(k, v value) (value, value) {
+	if t.mode&(weakKeys|weakValues) == 0 {
+		return k, v
+	}
+	if k, v = strengthen(k), strengthen(v); k == nil || v == nil {
+		return nil, nil
+	}
+	return k, v
+}
+
+// A finalizerQueue holds the copies of the objects with __gc metamethods,
+// by id, and the ids of the collected ones whose metamethods are due. It is
+// filled by cleanups, which run on a goroutine of the Go runtime, and drained
+// by the Lua thread at safe points.
+//
+// Cleanups only refer to the queue weakly, and by id to the copies, whose
+// metatables usually lead to the globals. So the runtime keeps none of the
+// Lua objects alive, and a dropped State is collected with its queue.
+type finalizerQueue struct {
+	mutex     sync.Mutex
+	copies    map[uint64]value
+	due       []uint64
+	lastID    uint64
+	pending   atomic.Bool
+	collected func(id uint64) // the cleanup function
+}
+
+func newFinalizerQueue() *finalizerQueue {
+	q := &finalizerQueue{copies: make(map[uint64]value)}
+	w := weak.Make(q)
+	q.collected = func(id uint64) {
+		if q := w.Value(); q != nil {
+			q.mutex.Lock()
+			q.due = append(q.due, id)
+			q.pending.Store(true)
+			q.mutex.Unlock()
+		}
+	}
+	return q
+}
+
+// register adds the copy o of a finalizable object, and returns its id.
+func (q *finalizerQueue) register(o value) uint64 {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.lastID++
+	q.copies[q.lastID] = o
+	return q.lastID
+}
+
+func (q *finalizerQueue) next() (o value) {
+	q.mutex.Lock()
+	for o == nil && len(q.due) > 0 {
+		o = q.copies[q.due[0]]
+		delete(q.copies, q.due[0])
+		q.due = q.due[1:]
+	}
+	q.pending.Store(len(q.due) > 0)
+	q.mutex.Unlock()
+	return
+}
+
+// markForFinalization arranges for the __gc metamethod of o to be called
+// once o is collected, if its new metatable mt has a __gc field, as Lua does
+// when setting the metatable of a table or userdata. A cleanup cannot refer
+// to the object being cleaned up, so the metamethod is called with a copy of
+// o: a userdata with the same data and user value, or a table sharing the
+// entries of o. Objects reachable from their own entries or data are never
+// finalized, and only collected with their State.
+func (l *State) markForFinalization(o value, mt *table) {
+	switch o := o.(type) {
+	case *table:
+		if o.finalizer != nil {
+			o.finalizer.metaTable = mt
+		} else if mt != nil && mt.atString(eventNames[tmGC]) != nil {
+			o.setMode(o.mode | finalizable)
+			o.finalizer = &table{hash: o.hash, metaTable: mt, mode: o.mode &^ finalizable}
+			q := l.global.finalizers
+			runtime.AddCleanup(o, q.collected, q.register(o.finalizer))
+		}
+	case *userData:
+		if o.finalizer != nil {
+			o.finalizer.metaTable = mt
+		} else if mt != nil && mt.atString(eventNames[tmGC]) != nil {
+			o.finalizer = &userData{metaTable: mt, env: o.env, data: o.data}
+			q := l.global.finalizers
+			runtime.AddCleanup(o, q.collected, q.register(o.finalizer))
+		}
+	}
+}
+
+func (l *State) finalizersPending() bool { return l.global.finalizers.pending.Load() }
+
+// runFinalizers calls the __gc metamethods of the collected objects in the
+// queue, with hooks disabled. As in Lua 5.2, an error in a metamethod is
+// propagated as "error in __gc metamethod" to the code running at the safe
+// point.
+func (l *State) runFinalizers() {
+	for o := l.global.finalizers.next(); o != nil; o = l.global.finalizers.next() {
+		tm := l.tagMethodByObject(o, tmGC)
+		if tm == nil {
+			continue
+		}
+		top, allowHook := l.top, l.allowHook
+		l.checkStack(2)
+		l.push(tm)
+		l.push(o)
+		l.allowHook = false
+		err := l.protectedCall(func() { l.call(top, 0, false) }, top, 0)
+		l.allowHook = allowHook
+		if err != nil {
+			message := "no message"
+			if s, ok := l.stack[l.top-1].(string); ok {
+				message = s
+			}
+			l.top = top
+			message = fmt.Sprintf("error in __gc metamethod (%s)", message)
+			l.push(message)
+			l.throw(RuntimeError(message))
+		}
+		l.top = top
+	}
+}
+
+// collectGarbage runs a full garbage collection, then the finalizers which
+// are already due. Others run at a later safe point.
+func (l *State) collectGarbage() {
+	clear(l.stack[l.top:]) // dead stack slots must not keep objects alive
+	runtime.GC()
+	l.runFinalizers()
+	l.remeasure()
+}
@@ -0,0 +1,120 @@
+package lua
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+	"weak"
+)
+
+func TestWeakTables(t *testing.T) {
+	l := NewState()
+	OpenLibraries(l)
+	err := DoString(l, `
+		local function count(t)
+			local n = 0
+			for k, v in pairs(t) do n = n + 1 end
+			return n
+		end
+		local keys = setmetatable({}, {__mode = "k"})
+		local values = setmetatable({}, {__mode = "v"})
+		local both = setmetatable({1, 2}, {__mode = "kv"})
+		local live, f = {}, function() end
+		keys[live], keys[f], keys.s, keys[1] = 1, 2, 3, 4
+		values.live, values.s, values[1] = live, "string", 1
+		both[live] = live
+		for i = 1, 100 do
+			keys[{}] = i
+			values[#values + 1] = {}
+			both[{}] = live
+			both[i + 2] = {}
+		end
+		assert(keys[live] == 1 and values.live == live and both[1] == 1 and both[live] == live)
+		collectgarbage()
+		assert(count(keys) == 4 and keys[live] == 1 and keys[f] == 2)
+		assert(count(values) == 3 and values.live == live and values[2] == nil)
+		assert(count(both) == 3 and both[live] == live and #both == 2)
+
+		setmetatable(keys, nil)
+		keys[{}] = 1
+		collectgarbage()
+		assert(count(keys) == 5)
+	`)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+}
+
+func TestFinalizers(t *testing.T) {
+	l := NewState()
+	OpenLibraries(l)
+	type resource struct{ name string }
+	l.PushUserData(&resource{"file"})
+	l.NewTable()
+	l.PushGoFunction(func(l *State) int {
+		l.PushString(l.ToUserData(1).(*resource).name)
+		l.SetGlobal("closed")
+		return 0
+	})
+	l.SetField(-2, "__gc")
+	l.SetMetaTable(-2)
+	l.Pop(1)
+	err := DoString(l, `
+		setmetatable({name = "table"}, {__gc = function(t) finalized = t.name end})
+		setmetatable({}, {__gc = function() error("boom") end})
+	`)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	var gcError error
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		runtime.GC()
+		if err := DoString(l, `local t = {}`); err != nil {
+			gcError = err
+		}
+		l.Global("finalized")
+		l.Global("closed")
+		finalized, _ := l.ToString(-2)
+		closed, _ := l.ToString(-1)
+		l.Pop(2)
+		if finalized == "table" && closed == "file" && gcError != nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if l.Global("finalized"); !l.IsString(-1) {
+		t.Error("table finalizer did not run")
+	}
+	if l.Global("closed"); !l.IsString(-1) {
+		t.Error("userdata finalizer did not run")
+	}
+	if gcError == nil || !strings.Contains(gcError.Error(), "error in __gc metamethod") {
+		t.Errorf("expected error in __gc metamethod but found %v", gcError)
+	}
+}
+
+func TestDroppedStateIsCollected(t *testing.T) {
+	payload := weak.Make(new([1 << 20]byte))
+	func() {
+		l := NewState()
+		OpenLibraries(l)
+		l.PushUserData(payload.Value())
+		l.SetGlobal("payload")
+		err := DoString(l, `
+			obj = setmetatable({}, {__gc = function() print(payload ~= nil) end})
+			local o = setmetatable({payload = payload}, {__gc = function() end})
+			o.self = o
+		`)
+		if err != nil {
+			t.Fatalf("error: %s", err)
+		}
+	}()
+	for deadline := time.Now().Add(5 * time.Second); payload.Value() != nil && time.Now().Before(deadline); {
+		runtime.GC()
+		time.Sleep(time.Millisecond)
+	}
+	if payload.Value() != nil {
+		t.Error("expected a dropped state with finalizable objects to be collected")
+	}
+}
@@ -1,3 +1,3 @@
 module github.com/hoxbio/go-lua
 
-go 1.24.1
+go 1.25.0
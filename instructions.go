@@ -52,6 +52,14 @@ const (
 	opClosure
 	opVarArg
 	opExtraArg
+	opFloorDivide
+	opBitwiseAnd
+	opBitwiseOr
+	opBitwiseXor
+	opShiftLeft
+	opShiftRight
+	opBitwiseNot
+	opToBeClosed
 )
 
 var opNames = []string{
@@ -95,6 +103,14 @@ var opNames = []string{
 	"CLOSURE",
 	"VARARG",
 	"EXTRAARG",
+	"IDIV",
+	"BAND",
+	"BOR",
+	"BXOR",
+	"SHL",
+	"SHR",
+	"BNOT",
+	"TBC",
 }
 
 const (
@@ -266,4 +282,12 @@ var opModes []byte = []byte{
 	opmode(0, 1, opArgU, opArgN, iABx),  // opClosure
 	opmode(0, 1, opArgU, opArgN, iABC),  // opVarArg
 	opmode(0, 0, opArgU, opArgU, iAx),   // opExtraArg
+	opmode(0, 1, opArgK, opArgK, iABC),  // opFloorDivide
+	opmode(0, 1, opArgK, opArgK, iABC),  // opBitwiseAnd
+	opmode(0, 1, opArgK, opArgK, iABC),  // opBitwiseOr
+	opmode(0, 1, opArgK, opArgK, iABC),  // opBitwiseXor
+	opmode(0, 1, opArgK, opArgK, iABC),  // opShiftLeft
+	opmode(0, 1, opArgK, opArgK, iABC),  // opShiftRight
+	opmode(0, 1, opArgR, opArgN, iABC),  // opBitwiseNot
+	opmode(0, 0, opArgN, opArgN, iABC),  // opToBeClosed
 }
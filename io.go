@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"os/exec"
 )
 
 const fileHandle = "FILE*"
@@ -76,6 +77,27 @@ func openFile(l *State, name string, flag int, perm os.FileMode) (File, error) {
 	return os.OpenFile(name, flag, perm)
 }
 
+func removeFile(l *State, name string) error {
+	if root := l.global.root; root != nil {
+		return root.Remove(name)
+	}
+	return os.Remove(name)
+}
+
+func renameFile(l *State, oldname, newname string) error {
+	if root := l.global.root; root != nil {
+		return root.Rename(oldname, newname)
+	}
+	return os.Rename(oldname, newname)
+}
+
+func statFile(l *State, name string) (os.FileInfo, error) {
+	if root := l.global.root; root != nil {
+		return root.Stat(name)
+	}
+	return os.Stat(name)
+}
+
 func forceOpen(l *State, name, mode string) {
 	s := newFile(l)
 	flags, err := flags(mode)
@@ -123,7 +145,9 @@ func close(l *State) int {
 func write(l *State, w io.Writer, argIndex int) int {
 	var err error
 	for argCount := l.Top(); argIndex < argCount && err == nil; argIndex++ {
-		if n, ok := l.ToNumber(argIndex); ok {
+		if i, ok := l.indexToValue(argIndex).(int64); ok {
+			_, err = fmt.Fprint(w, i)
+		} else if n, ok := l.ToNumber(argIndex); ok {
 			_, err = io.WriteString(w, numberToString(n))
 		} else {
 			_, err = io.WriteString(w, CheckString(l, argIndex))
@@ -304,18 +328,86 @@ func flags(m string) (f int, err error) {
 	return
 }
 
-func createTempFile(l *State) (File, error) {
+type shellRunner struct {
+	stdout, stderr io.Writer
+}
+
+type shellProcess struct {
+	cmd *exec.Cmd
+	r   io.ReadCloser
+	w   io.WriteCloser
+}
+
+func (r shellRunner) Start(command, mode string) (Process, error) {
+	p := &shellProcess{cmd: exec.Command("sh", "-c", command)}
+	p.cmd.Stderr = r.stderr
+	var err error
+	if mode == "r" {
+		p.r, err = p.cmd.StdoutPipe()
+	} else {
+		p.cmd.Stdout = r.stdout
+		if mode == "w" {
+			p.w, err = p.cmd.StdinPipe()
+		}
+	}
+	if err == nil {
+		err = p.cmd.Start()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *shellProcess) Read(b []byte) (int, error) {
+	if p.r == nil {
+		return 0, os.ErrInvalid
+	}
+	return p.r.Read(b)
+}
+
+func (p *shellProcess) Write(b []byte) (int, error) {
+	if p.w == nil {
+		return 0, os.ErrInvalid
+	}
+	return p.w.Write(b)
+}
+
+func (p *shellProcess) Close() error {
+	// Close the pipes first, so that a command blocked on output nobody reads
+	// gets SIGPIPE instead of making Wait hang.
+	if p.r != nil {
+		p.r.Close()
+	}
+	if p.w != nil {
+		p.w.Close()
+	}
+	return p.cmd.Wait()
+}
+
+func processRunner(l *State) ProcessRunner {
+	if r := l.global.processRunner; r != nil {
+		return r
+	}
+	return shellRunner{stdout: l.global.stdout, stderr: l.global.stderr}
+}
+
+func createTempFile(l *State) (File, string, error) {
 	if root := l.global.root; root != nil {
 		for i := 0; i < 100; i++ {
 			name := fmt.Sprintf(".lua_tmp_%d_%d", os.Getpid(), i)
 			f, err := root.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
 			if err == nil {
-				return f, nil
+				return f, name, nil
 			}
 		}
-		return nil, fmt.Errorf("failed to create temp file")
+		return nil, "", fmt.Errorf("failed to create temp file")
 	}
-	return os.CreateTemp("", "")
+	f, err := os.CreateTemp("", "lua_")
+	if err != nil {
+		return nil, "", err
+	}
+	return f, f.Name(), nil
 }
 
 var ioLibrary = []RegistryFunction{
@@ -352,11 +444,22 @@ var ioLibrary = []RegistryFunction{
 		return FileResult(l, err, name)
 	}},
 	{"output", ioFileHelper(output, "w")},
-	{"popen", func(l *State) int { Errorf(l, "'popen' not supported"); panic("unreachable") }},
+	{"popen", func(l *State) int {
+		command := CheckString(l, 1)
+		mode := OptString(l, 2, "r")
+		s := newStream(l, nil, nil, nil, func(l *State) int { return execResult(l, toStream(l).c.Close()) })
+		ArgumentCheck(l, mode == "r" || mode == "w", 2, "invalid mode")
+		p, err := processRunner(l).Start(command, mode)
+		if err != nil {
+			return FileResult(l, err, command)
+		}
+		s.r, s.w, s.c = p, p, p
+		return 1
+	}},
 	{"read", func(l *State) int { return read(l, ioReader(l, input), 1) }},
 	{"tmpfile", func(l *State) int {
 		s := newFile(l)
-		f, err := createTempFile(l)
+		f, _, err := createTempFile(l)
 		if err == nil {
 			s.setFile(f)
 			return 1
@@ -4,7 +4,10 @@ import(
 	"testing"
 	"strings"
 	"os"
+	"os/exec"
 	"fmt"
+	"errors"
+	"bytes"
 )
 
 func TestReadLine(t *testing.T) {
@@ -285,3 +288,114 @@ func TestFileSeekNegativeOffset(t *testing.T) {
 		t.Fatalf("error: %s", err)
 	}
 }
+
+func TestPopenRead(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skipf("io.popen requires sh: %s", err)
+	}
+	l := NewState()
+	OpenLibraries(l)
+	err := DoString(l, `
+		local f = assert(io.popen("echo hello; echo world"))
+		assert(io.type(f) == "file")
+		local t = {}
+		for line in f:lines() do t[#t + 1] = line end
+		assert(t[1] == "hello" and t[2] == "world", "unexpected output")
+		local ok, what, code = f:close()
+		assert(ok == true and what == "exit" and code == 0)
+		ok, what, code = io.popen("exit 3"):close()
+		assert(ok == nil and what == "exit" and code == 3, "expected exit status 3, got " .. tostring(code))
+	`)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+}
+
+func TestPopenWrite(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skipf("io.popen requires sh: %s", err)
+	}
+	var out bytes.Buffer
+	l := NewState()
+	l.SetStdout(&out)
+	OpenLibraries(l)
+	err := DoString(l, `
+		local f = assert(io.popen("tr a-z A-Z", "w"))
+		f:write("shout")
+		assert(f:close())
+		assert(not pcall(io.popen, "true", "rw"))
+	`)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if out.String() != "SHOUT" {
+		t.Errorf("expected SHOUT, got %q", out.String())
+	}
+}
+
+func TestPopenCloseUnread(t *testing.T) {
+	l := NewState()
+	OpenLibraries(l)
+	err := DoString(l, `
+		local f = io.popen("seq 1 1000000")
+		assert(f:read("*l") == "1")
+		local ok, what = f:close()
+		assert(what == "exit" or what == "signal")
+	`)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+}
+
+type denyRunner struct{}
+
+func (denyRunner) Start(command, mode string) (Process, error) {
+	return nil, errors.New("process creation denied")
+}
+
+type stubProcess struct {
+	*strings.Reader
+	code int
+}
+
+func (p stubProcess) Write(b []byte) (int, error) { return 0, os.ErrInvalid }
+func (p stubProcess) Close() error {
+	if p.code != 0 {
+		return stubExit(p.code)
+	}
+	return nil
+}
+
+type stubExit int
+
+func (e stubExit) Error() string { return fmt.Sprintf("exit status %d", int(e)) }
+func (e stubExit) ExitCode() int { return int(e) }
+
+type stubRunner struct{}
+
+func (stubRunner) Start(command, mode string) (Process, error) {
+	return stubProcess{strings.NewReader("ran " + command), len(command)}, nil
+}
+
+func TestPopenProcessRunner(t *testing.T) {
+	l := NewState()
+	OpenLibraries(l)
+	l.SetProcessRunner(denyRunner{})
+	err := DoString(l, `
+		local f, err = io.popen("ls")
+		assert(f == nil and err == "ls: process creation denied", err)
+	`)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	l.SetProcessRunner(stubRunner{})
+	err = DoString(l, `
+		local f = io.popen("stub")
+		assert(f:read("*a") == "ran stub")
+		local ok, what, code = f:close()
+		assert(ok == nil and what == "exit" and code == 4)
+	`)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+}
@@ -19,6 +19,7 @@ package lua
 // has the following standard libraries:
 //  basic library
 //  package library
+//  coroutine library
 //  string manipulation
 //  table manipulation
 //  mathematical functions (sin, log, etc.);
@@ -29,10 +30,16 @@ package lua
 // Except for the basic and the package libraries, each library provides all
 // its functions as fields of a global table or as methods of its objects.
 func OpenLibraries(l *State, preloaded ...RegistryFunction) {
+	OpenLibrariesWith(l, PackageOptions{}, preloaded...)
+}
+
+// OpenLibrariesWith is like OpenLibraries, but opens the package library
+// configured by options. See PackageOpenWith.
+func OpenLibrariesWith(l *State, options PackageOptions, preloaded ...RegistryFunction) {
 	libs := []RegistryFunction{
 		{"_G", BaseOpen},
-		{"package", PackageOpen},
-		// {"coroutine", CoroutineOpen},
+		{"package", PackageOpenWith(options)},
+		{"coroutine", CoroutineOpen},
 		{"table", TableOpen},
 		{"io", IOOpen},
 		{"os", OSOpen},
@@ -0,0 +1,86 @@
+package lua
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// InstructionLimitError is returned by ProtectedCall, Resume and friends when
+// a script executes more instructions than allowed by SetInstructionLimit.
+// Scripts stopped because of SetDeadline or SetContext return
+// context.DeadlineExceeded or context.Canceled instead.
+var InstructionLimitError = errors.New("instruction limit exceeded")
+
+// budgetCheckInterval is the number of instructions executed between checks
+// of the deadline and the context, which are too expensive to check for each
+// instruction. It must be a power of 2.
+const budgetCheckInterval = 1024
+
+type budget struct {
+	instructions, limit int
+	deadline            time.Time
+	context             context.Context
+	err                 error // sticky, so that scripts cannot recover with pcall
+}
+
+func (l *State) updateBudget(f func(b *budget)) {
+	b := l.global.budget
+	if b == nil {
+		b = &budget{}
+	}
+	f(b)
+	b.err = nil
+	if b.limit > 0 || !b.deadline.IsZero() || b.context != nil {
+		l.global.budget = b
+	} else {
+		l.global.budget = nil
+	}
+}
+
+// SetInstructionLimit limits the number of virtual machine instructions that
+// scripts running in l, or in any thread sharing its global state, may
+// execute from now on. Exceeding the limit raises an error that cannot be
+// caught by pcall, so the outermost protected call returns
+// InstructionLimitError. Once exceeded, the limit must be set again before
+// further Lua code can run. Passing 0 removes the limit.
+//
+// Instructions are counted in the interpreter loop, so time spent inside Go
+// functions is not limited.
+func (l *State) SetInstructionLimit(n int) {
+	l.updateBudget(func(b *budget) { b.instructions, b.limit = 0, n })
+}
+
+// SetDeadline stops scripts running in l, or in any thread sharing its global
+// state, once the wall-clock time passes deadline. The outermost protected
+// call then returns context.DeadlineExceeded. Passing the zero time removes
+// the deadline.
+func (l *State) SetDeadline(deadline time.Time) {
+	l.updateBudget(func(b *budget) { b.deadline = deadline })
+}
+
+// SetContext stops scripts running in l, or in any thread sharing its global
+// state, once ctx is done. The outermost protected call then returns
+// ctx.Err(). Passing nil removes the context.
+func (l *State) SetContext(ctx context.Context) {
+	l.updateBudget(func(b *budget) { b.context = ctx })
+}
+
+func (l *State) checkBudget() {
+	b := l.global.budget
+	if b.err == nil {
+		if b.instructions++; b.limit > 0 && b.instructions > b.limit {
+			b.err = InstructionLimitError
+		} else if b.instructions&(budgetCheckInterval-1) == 0 {
+			if !b.deadline.IsZero() && !time.Now().Before(b.deadline) {
+				b.err = context.DeadlineExceeded
+			} else if b.context != nil {
+				b.err = b.context.Err()
+			}
+		}
+	}
+	if b.err != nil {
+		l.push(b.err.Error())
+		l.throw(b.err)
+	}
+}
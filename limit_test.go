@@ -0,0 +1,100 @@
+package lua
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestInstructionLimit(t *testing.T) {
+	l := NewState()
+	OpenLibraries(l)
+	l.SetInstructionLimit(10000)
+	if err := DoString(l, `while true do end`); err != InstructionLimitError {
+		t.Fatalf("expected InstructionLimitError, got %v", err)
+	}
+	if err := DoString(l, `x = 1`); err != InstructionLimitError {
+		t.Errorf("expected the exhausted limit to stop further scripts, got %v", err)
+	}
+	l.SetInstructionLimit(10000)
+	if err := DoString(l, `for i = 1, 100 do x = i end`); err != nil {
+		t.Errorf("unexpected error after resetting the limit: %v", err)
+	}
+	l.SetInstructionLimit(0)
+	if err := DoString(l, `for i = 1, 100000 do x = i end`); err != nil {
+		t.Errorf("unexpected error after removing the limit: %v", err)
+	}
+}
+
+func TestInstructionLimitCannotBeCaught(t *testing.T) {
+	l := NewState()
+	OpenLibraries(l)
+	l.SetInstructionLimit(100000)
+	err := DoString(l, `
+		debug.sethook()
+		while true do
+			pcall(function() while true do end end)
+			coroutine.resume(coroutine.create(function() while true do end end))
+		end
+	`)
+	if err != InstructionLimitError {
+		t.Fatalf("expected InstructionLimitError, got %v", err)
+	}
+	if s, _ := l.ToString(-1); s != InstructionLimitError.Error() {
+		t.Errorf("expected error message %q, got %q", InstructionLimitError.Error(), s)
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	l := NewState()
+	OpenLibraries(l)
+	l.SetDeadline(time.Now().Add(10 * time.Millisecond))
+	if err := DoString(l, `while true do end`); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestContextCancellation(t *testing.T) {
+	l := NewState()
+	OpenLibraries(l)
+	ctx, cancel := context.WithCancel(context.Background())
+	l.SetContext(ctx)
+	l.Register("cancel", func(l *State) int { cancel(); return 0 })
+	if err := DoString(l, `cancel() while true do end`); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	l.SetContext(context.Background())
+	if err := DoString(l, `for i = 1, 10000 do end`); err != nil {
+		t.Errorf("unexpected error with a live context: %v", err)
+	}
+}
+
+func TestBudgetInCoroutine(t *testing.T) {
+	l := NewState()
+	OpenLibraries(l)
+	l.SetInstructionLimit(10000)
+	if err := DoString(l, `coroutine.wrap(function() while true do end end)()`); err != InstructionLimitError {
+		t.Errorf("expected InstructionLimitError, got %v", err)
+	}
+	if s, _ := l.ToString(-1); s != InstructionLimitError.Error() {
+		t.Errorf("expected error message %q, got %q", InstructionLimitError.Error(), s)
+	}
+
+	l = NewState()
+	OpenLibraries(l)
+	ctx, cancel := context.WithCancel(context.Background())
+	l.SetContext(ctx)
+	l.Register("cancel", func(l *State) int { cancel(); return 0 })
+	err := DoString(l, `
+		local ok = coroutine.resume(coroutine.create(function() cancel() while true do end end))
+		error("resumed after cancellation")
+	`)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled from resume, got %v", err)
+	}
+	l.SetContext(ctx)
+	if err := DoString(l, `coroutine.wrap(function() while true do end end)()`); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled from wrap, got %v", err)
+	}
+}
@@ -0,0 +1,252 @@
+package lua
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+type listState struct {
+	out  io.Writer
+	full bool
+	err  error
+}
+
+func (s *listState) printf(format string, args ...interface{}) {
+	if s.err == nil {
+		_, s.err = fmt.Fprintf(s.out, format, args...)
+	}
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
+
+func quoteConstant(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\a':
+			b.WriteString(`\a`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\v':
+			b.WriteString(`\v`)
+		default:
+			if c >= ' ' && c <= '~' {
+				b.WriteByte(c)
+			} else {
+				fmt.Fprintf(&b, `\%03d`, c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func constantString(p *prototype, index int) string {
+	switch k := p.constants[index].(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return fmt.Sprint(k)
+	case float64:
+		return fmt.Sprintf("%.14g", k)
+	case int64:
+		return fmt.Sprintf("%d", k)
+	case string:
+		return quoteConstant(k)
+	}
+	return "?"
+}
+
+// rkOperand returns an RK operand as listed, with constants numbered from -1.
+func rkOperand(rk int) int {
+	if isConstant(rk) {
+		return -1 - constantIndex(rk)
+	}
+	return rk
+}
+
+// rkString returns the listing of an RK operand of p, a constant or "-" for
+// a register.
+func rkString(p *prototype, rk int) string {
+	if isConstant(rk) {
+		return constantString(p, constantIndex(rk))
+	}
+	return "-"
+}
+
+func upValueListName(p *prototype, index int) string {
+	if index < len(p.upValues) && p.upValues[index].name != "" {
+		return p.upValues[index].name
+	}
+	return "-"
+}
+
+func (s *listState) header(p *prototype) {
+	source := p.source
+	if source == "" {
+		source = "=?"
+	}
+	if source[0] == '@' || source[0] == '=' {
+		source = source[1:]
+	} else if source[0] == Signature[0] {
+		source = "(bstring)"
+	} else {
+		source = "(string)"
+	}
+	kind := "function"
+	if p.lineDefined == 0 {
+		kind = "main"
+	}
+	vararg := ""
+	if p.isVarArg {
+		vararg = "+"
+	}
+	s.printf("\n%s <%s:%d,%d> (%d instruction%s at %p)\n", kind, source, p.lineDefined, p.lastLineDefined, len(p.code), plural(len(p.code)), p)
+	s.printf("%d%s param%s, %d slot%s, %d upvalue%s, ", p.parameterCount, vararg, plural(p.parameterCount), p.maxStackSize, plural(p.maxStackSize), len(p.upValues), plural(len(p.upValues)))
+	s.printf("%d local%s, %d constant%s, %d function%s\n", len(p.localVariables), plural(len(p.localVariables)), len(p.constants), plural(len(p.constants)), len(p.prototypes), plural(len(p.prototypes)))
+}
+
+func (s *listState) code(p *prototype) {
+	for pc := 0; pc < len(p.code); pc++ {
+		i := p.code[pc]
+		op := i.opCode()
+		a, b, c, bx, sbx := i.a(), i.b(), i.c(), i.bx(), i.sbx()
+		s.printf("\t%d\t", pc+1)
+		if pc < len(p.lineInfo) && p.lineInfo[pc] > 0 {
+			s.printf("[%d]\t", p.lineInfo[pc])
+		} else {
+			s.printf("[-]\t")
+		}
+		s.printf("%-9s\t", opNames[op])
+		switch opMode(op) {
+		case iABC:
+			s.printf("%d", a)
+			if bMode(op) != opArgN {
+				s.printf(" %d", rkOperand(b))
+			}
+			if cMode(op) != opArgN {
+				s.printf(" %d", rkOperand(c))
+			}
+		case iABx:
+			s.printf("%d", a)
+			if bMode(op) == opArgK {
+				s.printf(" %d", -1-bx)
+			} else if bMode(op) == opArgU {
+				s.printf(" %d", bx)
+			}
+		case iAsBx:
+			s.printf("%d %d", a, sbx)
+		case iAx:
+			s.printf("%d", -1-i.ax())
+		}
+		switch op {
+		case opLoadConstant:
+			s.printf("\t; %s", constantString(p, bx))
+		case opGetUpValue, opSetUpValue:
+			s.printf("\t; %s", upValueListName(p, b))
+		case opGetTableUp:
+			s.printf("\t; %s", upValueListName(p, b))
+			if isConstant(c) {
+				s.printf(" %s", rkString(p, c))
+			}
+		case opSetTableUp:
+			s.printf("\t; %s", upValueListName(p, a))
+			if isConstant(b) {
+				s.printf(" %s", rkString(p, b))
+			}
+			if isConstant(c) {
+				s.printf(" %s", rkString(p, c))
+			}
+		case opGetTable, opSelf:
+			if isConstant(c) {
+				s.printf("\t; %s", rkString(p, c))
+			}
+		case opSetTable, opAdd, opSub, opMul, opDiv, opMod, opPow, opEqual, opLessThan, opLessOrEqual,
+			opFloorDivide, opBitwiseAnd, opBitwiseOr, opBitwiseXor, opShiftLeft, opShiftRight:
+			if isConstant(b) || isConstant(c) {
+				s.printf("\t; %s %s", rkString(p, b), rkString(p, c))
+			}
+		case opJump, opForLoop, opForPrep, opTForLoop:
+			s.printf("\t; to %d", sbx+pc+2)
+		case opClosure:
+			if bx < len(p.prototypes) {
+				s.printf("\t; %p", &p.prototypes[bx])
+			}
+		case opSetList:
+			if c == 0 && pc+1 < len(p.code) {
+				pc++
+				s.printf("\t; %d", int(p.code[pc]))
+			} else {
+				s.printf("\t; %d", c)
+			}
+		case opExtraArg:
+			if ax := i.ax(); ax < len(p.constants) {
+				s.printf("\t; %s", constantString(p, ax))
+			}
+		}
+		s.printf("\n")
+	}
+}
+
+func (s *listState) debug(p *prototype) {
+	s.printf("constants (%d) for %p:\n", len(p.constants), p)
+	for i := range p.constants {
+		s.printf("\t%d\t%s\n", i+1, constantString(p, i))
+	}
+	s.printf("locals (%d) for %p:\n", len(p.localVariables), p)
+	for i, v := range p.localVariables {
+		s.printf("\t%d\t%s\t%d\t%d\n", i, v.name, v.startPC+1, v.endPC+1)
+	}
+	s.printf("upvalues (%d) for %p:\n", len(p.upValues), p)
+	for i, u := range p.upValues {
+		inStack := 0
+		if u.isLocal {
+			inStack = 1
+		}
+		s.printf("\t%d\t%s\t%d\t%d\n", i, upValueListName(p, i), inStack, u.index)
+	}
+}
+
+func (s *listState) function(p *prototype) {
+	s.header(p)
+	s.code(p)
+	if s.full {
+		s.debug(p)
+	}
+	for i := range p.prototypes {
+		s.function(&p.prototypes[i])
+	}
+}
+
+// List writes to w a listing of the bytecode of the Lua function on the top
+// of the stack and of the functions it defines, in the format of luac -l. If
+// full is true, the listing also includes the constants, locals and up values
+// of each function, as luac -l -l does.
+func (l *State) List(w io.Writer, full bool) error {
+	l.checkElementCount(1)
+	if f, ok := l.stack[l.top-1].(*luaClosure); ok {
+		s := listState{out: w, full: full}
+		s.function(f.prototype)
+		return s.err
+	}
+	panic("closure expected")
+}
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 )
 
 func findLoader(l *State, name string) {
@@ -29,13 +31,13 @@ func findLoader(l *State, name string) {
 	}
 }
 
-func findFile(l *State, name, field, dirSep string) (string, error) {
+func findFile(l *State, name, field, dirSep string, readable func(string) bool) (string, error) {
 	l.Field(UpValueIndex(1), field)
 	path, ok := l.ToString(-1)
 	if !ok {
 		Errorf(l, "'package.%s' must be a string", field)
 	}
-	return searchPath(l, name, path, ".", dirSep)
+	return searchPath(name, path, ".", dirSep, readable)
 }
 
 func checkLoad(l *State, loaded bool, fileName string) int {
@@ -51,8 +53,9 @@ func checkLoad(l *State, loaded bool, fileName string) int {
 
 func searcherLua(l *State) int {
 	name := CheckString(l, 1)
-	filename, err := findFile(l, name, "path", string(filepath.Separator))
+	filename, err := findFile(l, name, "path", string(filepath.Separator), func(f string) bool { return readable(l, f) })
 	if err != nil {
+		l.PushString(err.Error())
 		return 1 // Module not found in this path.
 	}
 	return checkLoad(l, LoadFile(l, filename, "") == nil, filename)
@@ -68,8 +71,19 @@ func searcherPreload(l *State) int {
 	return 1
 }
 
+func searcherGo(l *State) int {
+	name := CheckString(l, 1)
+	if f := goModule(l, name); f != nil {
+		l.PushGoFunction(f)
+		l.PushString(name)
+		return 2
+	}
+	l.PushString(fmt.Sprintf("\n\tno Go module '%s'", name))
+	return 1
+}
+
 func createSearchersTable(l *State) {
-	searchers := []Function{searcherPreload, searcherLua}
+	searchers := []Function{searcherPreload, searcherLua, searcherGo}
 	l.CreateTable(len(searchers), 0)
 	for i, s := range searchers {
 		l.PushValue(-2)
@@ -78,15 +92,122 @@ func createSearchersTable(l *State) {
 	}
 }
 
-func readable(filename string) bool {
-	f, err := os.Open(filename)
-	if f != nil {
-		f.Close()
+// AddSearcher appends searcher to package.searchers, so that require tries it
+// after the standard searchers. Like them, searcher is called with the package
+// table as its first up value. The package library must already be open.
+func AddSearcher(l *State, searcher Function) {
+	l.Field(RegistryIndex, "_LOADED")
+	l.Field(-1, "package")
+	l.Field(-1, "searchers")
+	if !l.IsTable(-1) {
+		Errorf(l, "'package.searchers' must be a table")
+	}
+	l.PushValue(-2)
+	l.PushGoClosure(searcher, 1)
+	l.RawSetInt(-2, l.RawLength(-2)+1)
+	l.Pop(3)
+}
+
+// goModules holds the Go modules registered with RegisterModule.
+var goModules = struct {
+	sync.RWMutex
+	m map[string]Function
+}{m: make(map[string]Function)}
+
+// RegisterModule makes the Go module name available to require in every
+// State, much as a C library installed in package.cpath is in Lua. open is
+// called like a library opening function, with the module name as its
+// argument, and returns the module. Names may be dotted, as in "json.encode",
+// in which case they are matched as a whole.
+//
+// RegisterModule is typically called from the init function of the Go
+// package implementing the module. It panics if open is nil or if name is
+// already registered.
+func RegisterModule(name string, open Function) {
+	goModules.Lock()
+	defer goModules.Unlock()
+	if open == nil {
+		panic("lua: RegisterModule open function is nil")
+	}
+	if _, dup := goModules.m[name]; dup {
+		panic("lua: RegisterModule called twice for module " + name)
+	}
+	goModules.m[name] = open
+}
+
+// AddModule makes the Go module name available to require in l only, taking
+// precedence over a module of the same name registered with RegisterModule.
+// Unlike package.preload, which is consulted first, modules added with
+// AddModule are found after the Lua modules in package.path, and can be
+// loaded with package.loadlib.
+func AddModule(l *State, name string, open Function) {
+	SubTable(l, RegistryIndex, "_GOMODULES")
+	l.PushGoFunction(open)
+	l.SetField(-2, name)
+	l.Pop(1)
+}
+
+// goModule returns the opening function of the Go module name, or nil.
+func goModule(l *State, name string) Function {
+	l.Field(RegistryIndex, "_GOMODULES")
+	if l.IsTable(-1) {
+		l.Field(-1, name)
+		f := l.ToGoFunction(-1)
+		l.Pop(2)
+		if f != nil {
+			return f
+		}
+	} else {
+		l.Pop(1)
 	}
-	return err == nil
+	goModules.RLock()
+	defer goModules.RUnlock()
+	return goModules.m[name]
 }
 
-func searchPath(l *State, name, path, sep, dirSep string) (string, error) {
+// goModuleNames returns the names of the Go modules available in l, sorted.
+func goModuleNames(l *State) []string {
+	goModules.RLock()
+	names := make([]string, 0, len(goModules.m))
+	for name := range goModules.m {
+		names = append(names, name)
+	}
+	goModules.RUnlock()
+	if l.Field(RegistryIndex, "_GOMODULES"); l.IsTable(-1) {
+		for l.PushNil(); l.Next(-2); l.Pop(1) {
+			if name, ok := l.ToValue(-2).(string); ok {
+				names = append(names, name)
+			}
+		}
+	}
+	l.Pop(1)
+	sort.Strings(names)
+	return names
+}
+
+// loadGoModule resolves the function name passed to package.loadlib, either
+// a Go module name or the name of the corresponding C function, such as
+// "luaopen_json_encode" for "json.encode".
+func loadGoModule(l *State, symbol string) Function {
+	if f := goModule(l, symbol); f != nil {
+		return f
+	}
+	if symbol = strings.TrimPrefix(symbol, "luaopen_"); symbol != "" {
+		for _, name := range goModuleNames(l) {
+			if strings.Replace(name, ".", "_", -1) == symbol {
+				return goModule(l, name)
+			}
+		}
+	}
+	return nil
+}
+
+func readable(l *State, filename string) bool {
+	info, err := statFile(l, filename)
+	return err == nil && !info.IsDir()
+}
+
+func searchPath(name, path, sep, dirSep string, readable func(string) bool) (string, error) {
 	var msg string
 	if sep != "" {
 		name = strings.Replace(name, sep, dirSep, -1) // Replace sep by dirSep.
@@ -111,8 +232,15 @@ func noEnv(l *State) bool {
 	return b
 }
 
-func setPath(l *State, field, env, def string) {
-	if path := os.Getenv(env); path == "" || noEnv(l) {
+// setPath sets field of the package table to the value of the versioned
+// environment variable env_5_2, or else of env, with ";;" replaced by def. If
+// neither is set, or the environment is ignored, def is used as is.
+func setPath(l *State, field, env, def string, ignoreEnv bool) {
+	path, ok := os.LookupEnv(env + "_5_2")
+	if !ok {
+		path, ok = os.LookupEnv(env)
+	}
+	if !ok || ignoreEnv || noEnv(l) {
 		l.PushString(def)
 	} else {
 		o := fmt.Sprintf("%c%c", pathListSeparator, pathListSeparator)
@@ -123,21 +251,58 @@ func setPath(l *State, field, env, def string) {
 	l.SetField(-2, field)
 }
 
+// PackageOptions configure the package library opened by PackageOpenWith.
+type PackageOptions struct {
+	// Path is the default search path for Lua modules, a list of templates
+	// separated by semicolons in which each '?' is replaced by the module
+	// name, as in "./?.lua;./?/init.lua". If empty, DefaultPath is used.
+	Path string
+
+	// NoEnvironment makes package.path ignore the LUA_PATH_5_2 and LUA_PATH
+	// environment variables, for hermetic embeddings. Setting the registry
+	// field LUA_NOENV to true has the same effect.
+	NoEnvironment bool
+}
+
+// PackageOpenWith returns a function which opens the package library
+// configured by options, to be passed to Require in place of PackageOpen.
+//
+// package.path is set from the LUA_PATH_5_2 environment variable, or else
+// LUA_PATH, in which ";;" stands for the default path. If neither is set, or
+// options.NoEnvironment is true, it is set to the default path.
+func PackageOpenWith(options PackageOptions) Function {
+	path := options.Path
+	if path == "" {
+		path = DefaultPath
+	}
+	return func(l *State) int { return openPackage(l, path, options.NoEnvironment) }
+}
+
 var packageLibrary = []RegistryFunction{
 	{"loadlib", func(l *State) int {
-		_ = CheckString(l, 1) // path
-		_ = CheckString(l, 2) // init
+		_ = CheckString(l, 1) // path, unused as Go modules are linked in
+		symbol := CheckString(l, 2)
+		if symbol == "*" {
+			l.PushNil()
+			l.PushString("dynamic libraries not supported; register Go modules instead")
+			l.PushString("absent")
+			return 3 // Return nil, error message, and where.
+		}
+		if f := loadGoModule(l, symbol); f != nil {
+			l.PushGoFunction(f)
+			return 1
+		}
 		l.PushNil()
-		l.PushString("dynamic libraries not enabled; check your Lua installation")
-		l.PushString("absent")
-		return 3 // Return nil, error message, and where.
+		l.PushString(fmt.Sprintf("no Go module for '%s'", symbol))
+		l.PushString("init")
+		return 3
 	}},
 	{"searchpath", func(l *State) int {
 		name := CheckString(l, 1)
 		path := CheckString(l, 2)
 		sep := OptString(l, 3, ".")
 		dirSep := OptString(l, 4, string(filepath.Separator))
-		f, err := searchPath(l, name, path, sep, dirSep)
+		f, err := searchPath(name, path, sep, dirSep, func(f string) bool { return readable(l, f) })
 		if err != nil {
 			l.PushNil()
 			l.PushString(err.Error())
@@ -148,12 +313,15 @@ var packageLibrary = []RegistryFunction{
 	}},
 }
 
-// PackageOpen opens the package library. Usually passed to Require.
-func PackageOpen(l *State) int {
+// PackageOpen opens the package library with the default options. Usually
+// passed to Require.
+func PackageOpen(l *State) int { return openPackage(l, DefaultPath, false) }
+
+func openPackage(l *State, path string, ignoreEnv bool) int {
 	NewLibrary(l, packageLibrary)
 	createSearchersTable(l)
 	l.SetField(-2, "searchers")
-	setPath(l, "path", "LUA_PATH", defaultPath)
+	setPath(l, "path", "LUA_PATH", path, ignoreEnv)
 	l.PushString(fmt.Sprintf("%c\n%c\n?\n!\n-\n", filepath.Separator, pathListSeparator))
 	l.SetField(-2, "config")
 	SubTable(l, RegistryIndex, "_LOADED")
@@ -6,7 +6,9 @@ import (
 	"io"
 	"math"
 	"os"
+	"reflect"
 	"strings"
+	"time"
 )
 
 // File is the interface for file operations, satisfied by *os.File.
@@ -20,8 +22,13 @@ type File interface {
 
 // Root is the interface for filesystem access. It is satisfied by *OSRoot
 // but can be implemented by any pseudo-filesystem (e.g. a database-backed FS).
+// Every library function that touches the filesystem (io.open, os.remove,
+// os.rename, os.tmpname, loadfile, dofile, and require) goes through it.
 type Root interface {
 	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	Remove(name string) error
+	Rename(oldname, newname string) error
+	Stat(name string) (os.FileInfo, error)
 }
 
 // OSRoot wraps *os.Root to implement Root.
@@ -34,6 +41,34 @@ func (o *OSRoot) OpenFile(name string, flag int, perm os.FileMode) (File, error)
 	return o.r.OpenFile(name, flag, perm)
 }
 
+func (o *OSRoot) Remove(name string) error { return o.r.Remove(name) }
+
+func (o *OSRoot) Rename(oldname, newname string) error { return o.r.Rename(oldname, newname) }
+
+func (o *OSRoot) Stat(name string) (os.FileInfo, error) { return o.r.Stat(name) }
+
+// ProcessRunner starts the commands run by io.popen and os.execute. The
+// default runner passes the command to "sh -c"; hosts can install their own
+// with SetProcessRunner to stub or deny process creation.
+type ProcessRunner interface {
+	// Start runs command. If mode is "r", the command's standard output can be
+	// read from the returned Process; if mode is "w", writes to the Process go
+	// to the command's standard input. If mode is empty, as for os.execute,
+	// the command runs without pipes. os.execute without a command starts an
+	// empty command, to check whether commands can be run at all.
+	Start(command, mode string) (Process, error)
+}
+
+// Process is a command started by a ProcessRunner. Close releases the pipe
+// and waits for the command to exit. It returns nil if the command exited
+// successfully, and otherwise an *exec.ExitError, or any error with an
+// ExitCode() int method, to report the command's exit status.
+type Process interface {
+	io.Reader
+	io.Writer
+	io.Closer
+}
+
 // MultipleReturns is the argument for argCount or resultCount in ProtectedCall and Call.
 const MultipleReturns = -1
 
@@ -59,6 +94,75 @@ type RuntimeError string
 
 func (r RuntimeError) Error() string { return "runtime error: " + string(r) }
 
+// An Error is returned by ProtectedCall, Resume and friends when a Lua error
+// is raised, by a script or by Error, or as a runtime error of the VM. It
+// records the stack at the point where the error was raised, before the
+// stack is unwound and before the error handler runs. It wraps the
+// RuntimeError holding its message, and is retrieved with errors.As:
+//
+//	var e *lua.Error
+//	if errors.As(l.ProtectedCall(0, 0, 0), &e) {
+//		log.Print(e.Traceback())
+//	}
+type Error struct {
+	Value   interface{}  // the error object, before the error handler runs
+	Message string       // the error object returned by the error handler, as a string
+	Frames  []StackFrame // the active functions, innermost first
+
+	frames []rawFrame // captured, until resolved into Frames
+}
+
+// resolveFrames fills in the Frames of err, if it is an *Error about to be
+// returned to the host. Errors caught by pcall are never resolved.
+func resolveFrames(err error) error {
+	if e, ok := err.(*Error); ok && e.frames != nil {
+		e.Frames = make([]StackFrame, len(e.frames))
+		for i, f := range e.frames {
+			e.Frames[i] = f.resolve()
+		}
+		e.frames = nil
+	}
+	return err
+}
+
+// A StackFrame describes an active function when an Error was raised.
+type StackFrame struct {
+	Source   string // short source, e.g. "script.lua" or "[Go]"
+	Line     int    // current line, or -1 if not available
+	Function string // name of the function, or "" if not known
+	Kind     string // how the function was named, as Debug.NameKind, or "main" for a main chunk
+}
+
+func (e *Error) Error() string { return RuntimeError(e.Message).Error() }
+func (e *Error) Unwrap() error { return RuntimeError(e.Message) }
+
+// Traceback formats the message and stack frames of e as Traceback does.
+// Frames in the middle of very deep stacks are not recorded, and show as
+// "...".
+func (e *Error) Traceback() string {
+	var b strings.Builder
+	b.WriteString(e.Message + "\nstack traceback:")
+	for _, f := range e.Frames {
+		if f.Source == "..." {
+			b.WriteString("\n\t...")
+			continue
+		}
+		b.WriteString("\n\t" + f.Source + ":")
+		if f.Line > 0 {
+			fmt.Fprintf(&b, "%d:", f.Line)
+		}
+		switch {
+		case f.Function != "":
+			fmt.Fprintf(&b, " in function '%s'", f.Function)
+		case f.Kind == "main":
+			b.WriteString(" in main chunk")
+		default:
+			b.WriteString(" in ?")
+		}
+	}
+	return b.String()
+}
+
 // A Type is a symbolic representation of a Lua VM type.
 type Type int
 
@@ -83,13 +187,20 @@ type Operator int
 
 // Valid Operator values for Arith.
 const (
-	OpAdd        Operator = iota // Performs addition (+).
-	OpSub                        // Performs subtraction (-).
-	OpMul                        // Performs multiplication (*).
-	OpDiv                        // Performs division (/).
-	OpMod                        // Performs modulo (%).
-	OpPow                        // Performs exponentiation (^).
-	OpUnaryMinus                 // Performs mathematical negation (unary -).
+	OpAdd         Operator = iota // Performs addition (+).
+	OpSub                         // Performs subtraction (-).
+	OpMul                         // Performs multiplication (*).
+	OpDiv                         // Performs division (/).
+	OpMod                         // Performs modulo (%).
+	OpPow                         // Performs exponentiation (^).
+	OpUnaryMinus                  // Performs mathematical negation (unary -).
+	OpFloorDivide                 // Performs floor division (//), see Integers.
+	OpBitwiseAnd                  // Performs bitwise and (&), see Bitwise.
+	OpBitwiseOr                   // Performs bitwise or (|).
+	OpBitwiseXor                  // Performs bitwise exclusive or (~).
+	OpShiftLeft                   // Performs left shift (<<).
+	OpShiftRight                  // Performs right shift (>>).
+	OpBitwiseNot                  // Performs bitwise not (unary ~).
 )
 
 // A ComparisonOperator is an op argument for Compare.
@@ -220,14 +331,7 @@ type Hook func(state *State, activationRecord Debug)
 // A Function is a Go function intended to be called from Lua.
 type Function func(state *State) int
 
-// TODO XMove(from, to State, n int)
-//
-// Set functions (stack -> Lua)
-// RawSetValue(index int, p interface{})
-//
-// Debug API
-// Local(activationRecord *Debug, index int) string
-// SetLocal(activationRecord *Debug, index int) string
+// TODO RawSetValue(index int, p interface{})
 
 type pc int
 type callStatus byte
@@ -262,6 +366,7 @@ type State struct {
 	hookCount             int
 	hooker                Hook
 	upValues              *openUpValue
+	toBeClosed            []int    // stack indices of pending <close> variables
 	errorFunction         int      // current error handling function (stack index)
 	baseCallInfo          callInfo // callInfo for first level (go calling lua)
 	protectFunction       func()
@@ -270,15 +375,25 @@ type State struct {
 type globalState struct {
 	mainThread         *State
 	tagMethodNames     [tmCount]string
-	metaTables         [TypeCount]*table // metatables for basic types
+	metaTables         [TypeCount]*table       // metatables for basic types
+	goMetaTables       map[reflect.Type]*table // metatables for Go values, see PushGoValue
 	registry           *table
 	panicFunction      Function // to be called in unprotected errors
 	version            *float64 // pointer to version number
 	memoryErrorMessage string
 	root               Root
+	processRunner      ProcessRunner
+	budget             *budget // nil unless an execution limit is set
+	memoryLimit        int     // in bytes, 0 if unlimited
+	allocated          int     // approximate bytes in use, as last measured plus allocations since
+	nextMeasure        int     // allocated bytes at which to measure again, when memoryLimit is set
 	stdin              io.Reader
 	stdout             io.Writer
 	stderr             io.Writer
+	clock              func() time.Time
+	location           *time.Location
+	dialect            Dialect
+	finalizers         *finalizerQueue
 	// seed uint // randomized seed for hashes
 	// upValueHead upValue // head of double-linked list of all open upvalues
 }
@@ -291,7 +406,7 @@ func (g *globalState) metaTable(o value) *table {
 	case bool:
 		t = TypeBoolean
 	// TODO TypeLightUserData
-	case float64:
+	case float64, int64:
 		t = TypeNumber
 	case string:
 		t = TypeString
@@ -403,7 +518,7 @@ func (l *State) CallWithContinuation(argCount, resultCount, context int, continu
 //
 // The possible errors are the following:
 //
-//	RuntimeError  a runtime error
+//	*Error        a runtime error, wrapping a RuntimeError
 //	MemoryError   allocating memory, the error handler is not called
 //	ErrorError    running the error handler
 //
@@ -416,7 +531,11 @@ func (l *State) ProtectedCall(argCount, resultCount, errorFunction int) error {
 // allows the called function to yield.
 //
 // http://www.lua.org/manual/5.2/manual.html#lua_pcallk
-func (l *State) ProtectedCallWithContinuation(argCount, resultCount, errorFunction, context int, continuation Function) (err error) {
+func (l *State) ProtectedCallWithContinuation(argCount, resultCount, errorFunction, context int, continuation Function) error {
+	return resolveFrames(l.protectedCallWithContinuation(argCount, resultCount, errorFunction, context, continuation))
+}
+
+func (l *State) protectedCallWithContinuation(argCount, resultCount, errorFunction, context int, continuation Function) (err error) {
 	if apiCheck && continuation != nil && l.callInfo.isLua() {
 		panic("cannot use continuations inside hooks")
 	}
@@ -447,17 +566,150 @@ func (l *State) ProtectedCallWithContinuation(argCount, resultCount, errorFuncti
 	return
 }
 
+// Resume starts and resumes a coroutine in thread l.
+//
+// To start a coroutine, first create a new thread (see NewThread); then push
+// onto its stack the main function plus any arguments; then call Resume, with
+// argCount being the number of arguments. This call returns when the
+// coroutine suspends or finishes its execution. When it returns, the stack
+// contains all values passed to Yield, or all values returned by the body
+// function. Resume returns true if the coroutine yields, and false with a nil
+// error if the coroutine finishes its execution without errors. In case of
+// errors, the stack is not unwound, so you can use the debug API over it. The
+// error message is on the top of the stack.
+//
+// To resume a coroutine, remove any results from the last Yield, put on its
+// stack only the values to be passed as results from Yield, and then call
+// Resume.
+//
+// The parameter from represents the coroutine that is resuming l. If there is
+// no such coroutine, this parameter can be nil.
+//
+// http://www.lua.org/manual/5.2/manual.html#lua_resume
+func (l *State) Resume(from *State, argCount int) (shouldYield bool, err error) {
+	shouldYield, err = l.resumeFrom(from, argCount)
+	return shouldYield, resolveFrames(err)
+}
+
+func (l *State) resumeFrom(from *State, argCount int) (shouldYield bool, err error) {
+	if l.shouldYield {
+		l.checkElementCount(argCount)
+	} else {
+		l.checkElementCount(argCount + 1)
+	}
+	nonYieldableCallCount := l.nonYieldableCallCount
+	if l.nestedGoCallCount = 1; from != nil {
+		l.nestedGoCallCount = from.nestedGoCallCount + 1
+	}
+	l.nonYieldableCallCount = 0 // allow yields
+	firstArg := l.top - argCount
+	switch {
+	case l.nestedGoCallCount >= maxCallCount:
+		err = l.resumeError("Go stack overflow", firstArg)
+	case l.error != nil:
+		err = l.resumeError("cannot resume dead coroutine", firstArg)
+	case !l.shouldYield && l.callInfo != &l.baseCallInfo:
+		err = l.resumeError("cannot resume non-suspended coroutine", firstArg)
+	default:
+		err = l.protect(func() { l.resume(firstArg) })
+		for err != nil && err != yieldError { // error?
+			if l.recover(err) { // recover point?
+				err = l.protect(l.unroll) // run continuation
+			} else { // unrecoverable error
+				l.error = err // mark thread as 'dead'
+				l.setErrorObject(err, l.top)
+				l.callInfo.setTop(l.top)
+				break
+			}
+		}
+	}
+	l.nonYieldableCallCount = nonYieldableCallCount
+	l.nestedGoCallCount--
+	if err == yieldError {
+		return true, nil
+	}
+	return false, err
+}
+
+func (l *State) resumeError(message string, firstArg int) error {
+	l.top = firstArg // remove args from the stack
+	l.apiPush(message)
+	return RuntimeError(message)
+}
+
+// YieldWithContinuation yields a coroutine.
+//
+// This function should only be called as the return expression of a Go
+// function, as follows:
+//
+//	return l.YieldWithContinuation(resultCount, context, continuation)
+//
+// When a Go function calls YieldWithContinuation in that way, the running
+// coroutine suspends its execution, and the call to Resume that started this
+// coroutine returns. The parameter resultCount is the number of values from
+// the stack that are passed as results to Resume.
+//
+// When the coroutine is resumed again, Lua calls the given continuation
+// function to continue the execution of the Go function that yielded. This
+// continuation function receives the same stack from the previous function,
+// with the results removed and replaced by the arguments passed to Resume.
+// Moreover, the continuation function may access the value context by
+// calling Context.
+//
+// http://www.lua.org/manual/5.2/manual.html#lua_yieldk
+func (l *State) YieldWithContinuation(resultCount, context int, continuation Function) int {
+	ci := l.callInfo
+	l.checkElementCount(resultCount)
+	if l.nonYieldableCallCount > 0 {
+		if l != l.global.mainThread {
+			l.runtimeError("attempt to yield across a Go-call boundary")
+		}
+		l.runtimeError("attempt to yield from outside a coroutine")
+	}
+	l.shouldYield = true
+	ci.extra = ci.function // save current 'function'
+	if ci.isLua() {        // inside a hook?
+		if apiCheck && continuation != nil {
+			panic("hooks cannot continue after yielding")
+		}
+	} else {
+		if ci.continuation = continuation; continuation != nil { // is there a continuation?
+			ci.context = context
+		}
+		ci.function = l.top - resultCount - 1 // protect stack below results
+		l.throw(yieldError)
+	}
+	l.assert(ci.isCallStatus(callStatusHooked)) // must be inside a hook
+	return 0
+}
+
+// Yield is equivalent to YieldWithContinuation, but it has no continuation.
+// Therefore, when the thread resumes, it returns to the function that called
+// the function calling Yield.
+//
+// http://www.lua.org/manual/5.2/manual.html#lua_yield
+func (l *State) Yield(resultCount int) int { return l.YieldWithContinuation(resultCount, 0, nil) }
+
 // Load loads a Lua chunk, without running it. If there are no errors, it
 // pushes the compiled chunk as a Lua function on top of the stack.
 // Otherwise, it pushes an error message.
 //
 // http://www.lua.org/manual/5.2/manual.html#lua_load
 func (l *State) Load(r io.Reader, chunkName string, mode string) error {
+	return l.LoadDialect(r, chunkName, mode, l.global.dialect)
+}
+
+// LoadDialect is like Load, but compiles a text chunk with the language
+// extensions d rather than those selected by SetDialect. As Integers and
+// Bitwise also change how values behave at run time, they are best selected
+// for the whole state with SetDialect; LoadDialect suits the extensions which
+// only change the syntax, such as LocalAttributes.
+func (l *State) LoadDialect(r io.Reader, chunkName, mode string, d Dialect) error {
 	if chunkName == "" {
 		chunkName = "?"
 	}
 
-	if err := protectedParser(l, r, chunkName, mode); err != nil {
+	if err := protectedParser(l, r, chunkName, mode, d); err != nil {
 		return err
 	}
 
@@ -473,9 +725,38 @@ func (l *State) Load(r io.Reader, chunkName string, mode string) error {
 //
 // http://www.lua.org/manual/5.3/manual.html#lua_dump
 func (l *State) Dump(w io.Writer) error {
+	return l.DumpWith(w, DumpOptions{})
+}
+
+// DumpStripped is like Dump, but omits debug information (source name, line
+// information, and the names of locals and up values) from the binary chunk.
+//
+// http://www.lua.org/manual/5.3/manual.html#lua_dump
+func (l *State) DumpStripped(w io.Writer) error {
+	return l.DumpWith(w, DumpOptions{Strip: true})
+}
+
+// DumpOptions control how DumpWith writes a binary chunk.
+type DumpOptions struct {
+	// Strip omits debug information, as in DumpStripped.
+	Strip bool
+
+	// Format is the layout of the chunk, for loading by the reference
+	// implementation on another platform. If zero, HostFormat is used.
+	Format ChunkFormat
+}
+
+// DumpWith is like Dump, but writes the binary chunk as given by options.
+// It fails if the format is not one Lua 5.2 can be built with, or if the
+// number format is integral and the function has non-integral constants.
+func (l *State) DumpWith(w io.Writer, options DumpOptions) error {
 	l.checkElementCount(1)
+	format := options.Format
+	if format == (ChunkFormat{}) {
+		format = HostFormat
+	}
 	if f, ok := l.stack[l.top-1].(*luaClosure); ok {
-		return l.dump(f.prototype, w)
+		return l.dump(f.prototype, w, options.Strip, format)
 	}
 	panic("closure expected")
 }
@@ -494,6 +775,7 @@ func NewState() *State {
 		stdin:              os.Stdin,
 		stdout:             os.Stdout,
 		stderr:             os.Stderr,
+		finalizers:         newFinalizerQueue(),
 	}
 	l.global = g
 	l.initializeStack()
@@ -503,6 +785,48 @@ func NewState() *State {
 	return l
 }
 
+// XMove exchanges values between different threads of the same global state.
+//
+// This function pops n values from the stack from, and pushes them onto the
+// stack to.
+//
+// http://www.lua.org/manual/5.2/manual.html#lua_xmove
+func XMove(from, to *State, n int) {
+	if from == to {
+		return
+	}
+	from.checkElementCount(n)
+	if apiCheck && from.global != to.global {
+		panic("moving among independent states")
+	}
+	if apiCheck && to.callInfo.top-to.top < n {
+		panic("not enough elements to move")
+	}
+	from.top -= n
+	for i := 0; i < n; i++ {
+		to.push(from.stack[from.top+i])
+	}
+}
+
+// NewThread creates a new thread, pushes it on the stack, and returns a
+// pointer to a State that represents this new thread. The new state returned
+// by this function shares with the original state all global objects (such
+// as tables), but has an independent execution stack.
+//
+// There is no explicit function to close or to destroy a thread. Threads are
+// subject to garbage collection, like any Lua object.
+//
+// http://www.lua.org/manual/5.2/manual.html#lua_newthread
+func (l *State) NewThread() *State {
+	l.allocate(threadSize + basicStackSize*valueSize)
+	l1 := &State{allowHook: true, error: nil, nonYieldableCallCount: 1, global: l.global}
+	l1.hookMask, l1.baseHookCount, l1.hooker = l.hookMask, l.baseHookCount, l.hooker
+	l1.resetHookCount()
+	l1.initializeStack()
+	l.apiPush(l1)
+	return l1
+}
+
 func apiCheckStackIndex(index int, v value) {
 	if apiCheck && (v == none || isPseudoIndex(index)) {
 		panic(fmt.Sprintf("index %d not in the stack", index))
@@ -676,7 +1000,7 @@ func (l *State) valueToType(v value) Type {
 		return TypeBoolean
 	// case lightUserData:
 	// 	return TypeLightUserData
-	case float64:
+	case float64, int64:
 		return TypeNumber
 	case string:
 		return TypeString
@@ -731,8 +1055,7 @@ func (l *State) IsString(index int) bool {
 	if _, ok := l.indexToValue(index).(string); ok {
 		return true
 	}
-	_, ok := l.indexToValue(index).(float64)
-	return ok
+	return isNumber(l.indexToValue(index))
 }
 
 // IsUserData verifies that the value at index is a userdata.
@@ -751,14 +1074,14 @@ func (l *State) IsUserData(index int) bool {
 //
 // http://www.lua.org/manual/5.2/manual.html#lua_arith
 func (l *State) Arith(op Operator) {
-	if op != OpUnaryMinus {
+	if op != OpUnaryMinus && op != OpBitwiseNot {
 		l.checkElementCount(2)
 	} else {
 		l.checkElementCount(1)
 		l.push(l.stack[l.top-1])
 	}
 	o1, o2 := l.stack[l.top-2], l.stack[l.top-1]
-	if n1, n2, ok := pairAsNumbers(o1, o2); ok {
+	if n1, n2, ok := pairAsNumbers(o1, o2); ok && !isBitwise(op) {
 		l.stack[l.top-2] = arith(op, n1, n2)
 	} else {
 		l.stack[l.top-2] = l.arith(o1, o2, tm(op-OpAdd)+tmAdd)
@@ -772,7 +1095,7 @@ func (l *State) Arith(op Operator) {
 // http://www.lua.org/manual/5.2/manual.html#lua_rawequal
 func (l *State) RawEqual(index1, index2 int) bool {
 	if o1, o2 := l.indexToValue(index1), l.indexToValue(index2); o1 != nil && o2 != nil {
-		return o1 == o2
+		return rawEqual(o1, o2)
 	}
 	return false
 }
@@ -805,10 +1128,30 @@ func (l *State) Compare(index1, index2 int, op ComparisonOperator) bool {
 //
 // http://www.lua.org/manual/5.2/manual.html#lua_tointegerx
 func (l *State) ToInteger(index int) (int, bool) {
-	if n, ok := l.toNumber(l.indexToValue(index)); ok {
-		return int(n), true
+	n, ok := l.toNumeric(l.indexToValue(index))
+	if !ok {
+		return 0, false
+	} else if i, ok := n.(int64); ok {
+		return int(i), true
 	}
-	return 0, false
+	return int(n.(float64)), true
+}
+
+// ToInteger64 converts the Lua value at index into an int64, without loss
+// of precision for the integers of the Integers dialect. The Lua value must
+// be an integer, or a float or string with an exact integer representation.
+//
+// If the operation failed, the second return value will be false.
+//
+// http://www.lua.org/manual/5.3/manual.html#lua_tointegerx
+func (l *State) ToInteger64(index int) (int64, bool) {
+	n, ok := l.toNumeric(l.indexToValue(index))
+	if !ok {
+		return 0, false
+	} else if i, ok := n.(int64); ok {
+		return i, true
+	}
+	return floatToInteger(n.(float64))
 }
 
 // ToUnsigned converts the Lua value at index to a Go uint. The Lua value
@@ -823,7 +1166,9 @@ func (l *State) ToInteger(index int) (int, bool) {
 //
 // http://www.lua.org/manual/5.2/manual.html#lua_tounsignedx
 func (l *State) ToUnsigned(index int) (uint, bool) {
-	if n, ok := l.toNumber(l.indexToValue(index)); ok {
+	if i, ok := l.indexToValue(index).(int64); ok {
+		return uint(uint32(i)), true
+	} else if n, ok := l.toNumber(l.indexToValue(index)); ok {
 		const supUnsigned = float64(^uint32(0)) + 1
 		return uint(n - math.Floor(n/supUnsigned)*supUnsigned), true
 	}
@@ -836,7 +1181,7 @@ func (l *State) ToUnsigned(index int) (uint, bool) {
 //
 // http://www.lua.org/manual/5.2/manual.html#lua_tolstring
 func (l *State) ToString(index int) (s string, ok bool) {
-	if s, ok = toString(l.indexToValue(index)); ok { // Bug compatibility: replace a number with its string representation.
+	if s, ok = l.valueToString(l.indexToValue(index)); ok { // Bug compatibility: replace a number with its string representation.
 		l.setIndexToValue(index, s)
 	}
 	return
@@ -922,6 +1267,7 @@ func (l *State) ToValue(index int) interface{} {
 //
 // http://www.lua.org/manual/5.2/manual.html#lua_pushstring
 func (l *State) PushString(s string) string { // TODO is it useful to return the argument?
+	l.allocate(stringSize + len(s))
 	l.apiPush(s)
 	return s
 }
@@ -1001,6 +1347,7 @@ func (l *State) PushGoClosure(function Function, upValueCount uint8) {
 		n := int(upValueCount)
 
 		l.checkElementCount(n)
+		l.allocate(closureSize + n*valueSize)
 		cl := &goClosure{function: function, upValues: make([]value, upValueCount)}
 		l.top -= n
 		copy(cl.upValues, l.stack[l.top:l.top+n])
@@ -1075,6 +1422,7 @@ func (l *State) RawGetValue(index int, p interface{}) {
 //
 // http://www.lua.org/manual/5.2/manual.html#lua_createtable
 func (l *State) CreateTable(arrayCount, recordCount int) {
+	l.allocate(tableSize + arrayCount*valueSize + recordCount*hashEntrySize)
 	l.apiPush(newTableWithSize(arrayCount, recordCount))
 }
 
@@ -1146,7 +1494,7 @@ func (l *State) SetTable(index int) {
 func (l *State) RawSet(index int) {
 	l.checkElementCount(2)
 	t := l.indexToValue(index).(*table)
-	t.put(l, l.stack[l.top-2], l.stack[l.top-1])
+	l.putAndAllocate(t, l.stack[l.top-2], l.stack[l.top-1])
 	t.invalidateTagMethodCache()
 	l.top -= 2
 }
@@ -1161,7 +1509,11 @@ func (l *State) RawSet(index int) {
 func (l *State) RawSetInt(index, key int) {
 	l.checkElementCount(1)
 	t := l.indexToValue(index).(*table)
+	before := t.size()
 	t.putAtInt(key, l.stack[l.top-1])
+	if after := t.size(); after > before {
+		l.allocate(after - before)
+	}
 	l.top--
 }
 
@@ -1178,6 +1530,9 @@ func (l *State) SetUserValue(index int) {
 		t := l.stack[l.top-1].(*table)
 		d.env = t
 	}
+	if d.finalizer != nil {
+		d.finalizer.env = d.env
+	}
 	l.top--
 }
 
@@ -1194,8 +1549,11 @@ func (l *State) SetMetaTable(index int) {
 	switch v := l.indexToValue(index).(type) {
 	case *table:
 		v.metaTable = mt
+		v.setMode(weakMode(mt) | v.mode&finalizable)
+		l.markForFinalization(v, mt)
 	case *userData:
 		v.metaTable = mt
+		l.markForFinalization(v, mt)
 	default:
 		l.global.metaTables[l.TypeOf(index)] = mt
 	}
@@ -1263,15 +1621,19 @@ func (l *State) Register(name string, f Function) {
 }
 
 func (l *State) setErrorObject(err error, oldTop int) {
+	l.stack[oldTop] = l.errorObject(err)
+	l.top = oldTop + 1
+}
+
+// errorObject returns the Lua value of err, just after it was raised.
+func (l *State) errorObject(err error) value {
 	switch err {
 	case MemoryError:
-		l.stack[oldTop] = l.global.memoryErrorMessage
+		return l.global.memoryErrorMessage
 	case ErrorError:
-		l.stack[oldTop] = "error in error handling"
-	default:
-		l.stack[oldTop] = l.stack[l.top-1]
+		return "error in error handling"
 	}
-	l.top = oldTop + 1
+	return l.stack[l.top-1]
 }
 
 func (l *State) protectedCall(f func(), oldTop, errorFunc int) error {
@@ -1279,6 +1641,7 @@ func (l *State) protectedCall(f func(), oldTop, errorFunc int) error {
 	l.errorFunction = errorFunc
 	err := l.protect(f)
 	if err != nil {
+		err = l.closeProtected(callInfo, oldTop, err)
 		l.close(oldTop)
 		l.setErrorObject(err, oldTop)
 		l.callInfo, l.allowHook, l.nonYieldableCallCount = callInfo, allowHook, nonYieldableCallCount
@@ -1477,12 +1840,19 @@ func (l *State) PushNumber(n float64) { l.apiPush(n) }
 // PushInteger pushes n onto the stack.
 //
 // http://www.lua.org/manual/5.2/manual.html#lua_pushinteger
-func (l *State) PushInteger(n int) { l.apiPush(float64(n)) }
+func (l *State) PushInteger(n int) { l.apiPush(l.integer(int64(n))) }
+
+// PushInteger64 pushes n onto the stack. With the Integers dialect it is
+// pushed as an integer, without loss of precision; otherwise it is converted
+// to a float.
+//
+// http://www.lua.org/manual/5.3/manual.html#lua_pushinteger
+func (l *State) PushInteger64(n int64) { l.apiPush(l.integer(n)) }
 
 // PushUnsigned pushes n onto the stack.
 //
 // http://www.lua.org/manual/5.2/manual.html#lua_pushunsigned
-func (l *State) PushUnsigned(n uint) { l.apiPush(float64(n)) }
+func (l *State) PushUnsigned(n uint) { l.apiPush(l.integer(int64(n))) }
 
 // PushBoolean pushes a boolean value with value b onto the stack.
 //
@@ -1498,7 +1868,10 @@ func (l *State) PushLightUserData(d interface{}) { l.apiPush(d) }
 
 // PushUserData is similar to PushLightUserData, but pushes a full userdata
 // onto the stack.
-func (l *State) PushUserData(d interface{}) { l.apiPush(&userData{data: d}) }
+func (l *State) PushUserData(d interface{}) {
+	l.allocate(userDataSize)
+	l.apiPush(&userData{data: d})
+}
 
 // Length of the value at index; it is equivalent to the # operator in
 // Lua. The result is pushed on the stack.
@@ -1593,6 +1966,38 @@ func (l *State) SetStderr(w io.Writer) {
 	l.global.stderr = w
 }
 
+// SetProcessRunner sets the ProcessRunner used by io.popen and os.execute. Passing nil
+// restores the default, which runs commands with "sh -c".
+func (l *State) SetProcessRunner(r ProcessRunner) {
+	l.global.processRunner = r
+}
+
+// SetClock sets the function used by os.time and os.date to read the current
+// time, instead of the default time.Now. Passing nil restores the default.
+func (l *State) SetClock(now func() time.Time) {
+	l.global.clock = now
+}
+
+// SetLocation sets the time zone used by os.time and os.date for local time,
+// instead of the default time.Local. Passing nil restores the default.
+func (l *State) SetLocation(loc *time.Location) {
+	l.global.location = loc
+}
+
+func (l *State) now() time.Time {
+	if l.global.clock != nil {
+		return l.global.clock()
+	}
+	return time.Now()
+}
+
+func (l *State) location() *time.Location {
+	if l.global.location != nil {
+		return l.global.location
+	}
+	return time.Local
+}
+
 // SetRoot sets the filesystem root. Pass a *lua.OSRoot to use a real *os.Root.
 func (l *State) SetRoot(r Root) {
 	l.global.root = r
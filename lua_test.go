@@ -329,3 +329,136 @@ func TestSetRootLines(t *testing.T) {
 }
 
 
+
+func TestXMove(t *testing.T) {
+	l := NewState()
+	l1 := l.NewThread()
+	l.PushInteger(1)
+	l.PushString("two")
+	l.PushBoolean(true)
+	XMove(l, l1, 2)
+	if l.Top() != 2 {
+		t.Errorf("l.Top() = %d; want 2", l.Top())
+	}
+	if l1.Top() != 2 {
+		t.Fatalf("l1.Top() = %d; want 2", l1.Top())
+	}
+	if s, ok := l1.ToString(1); !ok || s != "two" {
+		t.Errorf("l1.ToString(1) = %q, %t; want \"two\", true", s, ok)
+	}
+	if !l1.ToBoolean(2) {
+		t.Errorf("l1.ToBoolean(2) = false; want true")
+	}
+	XMove(l1, l, 1)
+	if !l.ToBoolean(-1) || l1.Top() != 1 {
+		t.Errorf("XMove(l1, l, 1) did not move the top value back")
+	}
+}
+
+func TestDebugGetHook(t *testing.T) {
+	testString(t, `
+		assert(select('#', debug.gethook()) == 3)
+		local f, mask, count = debug.gethook()
+		assert(f == nil and mask == "" and count == 0)
+		local function hook() end
+		debug.sethook(hook, "cr", 5)
+		f, mask, count = debug.gethook()
+		debug.sethook()
+		assert(f == hook and mask == "cr" and count == 5)
+		assert(debug.gethook() == nil)
+		local co = coroutine.create(function() end)
+		debug.sethook(co, hook, "c")
+		assert(debug.gethook(co) == hook)
+		assert(debug.gethook() == nil)
+	`)
+}
+
+func TestSetRootRemoveAndRename(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644)
+	os.WriteFile(filepath.Join(dir, "b.txt"), []byte("b"), 0644)
+
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+
+	l := NewState()
+	l.SetRoot(NewOSRoot(root))
+	OpenLibraries(l)
+
+	err = DoString(l, `
+		assert(os.rename("a.txt", "c.txt"))
+		assert(os.remove("b.txt"))
+		assert(not os.remove("b.txt"), "expected removing a missing file to fail")
+		assert(not os.remove("../outside.txt"), "expected remove outside the root to fail")
+		assert(not os.rename("c.txt", "../outside.txt"), "expected rename outside the root to fail")
+	`)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "c.txt")); err != nil {
+		t.Errorf("expected c.txt to exist: %s", err)
+	}
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be gone, got %v", name, err)
+		}
+	}
+}
+
+func TestSetRootTmpName(t *testing.T) {
+	dir := t.TempDir()
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+
+	l := NewState()
+	l.SetRoot(NewOSRoot(root))
+	OpenLibraries(l)
+
+	err = DoString(l, `
+		local name = os.tmpname()
+		local f = assert(io.open(name, "w"))
+		f:write("temporary")
+		f:close()
+		tmp = name
+	`)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	l.Global("tmp")
+	name, _ := l.ToString(-1)
+	if b, err := os.ReadFile(filepath.Join(dir, name)); err != nil || string(b) != "temporary" {
+		t.Errorf("expected temporary file %q inside the root, got %q, %v", name, b, err)
+	}
+}
+
+func TestSetRootRequire(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "mod.lua"), []byte(`return {answer = 42}`), 0644)
+	outside := t.TempDir()
+	os.WriteFile(filepath.Join(outside, "escape.lua"), []byte(`return true`), 0644)
+
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+
+	l := NewState()
+	l.SetRoot(NewOSRoot(root))
+	OpenLibraries(l)
+
+	err = DoString(l, fmt.Sprintf(`
+		package.path = "./?.lua;%s/?.lua"
+		assert(require("mod").answer == 42)
+		assert(not pcall(require, "escape"), "expected require outside the root to fail")
+	`, outside))
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+}
@@ -33,18 +33,49 @@ func reduce(f func(float64, float64) float64) Function {
 	}
 }
 
+// pushIntegral pushes the integral float f, as an integer if integers are
+// enabled and f fits.
+func pushIntegral(l *State, f float64) {
+	if i, ok := floatToInteger(f); ok && l.integers() {
+		l.PushInteger64(i)
+	} else {
+		l.PushNumber(f)
+	}
+}
+
+func mathRound(f func(float64) float64) Function {
+	return func(l *State) int {
+		if i, ok := l.indexToValue(1).(int64); ok {
+			l.PushInteger64(i)
+		} else {
+			pushIntegral(l, f(CheckNumber(l, 1)))
+		}
+		return 1
+	}
+}
+
 var mathLibrary = []RegistryFunction{
-	{"abs", mathUnaryOp(math.Abs)},
+	{"abs", func(l *State) int {
+		if i, ok := l.indexToValue(1).(int64); ok {
+			if i < 0 {
+				i = -i // wraps around for math.mininteger, as in Lua 5.3
+			}
+			l.PushInteger64(i)
+		} else {
+			l.PushNumber(math.Abs(CheckNumber(l, 1)))
+		}
+		return 1
+	}},
 	{"acos", mathUnaryOp(math.Acos)},
 	{"asin", mathUnaryOp(math.Asin)},
 	{"atan2", mathBinaryOp(math.Atan2)},
 	{"atan", mathUnaryOp(math.Atan)},
-	{"ceil", mathUnaryOp(math.Ceil)},
+	{"ceil", mathRound(math.Ceil)},
 	{"cosh", mathUnaryOp(math.Cosh)},
 	{"cos", mathUnaryOp(math.Cos)},
 	{"deg", mathUnaryOp(func(x float64) float64 { return x / radiansPerDegree })},
 	{"exp", mathUnaryOp(math.Exp)},
-	{"floor", mathUnaryOp(math.Floor)},
+	{"floor", mathRound(math.Floor)},
 	{"fmod", mathBinaryOp(math.Mod)},
 	{"frexp", func(l *State) int {
 		f, e := math.Frexp(CheckNumber(l, 1))
@@ -86,11 +117,11 @@ var mathLibrary = []RegistryFunction{
 		case 1: // upper limit only
 			u := CheckNumber(l, 1)
 			ArgumentCheck(l, 1.0 <= u, 1, "interval is empty")
-			l.PushNumber(math.Floor(r*u) + 1.0) // [1, u]
+			pushIntegral(l, math.Floor(r*u)+1.0) // [1, u]
 		case 2: // lower and upper limits
 			lo, u := CheckNumber(l, 1), CheckNumber(l, 2)
 			ArgumentCheck(l, lo <= u, 2, "interval is empty")
-			l.PushNumber(math.Floor(r*(u-lo+1)) + lo) // [lo, u]
+			pushIntegral(l, math.Floor(r*(u-lo+1))+lo) // [lo, u]
 		default:
 			Errorf(l, "wrong number of arguments")
 		}
@@ -108,9 +139,44 @@ var mathLibrary = []RegistryFunction{
 	{"tan", mathUnaryOp(math.Tan)},
 }
 
+// mathIntegerLibrary holds the functions added by the Integers dialect.
+var mathIntegerLibrary = []RegistryFunction{
+	{"tointeger", func(l *State) int {
+		if i, ok := l.ToInteger64(1); ok && l.TypeOf(1) == TypeNumber {
+			l.PushInteger64(i)
+		} else {
+			CheckAny(l, 1)
+			l.PushNil()
+		}
+		return 1
+	}},
+	{"type", func(l *State) int {
+		switch l.indexToValue(1).(type) {
+		case int64:
+			l.PushString("integer")
+		case float64:
+			l.PushString("float")
+		default:
+			CheckAny(l, 1)
+			l.PushNil()
+		}
+		return 1
+	}},
+}
+
 // MathOpen opens the math library. Usually passed to Require.
+//
+// When the Integers dialect is selected, the library also provides
+// math.type, math.tointeger, math.maxinteger and math.mininteger.
 func MathOpen(l *State) int {
 	NewLibrary(l, mathLibrary)
+	if l.integers() {
+		SetFunctions(l, mathIntegerLibrary, 0)
+		l.PushInteger64(math.MaxInt64)
+		l.SetField(-2, "maxinteger")
+		l.PushInteger64(math.MinInt64)
+		l.SetField(-2, "mininteger")
+	}
 	l.PushNumber(3.1415926535897932384626433832795) // TODO use math.Pi instead? Values differ.
 	l.SetField(-2, "pi")
 	l.PushNumber(math.MaxFloat64)
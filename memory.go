@@ -0,0 +1,191 @@
+package lua
+
+import "unsafe"
+
+// Approximate sizes, in bytes, of the Go representations of Lua objects. They
+// are only used for memory accounting, which is itself approximate.
+const (
+	valueSize     = 16 // an interface value, e.g. a stack slot or array entry
+	stringSize    = 16 // string header, excluding the bytes
+	tableSize     = 80
+	hashEntrySize = 48 // key, value and Go map overhead
+	closureSize   = 40
+	upValueSize   = 32
+	userDataSize  = 48
+	threadSize    = 400
+)
+
+// SetMemoryLimit sets an approximate ceiling, in bytes, on the memory used by
+// the Lua objects of l and of every thread sharing its global state. When
+// allocations exceed it, the reachable objects are measured again, and if they
+// still exceed the limit a MemoryError is raised in the running script. So
+// that measuring stays cheap, an eighth of the limit is allocated at least
+// between measurements, which scripts may briefly use beyond the limit.
+// Passing 0 removes the limit.
+//
+// Memory is accounted for tables, strings, closures, userdata, and stacks,
+// but not for Go values held by userdata or by Go functions.
+func (l *State) SetMemoryLimit(bytes int) {
+	l.global.memoryLimit = bytes
+	l.remeasure()
+}
+
+// MemoryUsage returns the approximate number of bytes used by the Lua objects
+// reachable from l's global state. It walks all reachable objects, so it is
+// relatively expensive.
+func (l *State) MemoryUsage() int {
+	l.remeasure()
+	return l.global.allocated
+}
+
+// remeasure replaces the count of allocated bytes with a measurement of the
+// reachable objects, and schedules the next measurement.
+func (l *State) remeasure() {
+	g := l.global
+	g.allocated = l.measure()
+	g.nextMeasure = max(g.memoryLimit, g.allocated+g.memoryLimit/8)
+}
+
+// allocate accounts for n newly allocated bytes, raising a MemoryError if this
+// exceeds the memory limit even after measuring the reachable objects.
+func (l *State) allocate(n int) {
+	g := l.global
+	if g.allocated += n; g.memoryLimit > 0 && g.allocated > g.nextMeasure {
+		l.remeasure()
+		if g.allocated+n > g.memoryLimit {
+			l.throw(MemoryError)
+		}
+		g.allocated += n
+	}
+}
+
+func (t *table) size() int { return tableSize + cap(t.array)*valueSize + len(t.hash)*hashEntrySize }
+
+// putAndAllocate stores v at k in t, accounting for any growth of t.
+func (l *State) putAndAllocate(t *table, k, v value) {
+	before := t.size()
+	t.put(l, k, v)
+	if after := t.size(); after > before {
+		l.allocate(after - before)
+	}
+}
+
+// A memoryMeter measures the objects reachable from a global state. It keeps
+// the objects still to walk in a worklist rather than recursing, so that deeply
+// nested objects cannot overflow the Go stack.
+type memoryMeter struct {
+	seen    map[interface{}]bool
+	pending []interface{} // objects seen but not yet walked
+	total   int
+}
+
+func (l *State) measure() int {
+	g := l.global
+	m := memoryMeter{seen: make(map[interface{}]bool)}
+	m.value(g.mainThread)
+	m.value(g.registry)
+	for _, mt := range g.metaTables {
+		if mt != nil {
+			m.value(mt)
+		}
+	}
+	for len(m.pending) > 0 {
+		p := m.pending[len(m.pending)-1]
+		m.pending = m.pending[:len(m.pending)-1]
+		m.walk(p)
+	}
+	return m.total
+}
+
+func (m *memoryMeter) string(s string) {
+	m.total += stringSize
+	if len(s) == 0 {
+		return
+	}
+	if p := unsafe.StringData(s); !m.seen[p] {
+		m.seen[p] = true
+		m.total += len(s)
+	}
+}
+
+func (m *memoryMeter) visit(p interface{}) bool {
+	if m.seen[p] {
+		return false
+	}
+	m.seen[p] = true
+	return true
+}
+
+// queue adds p to the objects to walk, unless it was already seen.
+func (m *memoryMeter) queue(p interface{}) {
+	if m.visit(p) {
+		m.pending = append(m.pending, p)
+	}
+}
+
+func (m *memoryMeter) value(v value) {
+	switch v := v.(type) {
+	case string:
+		m.string(v)
+	case *table, *luaClosure, *goClosure, *userData, *State:
+		m.queue(v)
+	}
+}
+
+// walk accounts for the object p, and queues the objects it refers to.
+func (m *memoryMeter) walk(p interface{}) {
+	switch v := p.(type) {
+	case *table:
+		m.total += v.size()
+		for _, e := range v.array {
+			m.value(e)
+		}
+		for k, e := range v.hash {
+			m.value(k)
+			m.value(e)
+		}
+		if v.metaTable != nil {
+			m.value(v.metaTable)
+		}
+	case *luaClosure:
+		m.total += closureSize + len(v.upValues)*8
+		m.queue(v.prototype)
+		for _, uv := range v.upValues {
+			if uv != nil && m.visit(uv) {
+				m.total += upValueSize
+				m.value(uv.value())
+			}
+		}
+	case *goClosure:
+		m.total += closureSize + len(v.upValues)*valueSize
+		for _, uv := range v.upValues {
+			m.value(uv)
+		}
+	case *userData:
+		m.total += userDataSize
+		if v.metaTable != nil {
+			m.value(v.metaTable)
+		}
+		if v.env != nil {
+			m.value(v.env)
+		}
+	case *prototype:
+		m.total += len(v.code)*4 + len(v.constants)*valueSize + len(v.lineInfo)*4 + len(v.upValues)*valueSize*2
+		for _, k := range v.constants {
+			m.value(k)
+		}
+		for i := range v.prototypes {
+			m.queue(&v.prototypes[i])
+		}
+		for _, lv := range v.localVariables {
+			m.total += valueSize
+			m.string(lv.name)
+		}
+		m.string(v.source)
+	case *State:
+		m.total += threadSize + len(v.stack)*valueSize
+		for _, x := range v.stack[:v.top] {
+			m.value(x)
+		}
+	}
+}
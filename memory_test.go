@@ -0,0 +1,109 @@
+package lua
+
+import (
+	"runtime/debug"
+	"testing"
+)
+
+func TestMemoryUsageIsPerState(t *testing.T) {
+	l1, l2 := NewState(), NewState()
+	OpenLibraries(l1)
+	OpenLibraries(l2)
+	before := l2.MemoryUsage()
+	if err := DoString(l1, `t = {} for i = 1, 100000 do t[i] = tostring(i) end`); err != nil {
+		t.Fatal(err)
+	}
+	if n := l1.MemoryUsage(); n < 100000*valueSize {
+		t.Errorf("expected at least %d bytes in use, got %d", 100000*valueSize, n)
+	}
+	if after := l2.MemoryUsage(); after != before {
+		t.Errorf("expected memory usage of an idle state to stay at %d, got %d", before, after)
+	}
+	testString(t, `
+		local before = collectgarbage("count")
+		t = {}
+		for i = 1, 10000 do t[i] = {} end
+		local during, bytes = collectgarbage("count")
+		assert(during > before + 10000 * 80 / 1024, "count did not grow")
+		assert(bytes == math.floor(during * 1024) % 1024)
+		t = nil
+		collectgarbage()
+		assert(collectgarbage("count") < during, "count did not shrink")
+	`)
+}
+
+func TestMemoryLimit(t *testing.T) {
+	l := NewState()
+	OpenLibraries(l)
+	l.SetMemoryLimit(l.MemoryUsage() + 1<<20)
+	if err := DoString(l, `local t = {} for i = 1, 1e7 do t[i] = i end`); err != MemoryError {
+		t.Fatalf("expected MemoryError for a growing table, got %v", err)
+	}
+	if s, _ := l.ToString(-1); s != "not enough memory" {
+		t.Errorf("expected error message 'not enough memory', got %q", s)
+	}
+	l.Pop(1)
+	if err := DoString(l, `local s = "x" while true do s = s .. s end`); err != MemoryError {
+		t.Fatalf("expected MemoryError for a growing string, got %v", err)
+	}
+	l.Pop(1)
+	if err := DoString(l, `local function f() return f() + 1 end f()`); err == nil {
+		t.Fatal("expected an error for unbounded recursion")
+	}
+	l.Pop(1)
+	err := DoString(l, `
+		local ok, err = pcall(function() local t = {} for i = 1, 1e7 do t[i] = {} end end)
+		assert(not ok and err == "not enough memory")
+		local t = {}
+		for i = 1, 1000 do t[i] = string.rep("x", 100) end -- garbage was released
+	`)
+	if err != nil {
+		t.Fatalf("unexpected error after recovering from a memory error: %v", err)
+	}
+	l.SetMemoryLimit(0)
+	if err := DoString(l, `local t = {} for i = 1, 1e5 do t[i] = {} end`); err != nil {
+		t.Errorf("unexpected error after removing the limit: %v", err)
+	}
+}
+
+func TestMemoryDeepNesting(t *testing.T) {
+	l := NewState()
+	OpenLibraries(l)
+	l.SetMemoryLimit(1 << 30)
+	defer debug.SetMaxStack(debug.SetMaxStack(16 << 20)) // fail fast if measuring recurses
+	err := DoString(l, `
+		local t = nil
+		for i = 1, 2e5 do t = {t} end
+		collectgarbage()
+		assert(collectgarbage("count") > 2e5 * 80 / 1024)
+	`)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+}
+
+func TestMemoryLimitMeasuresSeldom(t *testing.T) {
+	l := NewState()
+	OpenLibraries(l)
+	if err := DoString(l, `t = {} for i = 1, 1e5 do t[i] = {} end`); err != nil {
+		t.Fatal(err)
+	}
+	l.SetMemoryLimit(l.MemoryUsage() + 1024)
+	l.Register("allocated", func(l *State) int {
+		l.PushInteger(l.global.allocated)
+		return 1
+	})
+	err := DoString(l, `
+		local measured, last = 0, allocated()
+		for i = 1, 1e4 do
+			local garbage = {}
+			local n = allocated()
+			if n < last then measured = measured + 1 end -- measuring dropped the garbage
+			last = n
+		end
+		assert(measured < 10, measured)
+	`)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+}
@@ -1,9 +1,11 @@
 package lua
 
 import (
-	"io/ioutil"
+	"bytes"
+	"fmt"
 	"os"
 	"os/exec"
+	"strings"
 	"syscall"
 	"time"
 )
@@ -21,9 +23,177 @@ func field(l *State, key string, def int) int {
 	return r
 }
 
+// execResult pushes the results of os.execute, or of closing a file opened
+// with io.popen, for the error returned by running a command.
+func execResult(l *State, err error) int {
+	terminatedSuccessfully := true
+	terminationReason := "exit"
+	terminationData := 0
+
+	if err != nil {
+		terminatedSuccessfully = false
+		terminationReason = "exit"
+		terminationData = 1
+
+		if exiterr, ok := err.(*exec.ExitError); ok {
+			if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
+				if status.Signaled() {
+					terminationReason = "signal"
+					terminationData = int(status.Signal())
+				} else {
+					terminationData = status.ExitStatus()
+				}
+			} else {
+				// Unsupported system?
+			}
+		} else if exiterr, ok := err.(interface{ ExitCode() int }); ok {
+			terminationData = exiterr.ExitCode()
+		} else {
+			// From man 3 system:
+			// "If a child process could not be created, or its
+			// status could not be retrieved, the return value
+			// is -1."
+			terminationData = -1
+		}
+	}
+
+	// Deal with the return values.
+	if terminatedSuccessfully {
+		l.PushBoolean(true)
+	} else {
+		l.PushNil()
+	}
+
+	l.PushString(terminationReason)
+	l.PushInteger(terminationData)
+
+	return 3
+}
+
+func setField(l *State, key string, value int) {
+	l.PushInteger(value)
+	l.SetField(-2, key)
+}
+
+func setBoolField(l *State, key string, value bool) {
+	l.PushBoolean(value)
+	l.SetField(-2, key)
+}
+
+// dstDelta returns how far daylight saving time moves the clock in the time
+// zone of t, searching the zone periods adjacent to t for one whose daylight
+// saving state differs. It returns 0 for zones without daylight saving time.
+func dstDelta(t time.Time) time.Duration {
+	_, offset := t.Zone()
+	start, end := t.ZoneBounds()
+	for _, u := range []time.Time{start.Add(-time.Second), end} {
+		if !u.IsZero() && u.IsDST() != t.IsDST() {
+			_, other := u.Zone()
+			if t.IsDST() {
+				return time.Duration(offset-other) * time.Second
+			}
+			return time.Duration(other-offset) * time.Second
+		}
+	}
+	return 0
+}
+
+const strftimeConversions = "aAbBcdHIjmMpSUwWxXyYzZ%" // C89 conversions, plus %z
+
+func strftime(b *bytes.Buffer, format string, t time.Time) error {
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			b.WriteByte(format[i])
+			continue
+		}
+		if i++; i >= len(format) || strings.IndexByte(strftimeConversions, format[i]) < 0 {
+			return fmt.Errorf("invalid conversion specifier '%%%s'", format[i:min(i+1, len(format))])
+		}
+		switch format[i] {
+		case 'a':
+			b.WriteString(t.Format("Mon"))
+		case 'A':
+			b.WriteString(t.Format("Monday"))
+		case 'b':
+			b.WriteString(t.Format("Jan"))
+		case 'B':
+			b.WriteString(t.Format("January"))
+		case 'c':
+			b.WriteString(t.Format("Mon Jan _2 15:04:05 2006"))
+		case 'd':
+			fmt.Fprintf(b, "%02d", t.Day())
+		case 'H':
+			fmt.Fprintf(b, "%02d", t.Hour())
+		case 'I':
+			fmt.Fprintf(b, "%02d", (t.Hour()+11)%12+1)
+		case 'j':
+			fmt.Fprintf(b, "%03d", t.YearDay())
+		case 'm':
+			fmt.Fprintf(b, "%02d", int(t.Month()))
+		case 'M':
+			fmt.Fprintf(b, "%02d", t.Minute())
+		case 'p':
+			b.WriteString(t.Format("PM"))
+		case 'S':
+			fmt.Fprintf(b, "%02d", t.Second())
+		case 'U': // week of the year, with Sunday as the first day of the week
+			fmt.Fprintf(b, "%02d", (t.YearDay()+6-int(t.Weekday()))/7)
+		case 'w':
+			fmt.Fprintf(b, "%d", int(t.Weekday()))
+		case 'W': // week of the year, with Monday as the first day of the week
+			fmt.Fprintf(b, "%02d", (t.YearDay()+6-(int(t.Weekday())+6)%7)/7)
+		case 'x':
+			b.WriteString(t.Format("01/02/06"))
+		case 'X':
+			b.WriteString(t.Format("15:04:05"))
+		case 'y':
+			fmt.Fprintf(b, "%02d", t.Year()%100)
+		case 'Y':
+			fmt.Fprintf(b, "%d", t.Year())
+		case 'z':
+			b.WriteString(t.Format("-0700"))
+		case 'Z':
+			b.WriteString(t.Format("MST"))
+		case '%':
+			b.WriteByte('%')
+		}
+	}
+	return nil
+}
+
 var osLibrary = []RegistryFunction{
 	{"clock", clock},
-	// {"date", os_date},
+	{"date", func(l *State) int {
+		format := OptString(l, 1, "%c")
+		t := l.now()
+		if !l.IsNoneOrNil(2) {
+			t = time.Unix(int64(CheckNumber(l, 2)), 0)
+		}
+		if strings.HasPrefix(format, "!") { // UTC?
+			t, format = t.UTC(), format[1:] // skip '!'
+		} else {
+			t = t.In(l.location())
+		}
+		if strings.HasPrefix(format, "*t") {
+			l.CreateTable(0, 9) // 9 = number of fields
+			setField(l, "sec", t.Second())
+			setField(l, "min", t.Minute())
+			setField(l, "hour", t.Hour())
+			setField(l, "day", t.Day())
+			setField(l, "month", int(t.Month()))
+			setField(l, "year", t.Year())
+			setField(l, "wday", int(t.Weekday())+1)
+			setField(l, "yday", t.YearDay())
+			setBoolField(l, "isdst", t.IsDST())
+		} else {
+			var b bytes.Buffer
+			if err := strftime(&b, format, t); err != nil {
+				ArgumentError(l, 1, err.Error())
+			}
+			l.PushString(b.String())
+		}
+		return 1
+	}},
 	{"difftime", func(l *State) int {
 		l.PushNumber(time.Unix(int64(CheckNumber(l, 1)), 0).Sub(time.Unix(int64(OptNumber(l, 2, 0)), 0)).Seconds())
 		return 1
@@ -34,60 +204,16 @@ var osLibrary = []RegistryFunction{
 	// https://www.lua.org/manual/5.2/manual.html#pdf-os.execute
 	{"execute", func(l *State) int {
 		c := OptString(l, 1, "")
-
+		p, err := processRunner(l).Start(c, "")
+		if err == nil {
+			err = p.Close()
+		}
 		if c == "" {
-			// Check whether "sh" is available on the system.
-			err := exec.Command("sh").Run()
+			// Report whether a shell is available.
 			l.PushBoolean(err == nil)
 			return 1
 		}
-
-		terminatedSuccessfully := true
-		terminationReason := "exit"
-		terminationData := 0
-
-		// Create the command.
-		cmd := exec.Command("sh", "-c", c)
-		cmd.Stdout = l.global.stdout
-		cmd.Stderr = l.global.stderr
-
-		// Run the command.
-		if err := cmd.Run(); err != nil {
-			terminatedSuccessfully = false
-			terminationReason = "exit"
-			terminationData = 1
-
-			if exiterr, ok := err.(*exec.ExitError); ok {
-				if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
-					if status.Signaled() {
-						terminationReason = "signal"
-						terminationData = int(status.Signal())
-					} else {
-						terminationData = status.ExitStatus()
-					}
-				} else {
-					// Unsupported system?
-				}
-			} else {
-				// From man 3 system:
-				// "If a child process could not be created, or its
-				// status could not be retrieved, the return value
-				// is -1."
-				terminationData = -1
-			}
-		}
-
-		// Deal with the return values.
-		if terminatedSuccessfully {
-			l.PushBoolean(true)
-		} else {
-			l.PushNil()
-		}
-
-		l.PushString(terminationReason)
-		l.PushInteger(terminationData)
-
-		return 3
+		return execResult(l, err)
 	}},
 	{"exit", func(l *State) int {
 		var status int
@@ -105,8 +231,8 @@ var osLibrary = []RegistryFunction{
 		panic("unreachable")
 	}},
 	{"getenv", func(l *State) int { l.PushString(os.Getenv(CheckString(l, 1))); return 1 }},
-	{"remove", func(l *State) int { name := CheckString(l, 1); return FileResult(l, os.Remove(name), name) }},
-	{"rename", func(l *State) int { return FileResult(l, os.Rename(CheckString(l, 1), CheckString(l, 2)), "") }},
+	{"remove", func(l *State) int { name := CheckString(l, 1); return FileResult(l, removeFile(l, name), name) }},
+	{"rename", func(l *State) int { return FileResult(l, renameFile(l, CheckString(l, 1), CheckString(l, 2)), "") }},
 	// {"setlocale", func(l *State) int {
 	// 	op := CheckOption(l, 2, "all", []string{"all", "collate", "ctype", "monetary", "numeric", "time"})
 	// 	l.PushString(setlocale([]int{LC_ALL, LC_COLLATE, LC_CTYPE, LC_MONETARY, LC_NUMERIC, LC_TIME}, OptString(l, 1, "")))
@@ -114,28 +240,38 @@ var osLibrary = []RegistryFunction{
 	// }},
 	{"time", func(l *State) int {
 		if l.IsNoneOrNil(1) {
-			l.PushNumber(float64(time.Now().Unix()))
+			l.PushNumber(float64(l.now().Unix()))
 		} else {
 			CheckType(l, 1, TypeTable)
 			l.SetTop(1)
-			year := field(l, "year", -1) - 1900
-			month := field(l, "month", -1) - 1
-			day := field(l, "day", -1)
-			hour := field(l, "hour", 12)
-			min := field(l, "min", 0)
 			sec := field(l, "sec", 0)
-			// dst := boolField(l, "isdst") // TODO how to use dst?
-			l.PushNumber(float64(time.Date(year, time.Month(month), day, hour, min, sec, 0, time.Local).Unix()))
+			min := field(l, "min", 0)
+			hour := field(l, "hour", 12)
+			day := field(l, "day", -1)
+			month := field(l, "month", -1)
+			year := field(l, "year", -1)
+			l.Field(-1, "isdst")
+			dst, hasDST := l.ToBoolean(-1), !l.IsNil(-1)
+			l.Pop(1)
+			t := time.Date(year, time.Month(month), day, hour, min, sec, 0, l.location())
+			if hasDST && dst != t.IsDST() { // interpret the fields as daylight saving time or not, as requested
+				if dst {
+					t = t.Add(-dstDelta(t))
+				} else {
+					t = t.Add(dstDelta(t))
+				}
+			}
+			l.PushNumber(float64(t.Unix()))
 		}
 		return 1
 	}},
 	{"tmpname", func(l *State) int {
-		f, err := ioutil.TempFile("", "lua_")
+		f, name, err := createTempFile(l)
 		if err != nil {
 			Errorf(l, "unable to generate a unique filename")
 		}
 		defer f.Close()
-		l.PushString(f.Name())
+		l.PushString(name)
 		return 1
 	}},
 }
@@ -0,0 +1,91 @@
+package lua
+
+import (
+	"testing"
+	"time"
+)
+
+func testStringWithClock(t *testing.T, now time.Time, loc *time.Location, s string) {
+	l := NewState()
+	OpenLibraries(l)
+	l.SetClock(func() time.Time { return now })
+	l.SetLocation(loc)
+	if err := DoString(l, s); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOSDate(t *testing.T) {
+	now := time.Date(2009, time.February, 13, 23, 31, 30, 0, time.UTC)
+	testStringWithClock(t, now, time.FixedZone("EST", -5*60*60), `
+		assert(os.time() == 1234567890)
+		assert(os.date("!%Y-%m-%d %H:%M:%S") == "2009-02-13 23:31:30")
+		assert(os.date("%Y-%m-%d %H:%M:%S %Z %z") == "2009-02-13 18:31:30 EST -0500")
+		assert(os.date("!%c") == "Fri Feb 13 23:31:30 2009", os.date("!%c"))
+		assert(os.date("!%x %X %p %I") == "02/13/09 23:31:30 PM 11")
+		assert(os.date("!%a %A %b %B %d %j %w %y %%") == "Fri Friday Feb February 13 044 5 09 %")
+		assert(os.date("!%U %W", 0) == "00 00")
+		assert(os.date("!%U %W") == "06 06")
+		assert(os.date("!%H", 3600) == "01")
+		assert(not pcall(os.date, "%Ez"))
+		assert(not pcall(os.date, "%"))
+	`)
+}
+
+func TestOSDateTable(t *testing.T) {
+	now := time.Date(2009, time.February, 13, 23, 31, 30, 0, time.UTC)
+	testStringWithClock(t, now, time.FixedZone("EST", -5*60*60), `
+		local d = os.date("!*t")
+		assert(d.year == 2009 and d.month == 2 and d.day == 13)
+		assert(d.hour == 23 and d.min == 31 and d.sec == 30)
+		assert(d.wday == 6 and d.yday == 44 and d.isdst == false)
+		d = os.date("*t")
+		assert(d.hour == 18)
+		assert(os.time(d) == os.time())
+		assert(os.time({year = 2009, month = 2, day = 13, hour = 18, min = 31, sec = 30}) == 1234567890)
+		assert(os.time({year = 2009, month = 2, day = 14}) == os.time({year = 2009, month = 2, day = 13, hour = 36}))
+		assert(not pcall(os.time, {year = 2009, month = 2}))
+	`)
+}
+
+func TestOSTimeDaylightSaving(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone database unavailable: %s", err)
+	}
+	testStringWithClock(t, time.Now(), loc, `
+		local winter = os.time({year = 2020, month = 1, day = 1, hour = 12})
+		assert(os.date("*t", winter).isdst == false)
+		assert(os.time({year = 2020, month = 1, day = 1, hour = 12, isdst = true}) == winter - 3600)
+		local summer = os.time({year = 2020, month = 7, day = 1, hour = 12})
+		assert(os.date("*t", summer).isdst == true)
+		assert(os.time({year = 2020, month = 7, day = 1, hour = 12, isdst = false}) == summer + 3600)
+		assert(os.time({year = 2020, month = 7, day = 1, hour = 12, isdst = true}) == summer)
+	`)
+}
+
+func TestOSExecuteProcessRunner(t *testing.T) {
+	l := NewState()
+	OpenLibraries(l)
+	err := DoString(l, `
+		assert(os.execute() == true)
+		local ok, what, code = os.execute("exit 3")
+		assert(ok == nil and what == "exit" and code == 3)
+	`)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	l.SetProcessRunner(denyRunner{})
+	err = DoString(l, `
+		assert(os.execute() == false)
+		local ok, what, code = os.execute("touch denied")
+		assert(ok == nil and what == "exit" and code == -1)
+	`)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	l.SetProcessRunner(stubRunner{})
+	if err = DoString(l, `assert(select(3, os.execute("stub")) == 4)`); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+}
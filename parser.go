@@ -173,6 +173,9 @@ func (p *parser) simpleExpression() (e exprDesc) {
 	case tkNumber:
 		e = makeExpression(kindNumber, 0)
 		e.value = p.n
+	case tkInteger:
+		e = makeExpression(kindNumber, 0)
+		e.value = p.i
 	case tkString:
 		e = p.function.EncodeString(p.s)
 	case tkNil:
@@ -207,6 +210,8 @@ func unaryOp(op rune) int {
 		return oprMinus
 	case '#':
 		return oprLength
+	case '~':
+		return oprBitwiseNot
 	}
 	return oprNoUnary
 }
@@ -225,6 +230,18 @@ func binaryOp(op rune) int {
 		return oprMod
 	case '^':
 		return oprPow
+	case tkFloorDivide:
+		return oprFloorDivide
+	case '&':
+		return oprBitwiseAnd
+	case '|':
+		return oprBitwiseOr
+	case '~':
+		return oprBitwiseXor
+	case tkShiftLeft:
+		return oprShiftLeft
+	case tkShiftRight:
+		return oprShiftRight
 	case tkConcat:
 		return oprConcat
 	case tkNE:
@@ -247,19 +264,37 @@ func binaryOp(op rune) int {
 	return oprNoBinary
 }
 
+// unaryOp and binaryOp return the operator for the current token, accepting
+// the bitwise operators only if they are enabled.
+func (p *parser) unaryOp() int {
+	if op := unaryOp(p.t); op != oprBitwiseNot || p.bitwise() {
+		return op
+	}
+	return oprNoUnary
+}
+
+func (p *parser) binaryOp() int {
+	if op := binaryOp(p.t); op < oprBitwiseAnd || op > oprShiftRight || p.bitwise() {
+		return op
+	}
+	return oprNoBinary
+}
+
 var priority []struct{ left, right int } = []struct{ left, right int }{
-	{6, 6}, {6, 6}, {7, 7}, {7, 7}, {7, 7}, // `+' `-' `*' `/' `%'
-	{10, 9}, {5, 4}, // ^, .. (right associative)
+	{10, 10}, {10, 10}, {11, 11}, {11, 11}, {11, 11}, // `+' `-' `*' `/' `%'
+	{14, 13}, {11, 11}, // ^ (right associative), //
+	{6, 6}, {4, 4}, {5, 5}, {7, 7}, {7, 7}, // &, |, ~, <<, >>
+	{9, 8}, // .. (right associative)
 	{3, 3}, {3, 3}, {3, 3}, // ==, <, <=
 	{3, 3}, {3, 3}, {3, 3}, // ~=, >, >=
 	{2, 2}, {1, 1}, // and, or
 }
 
-const unaryPriority = 8
+const unaryPriority = 12
 
 func (p *parser) subExpression(limit int) (e exprDesc, op int) {
 	p.enterLevel()
-	if u := unaryOp(p.t); u != oprNoUnary {
+	if u := p.unaryOp(); u != oprNoUnary {
 		line := p.lineNumber
 		p.next()
 		e, _ = p.subExpression(unaryPriority)
@@ -267,7 +302,7 @@ func (p *parser) subExpression(limit int) (e exprDesc, op int) {
 	} else {
 		e = p.simpleExpression()
 	}
-	op = binaryOp(p.t)
+	op = p.binaryOp()
 	for op != oprNoBinary && priority[op].left > limit {
 		line := p.lineNumber
 		p.next()
@@ -364,7 +399,7 @@ func (p *parser) forNumeric(name string, line int) {
 	if p.testNext(',') {
 		expr()
 	} else {
-		p.function.EncodeConstant(p.function.freeRegisterCount, p.function.NumberConstant(1))
+		p.function.EncodeConstant(p.function.freeRegisterCount, p.function.NumberConstant(p.integer(1)))
 		p.function.ReserveRegisters(1)
 	}
 	p.forBody(base, line, 1, true)
@@ -571,10 +606,34 @@ func (p *parser) localFunction() {
 	p.function.LocalVariable(p.body(false, p.lineNumber).info).startPC = pc(len(p.function.f.code))
 }
 
+func (p *parser) localAttribute() (readOnly, toBeClosed bool) {
+	if !p.attributes() || !p.testNext('<') {
+		return false, false
+	}
+	switch a := p.checkName(); a {
+	case "const":
+		readOnly = true
+	case "close":
+		readOnly, toBeClosed = true, true
+	default:
+		p.function.semanticError(fmt.Sprintf("unknown attribute '%s'", a))
+	}
+	p.checkNext('>')
+	return
+}
+
 func (p *parser) localStatement() {
-	v := 0
+	v, toBeClosed := 0, -1
 	for first := true; first || p.testNext(','); v++ {
 		p.function.MakeLocalVariable(p.checkName())
+		readOnly, closing := p.localAttribute()
+		p.function.f.localVariables[len(p.function.f.localVariables)-1].readOnly = readOnly
+		if closing {
+			if toBeClosed != -1 {
+				p.function.semanticError("multiple to-be-closed variables in local list")
+			}
+			toBeClosed = p.function.activeVariableCount + v
+		}
 		first = false
 	}
 	if p.testNext('=') {
@@ -585,6 +644,9 @@ func (p *parser) localStatement() {
 		p.function.AdjustAssignment(v, 0, e)
 	}
 	p.function.AdjustLocalVariables(v)
+	if toBeClosed != -1 {
+		p.function.MarkToBeClosed(toBeClosed)
+	}
 }
 
 func (p *parser) expressionStatement() {
@@ -662,7 +724,7 @@ func (p *parser) mainFunction() {
 func (l *State) StatementLines(code string) ([]int, error) {
 	r := bufio.NewReader(strings.NewReader(code))
 	p := &parser{
-		scanner: scanner{r: r, lineNumber: 1, lastLine: 1, lookAheadToken: token{t: tkEOS}, l: l, source: "=input"},
+		scanner: scanner{r: r, lineNumber: 1, lastLine: 1, lookAheadToken: token{t: tkEOS}, l: l, dialect: l.global.dialect, source: "=input"},
 		stmtLines: []int{},
 	}
 	f := &function{
@@ -691,8 +753,8 @@ func (l *State) StatementLines(code string) ([]int, error) {
 	return p.stmtLines, nil
 }
 
-func (l *State) parse(r io.ByteReader, name string) *luaClosure {
-	p := &parser{scanner: scanner{r: r, lineNumber: 1, lastLine: 1, lookAheadToken: token{t: tkEOS}, l: l, source: name}}
+func (l *State) parse(r io.ByteReader, name string, d Dialect) *luaClosure {
+	p := &parser{scanner: scanner{r: r, lineNumber: 1, lastLine: 1, lookAheadToken: token{t: tkEOS}, l: l, dialect: d, source: name}}
 	f := &function{f: &prototype{source: name, maxStackSize: 2, isVarArg: true}, constantLookup: make(map[value]int), p: p, jumpPC: noJump}
 	p.function = f
 	p.mainFunction()
@@ -709,22 +771,26 @@ func (l *State) checkMode(mode, x string) {
 	}
 }
 
-func protectedParser(l *State, r io.Reader, name, chunkMode string) error {
+func protectedParser(l *State, r io.Reader, name, chunkMode string, d Dialect) error {
 	l.nonYieldableCallCount++
 	err := l.protectedCall(func() {
 		var closure *luaClosure
 		b := bufio.NewReader(r)
 		if c, err := b.ReadByte(); err != nil {
 			l.checkMode(chunkMode, "text")
-			closure = l.parse(b, name)
+			closure = l.parse(b, name, d)
 		} else if c == Signature[0] {
 			l.checkMode(chunkMode, "binary")
 			b.UnreadByte()
-			closure, _ = l.undump(b, name) // TODO handle err
+			var err error
+			if closure, err = l.undump(b, name); err != nil {
+				l.push(undumpMessage(name, err))
+				l.throw(SyntaxError)
+			}
 		} else {
 			l.checkMode(chunkMode, "text")
 			b.UnreadByte()
-			closure = l.parse(b, name)
+			closure = l.parse(b, name, d)
 		}
 		l.assert(closure.upValueCount() == len(closure.prototype.upValues))
 		for i := range closure.upValues {
@@ -0,0 +1,154 @@
+package lua
+
+import "io"
+
+// An OpCode identifies the operation of a virtual machine instruction. Its
+// String method returns its name, as in the listings of luac, e.g. "MOVE".
+type OpCode uint8
+
+func (op OpCode) String() string {
+	if int(op) < len(opNames) {
+		return opNames[op]
+	}
+	return "?"
+}
+
+// An InstructionFormat tells how the operands of an instruction are encoded.
+type InstructionFormat int
+
+// The instruction formats. Every instruction has an A operand, except in
+// FormatAx.
+const (
+	FormatABC  = InstructionFormat(iABC)  // A, B and C operands
+	FormatABx  = InstructionFormat(iABx)  // A and unsigned Bx operands
+	FormatAsBx = InstructionFormat(iAsBx) // A and signed sBx operands
+	FormatAx   = InstructionFormat(iAx)   // a single Ax operand
+)
+
+// Format returns the format of instructions with operation op.
+func (op OpCode) Format() InstructionFormat {
+	if int(op) < len(opModes) {
+		return InstructionFormat(opMode(opCode(op)))
+	}
+	return FormatABC
+}
+
+// An Instruction is an encoded virtual machine instruction. Its methods
+// decode the operands of each format, whichever format the instruction has.
+type Instruction uint32
+
+func (i Instruction) OpCode() OpCode { return OpCode(instruction(i).opCode()) }
+func (i Instruction) A() int         { return instruction(i).a() }
+func (i Instruction) B() int         { return instruction(i).b() }
+func (i Instruction) C() int         { return instruction(i).c() }
+func (i Instruction) Bx() int        { return instruction(i).bx() }
+func (i Instruction) SBx() int       { return instruction(i).sbx() }
+func (i Instruction) Ax() int        { return instruction(i).ax() }
+
+// String returns the operation and operands of i, e.g. "ADD 1 1 constant 0".
+func (i Instruction) String() string {
+	if int(i.OpCode()) >= len(opNames) {
+		return "?"
+	}
+	return instruction(i).String()
+}
+
+// RKConstant reports whether a B or C operand which may refer to either a
+// register or a constant, such as the operands of ADD, refers to a constant,
+// and returns the index of that constant or register.
+func RKConstant(rk int) (index int, constant bool) {
+	if isConstant(rk) {
+		return constantIndex(rk), true
+	}
+	return rk, false
+}
+
+// An UpValueDesc describes how a closure captures an up value: from a
+// register of the enclosing function if InStack is true, and otherwise from
+// an up value of the enclosing function.
+type UpValueDesc struct {
+	Name    string // empty in stripped chunks
+	InStack bool
+	Index   int
+}
+
+// A LocalVariable describes the scope of a local variable, from the
+// instruction at StartPC up to, but not including, the instruction at EndPC.
+type LocalVariable struct {
+	Name           string
+	StartPC, EndPC int
+}
+
+// A Prototype is a read-only copy of the compiled code of a Lua function,
+// for static checks and disassemblers. Constants are nil, bool, float64,
+// string or, in the Integers dialect, int64 values. LineInfo holds the line
+// of each instruction, and is empty, like LocalVariables and the names of up
+// values, in stripped chunks.
+type Prototype struct {
+	Source                       string
+	LineDefined, LastLineDefined int
+	ParameterCount               int
+	IsVarArg                     bool
+	MaxStackSize                 int
+	Code                         []Instruction
+	Constants                    []interface{}
+	UpValues                     []UpValueDesc
+	LocalVariables               []LocalVariable
+	LineInfo                     []int
+	Prototypes                   []*Prototype // functions defined by this one, indexed by CLOSURE
+}
+
+func newPrototype(p *prototype) *Prototype {
+	v := &Prototype{
+		Source:          p.source,
+		LineDefined:     p.lineDefined,
+		LastLineDefined: p.lastLineDefined,
+		ParameterCount:  p.parameterCount,
+		IsVarArg:        p.isVarArg,
+		MaxStackSize:    p.maxStackSize,
+		Code:            make([]Instruction, len(p.code)),
+		Constants:       make([]interface{}, len(p.constants)),
+		UpValues:        make([]UpValueDesc, len(p.upValues)),
+		LocalVariables:  make([]LocalVariable, len(p.localVariables)),
+		LineInfo:        make([]int, len(p.lineInfo)),
+		Prototypes:      make([]*Prototype, len(p.prototypes)),
+	}
+	for i, c := range p.code {
+		v.Code[i] = Instruction(c)
+	}
+	for i, k := range p.constants {
+		v.Constants[i] = k
+	}
+	for i, u := range p.upValues {
+		v.UpValues[i] = UpValueDesc{Name: u.name, InStack: u.isLocal, Index: u.index}
+	}
+	for i, lv := range p.localVariables {
+		v.LocalVariables[i] = LocalVariable{Name: lv.name, StartPC: int(lv.startPC), EndPC: int(lv.endPC)}
+	}
+	for i, line := range p.lineInfo {
+		v.LineInfo[i] = int(line)
+	}
+	for i := range p.prototypes {
+		v.Prototypes[i] = newPrototype(&p.prototypes[i])
+	}
+	return v
+}
+
+// ToPrototype returns a copy of the compiled code of the Lua function at
+// index, or nil if the value is not a Lua function.
+func (l *State) ToPrototype(index int) *Prototype {
+	if f, ok := l.indexToValue(index).(*luaClosure); ok {
+		return newPrototype(f.prototype)
+	}
+	return nil
+}
+
+// ReadPrototype reads the main function of a binary chunk, as produced by
+// Dump or luac, without loading it into a State.
+func ReadPrototype(r io.Reader) (*Prototype, error) {
+	p, err := readChunk(r)
+	if err != nil {
+		return nil, err
+	}
+	return newPrototype(&p), nil
+}
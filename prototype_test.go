@@ -0,0 +1,91 @@
+package lua
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestToPrototype(t *testing.T) {
+	l := NewState()
+	if err := LoadString(l, "local t = {}\nfunction t.f(x, ...) return x + 1, 'a' end\nfor i = 1, 2 do end"); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	p := l.ToPrototype(-1)
+	if p.LineDefined != 0 || !p.IsVarArg || len(p.Prototypes) != 1 || len(p.LineInfo) != len(p.Code) {
+		t.Errorf("unexpected main function %+v", p)
+	}
+	if p.UpValues[0] != (UpValueDesc{Name: "_ENV", InStack: true}) {
+		t.Errorf("unexpected up value %+v", p.UpValues[0])
+	}
+	if p.LocalVariables[0].Name != "t" || p.LocalVariables[1].Name != "(for index)" {
+		t.Errorf("unexpected locals %+v", p.LocalVariables)
+	}
+	var forLoop Instruction
+	for _, i := range p.Code {
+		if i.OpCode().String() == "FORLOOP" {
+			forLoop = i
+		}
+	}
+	if forLoop.OpCode().Format() != FormatAsBx || forLoop.SBx() != -1 {
+		t.Errorf("unexpected loop instruction %s", forLoop)
+	}
+
+	f := p.Prototypes[0]
+	if f.LineDefined != 2 || f.ParameterCount != 1 || !f.IsVarArg || !reflect.DeepEqual(f.Constants, []interface{}{1.0, "a"}) {
+		t.Errorf("unexpected function %+v", f)
+	}
+	add := f.Code[0]
+	if add.OpCode().String() != "ADD" || add.OpCode().Format() != FormatABC || add.String() != "ADD 1 0 constant 0" {
+		t.Errorf("unexpected instruction %s", add)
+	}
+	if k, ok := RKConstant(add.C()); !ok || f.Constants[k] != 1.0 {
+		t.Errorf("expected constant operand but found %d, %v", k, ok)
+	}
+	if r, ok := RKConstant(add.B()); ok || r != 0 {
+		t.Errorf("expected register operand but found %d, %v", r, ok)
+	}
+	if f.LineInfo[0] != 2 || f.LocalVariables[0] != (LocalVariable{Name: "x", StartPC: 0, EndPC: len(f.Code)}) {
+		t.Errorf("unexpected debug information %v %+v", f.LineInfo, f.LocalVariables)
+	}
+
+	l.PushGoFunction(func(*State) int { return 0 })
+	if l.ToPrototype(-1) != nil {
+		t.Error("expected no prototype for a Go function")
+	}
+}
+
+func TestReadPrototype(t *testing.T) {
+	b, err := os.ReadFile("fixtures/fib.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := ReadPrototype(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	l := NewState()
+	if err := l.Load(bytes.NewReader(b), "fib", "b"); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if !reflect.DeepEqual(p, l.ToPrototype(-1)) {
+		t.Error("expected the same prototype when reading and loading a chunk")
+	}
+	if p.Source != "@fixtures/fib.lua" || len(p.Prototypes) != 3 {
+		t.Errorf("unexpected prototype %+v", p)
+	}
+
+	var stripped bytes.Buffer
+	if err := l.DumpStripped(&stripped); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if p, err = ReadPrototype(&stripped); err != nil {
+		t.Fatalf("error: %s", err)
+	} else if len(p.LineInfo) != 0 || len(p.LocalVariables) != 0 || p.UpValues[0].Name != "" {
+		t.Errorf("expected no debug information in %+v", p)
+	}
+	if _, err := ReadPrototype(bytes.NewReader(b[:20])); err == nil {
+		t.Error("expected an error for a truncated chunk")
+	}
+}
@@ -0,0 +1,730 @@
+package lua
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"sync"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+const maxConversionDepth = 200 // of tables converted to Go values
+
+// PushGoValue pushes v onto the stack, converting it to the closest Lua value:
+//
+//   - nil, and nil pointers, interfaces, channels and funcs, become nil.
+//   - Booleans, numbers and strings become their Lua counterparts.
+//   - A Function becomes a Go function, exactly as with PushGoFunction.
+//   - Any other func becomes a Go function which converts its arguments with
+//     ToGoValue and pushes its results with PushGoValue. A non-nil error as
+//     the last result is raised as a Lua error.
+//   - Everything else, i.e. structs, pointers, slices, arrays, maps and
+//     channels, becomes a userdata holding v, with a metatable generated for
+//     the type of v.
+//
+// From Lua, the exported fields and methods of a struct (or pointer to
+// struct) are accessed by name, or by the name given in a `lua:"name"` field
+// tag; a tag of "-" hides the field. Fields can only be assigned through a
+// pointer. Slices and arrays are indexed from 1, maps by their keys, and
+// assigning nil to a map key deletes it. The # operator and pairs work on all
+// of these, and a channel has the methods send, receive and close.
+//
+// Userdata can be converted back with ToGoValue, or ToUserData.
+func (l *State) PushGoValue(v interface{}) { l.pushReflect(reflect.ValueOf(v)) }
+
+// ToGoValue converts the Lua value at index to the Go value pointed to by v,
+// which must be a non-nil pointer. It is the inverse of PushGoValue: numbers
+// and strings convert to the Go numeric and string types, tables to structs,
+// slices, arrays and maps, Lua functions to funcs, and userdata to the type
+// of the Go value they hold. Converting to an empty interface produces nil,
+// bool, float64, string, the value held by a userdata, or, for a table,
+// []interface{} if it is a sequence, or else a map[string]interface{} or
+// map[interface{}]interface{}.
+//
+// A func converted from a Lua function calls it on l, so it must be called on
+// the goroutine running l, and only while l is not running other Lua code,
+// e.g. from a Go function called by Lua. If the func has an error as its last
+// result, Lua errors are returned through it; otherwise they panic.
+//
+// If the value cannot be converted, an error describes where and why, e.g.
+// "field 'Tags': index 2: cannot convert table to string". The value at
+// index is never modified.
+func (l *State) ToGoValue(index int, v interface{}) error {
+	p := reflect.ValueOf(v)
+	if p.Kind() != reflect.Ptr || p.IsNil() {
+		return fmt.Errorf("ToGoValue of non-pointer %T", v)
+	}
+	r, err := l.toGoValue(index, p.Type().Elem())
+	if err == nil {
+		p.Elem().Set(r)
+	}
+	return err
+}
+
+func (l *State) pushReflect(v reflect.Value) {
+	if !v.IsValid() {
+		l.PushNil()
+		return
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		l.PushBoolean(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		l.PushInteger64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if u := v.Uint(); u <= math.MaxInt64 {
+			l.PushInteger64(int64(u))
+		} else {
+			l.PushNumber(float64(u))
+		}
+	case reflect.Float32, reflect.Float64:
+		l.PushNumber(v.Float())
+	case reflect.String:
+		l.PushString(v.String())
+	case reflect.Interface:
+		l.pushReflect(v.Elem())
+	case reflect.Func:
+		if v.IsNil() {
+			l.PushNil()
+		} else if f, ok := v.Interface().(Function); ok {
+			l.PushGoFunction(f)
+		} else {
+			l.PushGoFunction(reflectFunction(v))
+		}
+	case reflect.Ptr, reflect.Chan:
+		if v.IsNil() {
+			l.PushNil()
+			return
+		}
+		fallthrough
+	default:
+		l.PushUserData(v.Interface())
+		l.apiPush(l.goMetaTable(v.Type()))
+		l.SetMetaTable(-2)
+	}
+}
+
+// reflectFunction adapts a func of any signature to a Function.
+func reflectFunction(f reflect.Value) Function {
+	t := f.Type()
+	return func(l *State) int {
+		n := t.NumIn()
+		if t.IsVariadic() {
+			n--
+		}
+		in := make([]reflect.Value, 0, n)
+		for i := 0; i < n; i++ {
+			in = append(in, checkGoValue(l, i+1, t.In(i)))
+		}
+		if t.IsVariadic() {
+			for i, e := n+1, t.In(n).Elem(); i <= l.Top(); i++ {
+				in = append(in, checkGoValue(l, i, e))
+			}
+		}
+		out := f.Call(in)
+		if n := len(out); n > 0 && t.Out(n-1) == errorType {
+			if err := out[n-1]; !err.IsNil() {
+				Errorf(l, "%s", err.Interface().(error).Error())
+			}
+			out = out[:n-1]
+		}
+		for _, r := range out {
+			l.pushReflect(r)
+		}
+		return len(out)
+	}
+}
+
+func checkGoValue(l *State, index int, t reflect.Type) reflect.Value {
+	v, err := l.toGoValue(index, t)
+	if err != nil {
+		ArgumentError(l, index, err.Error())
+	}
+	return v
+}
+
+func (l *State) goMetaTable(t reflect.Type) *table {
+	if mt, ok := l.global.goMetaTables[t]; ok {
+		return mt
+	}
+	l.NewTable()
+	l.NewTable() // methods, shared as upvalue by the metamethods
+	for i := 0; i < t.NumMethod(); i++ {
+		m := t.Method(i)
+		l.PushGoFunction(reflectFunction(m.Func))
+		l.SetField(-2, m.Name)
+	}
+	if t.Kind() == reflect.Chan {
+		SetFunctions(l, []RegistryFunction{
+			{"send", goSend},
+			{"receive", goReceive},
+			{"close", goClose},
+		}, 0)
+	}
+	SetFunctions(l, []RegistryFunction{
+		{"__index", goIndex},
+		{"__newindex", goNewIndex},
+		{"__len", goLength},
+		{"__pairs", goPairs},
+		{"__eq", goEqual},
+		{"__tostring", goToString},
+	}, 1)
+	mt := l.indexToValue(-1).(*table)
+	l.Pop(1)
+	if l.global.goMetaTables == nil {
+		l.global.goMetaTables = make(map[reflect.Type]*table)
+	}
+	l.global.goMetaTables[t] = mt
+	return mt
+}
+
+func toReflect(l *State, index int) reflect.Value {
+	return reflect.ValueOf(l.ToUserData(index))
+}
+
+// structOf returns the struct held by v, if any, directly or through a pointer.
+func structOf(v reflect.Value) (reflect.Value, bool) {
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	return v, v.Kind() == reflect.Struct
+}
+
+// sequenceOf returns the slice or array held by v, if any, directly or
+// through a pointer.
+func sequenceOf(v reflect.Value) (reflect.Value, bool) {
+	if v.Kind() == reflect.Ptr && v.Elem().Kind() == reflect.Array {
+		v = v.Elem()
+	}
+	return v, v.Kind() == reflect.Slice || v.Kind() == reflect.Array
+}
+
+// pushElement pushes a field or element of a Go value. Addressable structs
+// are pushed by reference, so that assignments to their fields take effect.
+func (l *State) pushElement(v reflect.Value) {
+	if v.Kind() == reflect.Struct && v.CanAddr() {
+		v = v.Addr()
+	}
+	l.pushReflect(v)
+}
+
+func (l *State) sequenceIndex(index, length int) (int, bool) {
+	n, ok := l.ToNumber(index)
+	i := int(n)
+	return i - 1, ok && float64(i) == n && i >= 1 && i <= length
+}
+
+var fieldCache sync.Map // map[reflect.Type]map[string][]int
+
+// fieldsOf returns the index sequences of the visible exported fields of the
+// struct type t, keyed by their Lua names.
+func fieldsOf(t reflect.Type) map[string][]int {
+	if fields, ok := fieldCache.Load(t); ok {
+		return fields.(map[string][]int)
+	}
+	fields := make(map[string][]int)
+	for _, f := range reflect.VisibleFields(t) {
+		name := f.Name
+		if tag, ok := f.Tag.Lookup("lua"); ok {
+			name = tag
+		}
+		if f.IsExported() && name != "-" {
+			fields[name] = f.Index
+		}
+	}
+	fieldCache.Store(t, fields)
+	return fields
+}
+
+func fieldByName(s reflect.Value, name string) (reflect.Value, bool) {
+	i, ok := fieldsOf(s.Type())[name]
+	if !ok {
+		return reflect.Value{}, false
+	}
+	f, err := s.FieldByIndexErr(i)
+	return f, err == nil
+}
+
+func goIndex(l *State) int {
+	v := toReflect(l, 1)
+	if name, ok := l.indexToValue(2).(string); ok {
+		if s, ok := structOf(v); ok {
+			if f, ok := fieldByName(s, name); ok {
+				l.pushElement(f)
+				return 1
+			}
+		}
+		l.Field(UpValueIndex(1), name)
+		if !l.IsNil(-1) {
+			return 1
+		}
+		l.Pop(1)
+	}
+	if s, ok := sequenceOf(v); ok {
+		if i, ok := l.sequenceIndex(2, s.Len()); ok {
+			l.pushElement(s.Index(i))
+			return 1
+		}
+	} else if v.Kind() == reflect.Map {
+		if k, err := l.toGoValue(2, v.Type().Key()); err == nil {
+			l.pushReflect(v.MapIndex(k))
+			return 1
+		}
+	}
+	l.PushNil()
+	return 1
+}
+
+func goNewIndex(l *State) int {
+	v := toReflect(l, 1)
+	if s, ok := structOf(v); ok {
+		name := CheckString(l, 2)
+		f, ok := fieldByName(s, name)
+		if !ok {
+			Errorf(l, "%s has no field '%s'", s.Type(), name)
+		} else if !f.CanSet() {
+			Errorf(l, "cannot assign to field '%s' of %s (not a pointer)", name, s.Type())
+		}
+		x, err := l.toGoValue(3, f.Type())
+		if err != nil {
+			Errorf(l, "cannot assign to field '%s': %s", name, err.Error())
+		}
+		f.Set(x)
+	} else if s, ok := sequenceOf(v); ok {
+		i, ok := l.sequenceIndex(2, s.Len())
+		if !ok {
+			Errorf(l, "index out of range")
+		} else if !s.Index(i).CanSet() {
+			Errorf(l, "cannot assign to element of %s (not a pointer)", s.Type())
+		}
+		x, err := l.toGoValue(3, s.Type().Elem())
+		if err != nil {
+			Errorf(l, "cannot assign to index %d: %s", i+1, err.Error())
+		}
+		s.Index(i).Set(x)
+	} else if v.Kind() == reflect.Map {
+		k, err := l.toGoValue(2, v.Type().Key())
+		if err != nil {
+			Errorf(l, "invalid key: %s", err.Error())
+		}
+		if l.IsNil(3) {
+			v.SetMapIndex(k, reflect.Value{})
+			return 0
+		}
+		x, err := l.toGoValue(3, v.Type().Elem())
+		if err != nil {
+			Errorf(l, "cannot assign to key: %s", err.Error())
+		}
+		v.SetMapIndex(k, x)
+	} else {
+		Errorf(l, "cannot assign to %s", v.Type())
+	}
+	return 0
+}
+
+func goLength(l *State) int {
+	v := toReflect(l, 1)
+	if s, ok := sequenceOf(v); ok {
+		v = s
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.Chan:
+		l.PushInteger(v.Len())
+		return 1
+	}
+	Errorf(l, "attempt to get length of %s", v.Type())
+	panic("unreachable")
+}
+
+func goPairs(l *State) int {
+	var keys []reflect.Value
+	var value func(reflect.Value) reflect.Value
+	v := toReflect(l, 1)
+	if s, ok := structOf(v); ok {
+		for name := range fieldsOf(s.Type()) {
+			keys = append(keys, reflect.ValueOf(name))
+		}
+		value = func(k reflect.Value) reflect.Value { f, _ := fieldByName(s, k.String()); return f }
+	} else if s, ok := sequenceOf(v); ok {
+		for i := 1; i <= s.Len(); i++ {
+			keys = append(keys, reflect.ValueOf(i))
+		}
+		value = func(k reflect.Value) reflect.Value { return s.Index(int(k.Int()) - 1) }
+	} else if v.Kind() == reflect.Map {
+		keys, value = v.MapKeys(), v.MapIndex
+	} else {
+		Errorf(l, "cannot iterate over %s", v.Type())
+	}
+	l.PushGoFunction(func(l *State) int {
+		for len(keys) > 0 {
+			k := keys[0]
+			keys = keys[1:]
+			if e := value(k); e.IsValid() { // skip keys deleted during the traversal
+				l.pushReflect(k)
+				l.pushElement(e)
+				return 2
+			}
+		}
+		return 0
+	})
+	l.PushValue(1)
+	l.PushNil()
+	return 3
+}
+
+func goEqual(l *State) int {
+	a, b := toReflect(l, 1), toReflect(l, 2)
+	l.PushBoolean(a.Type() == b.Type() && a.Comparable() && a.Equal(b))
+	return 1
+}
+
+func goToString(l *State) int {
+	l.PushString(fmt.Sprint(l.ToUserData(1)))
+	return 1
+}
+
+func goSend(l *State) int {
+	c := toReflect(l, 1)
+	c.Send(checkGoValue(l, 2, c.Type().Elem()))
+	return 0
+}
+
+func goReceive(l *State) int {
+	v, ok := toReflect(l, 1).Recv()
+	l.pushReflect(v)
+	l.PushBoolean(ok)
+	return 2
+}
+
+func goClose(l *State) int {
+	toReflect(l, 1).Close()
+	return 0
+}
+
+func (l *State) conversionError(index int, t reflect.Type) error {
+	return fmt.Errorf("cannot convert %s to %s", TypeNameOf(l, index), t)
+}
+
+// toGoValue converts the value at index to a Go value of type t, without
+// modifying the value at index.
+func (l *State) toGoValue(index int, t reflect.Type) (reflect.Value, error) {
+	c := goConversion{l: l}
+	return c.value(index, t)
+}
+
+// A goConversion converts a Lua value to Go. It keeps the tables being
+// converted, from the outermost one in, so that a table which contains
+// itself, or nesting deeper than the Go stack allows, is an error.
+type goConversion struct {
+	l      *State
+	tables []*table
+}
+
+// enter adds the table at index to the tables being converted.
+func (c *goConversion) enter(index int) error {
+	t := c.l.indexToValue(index).(*table)
+	if slices.Contains(c.tables, t) {
+		return errors.New("cannot convert a table which contains itself")
+	} else if len(c.tables) >= maxConversionDepth {
+		return errors.New("table too deeply nested")
+	}
+	c.tables = append(c.tables, t)
+	return nil
+}
+
+func (c *goConversion) leave() { c.tables = c.tables[:len(c.tables)-1] }
+
+func (c *goConversion) value(index int, t reflect.Type) (reflect.Value, error) {
+	l := c.l
+	index = l.AbsIndex(index)
+	if l.IsUserData(index) {
+		if d := reflect.ValueOf(l.ToUserData(index)); d.IsValid() && d.Type().AssignableTo(t) {
+			v := reflect.New(t).Elem()
+			v.Set(d)
+			return v, nil
+		}
+	}
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Interface:
+		if l.IsNil(index) {
+			return v, nil
+		} else if t.NumMethod() == 0 {
+			x, err := c.toInterface(index)
+			if err == nil && x != nil {
+				v.Set(reflect.ValueOf(x))
+			}
+			return v, err
+		}
+	case reflect.Bool:
+		if l.IsBoolean(index) {
+			v.SetBool(l.ToBoolean(index))
+			return v, nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, ok := l.indexToValue(index).(int64); ok {
+			if v.OverflowInt(i) {
+				return v, fmt.Errorf("number %d overflows %s", i, t)
+			}
+			v.SetInt(i)
+			return v, nil
+		} else if n, ok := l.ToNumber(index); ok {
+			if n != math.Trunc(n) {
+				return v, fmt.Errorf("number %s has no integer representation", numberToString(n))
+			} else if n < math.MinInt64 || n >= math.MaxInt64 || v.OverflowInt(int64(n)) {
+				return v, fmt.Errorf("number %s overflows %s", numberToString(n), t)
+			}
+			v.SetInt(int64(n))
+			return v, nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if i, ok := l.indexToValue(index).(int64); ok {
+			if i < 0 || v.OverflowUint(uint64(i)) {
+				return v, fmt.Errorf("number %d overflows %s", i, t)
+			}
+			v.SetUint(uint64(i))
+			return v, nil
+		} else if n, ok := l.ToNumber(index); ok {
+			if n != math.Trunc(n) {
+				return v, fmt.Errorf("number %s has no integer representation", numberToString(n))
+			} else if n < 0 || n >= math.MaxUint64 || v.OverflowUint(uint64(n)) {
+				return v, fmt.Errorf("number %s overflows %s", numberToString(n), t)
+			}
+			v.SetUint(uint64(n))
+			return v, nil
+		}
+	case reflect.Float32, reflect.Float64:
+		if n, ok := l.ToNumber(index); ok {
+			v.SetFloat(n)
+			return v, nil
+		}
+	case reflect.String:
+		switch x := l.indexToValue(index).(type) {
+		case string:
+			v.SetString(x)
+			return v, nil
+		case float64, int64:
+			s, _ := l.valueToString(x)
+			v.SetString(s)
+			return v, nil
+		}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			if s, ok := l.indexToValue(index).(string); ok {
+				v.SetBytes([]byte(s))
+				return v, nil
+			}
+		}
+		if l.IsTable(index) {
+			if err := c.enter(index); err != nil {
+				return v, err
+			}
+			defer c.leave()
+			n := l.RawLength(index)
+			v.Set(reflect.MakeSlice(t, n, n))
+			return v, c.sequence(index, v)
+		}
+	case reflect.Array:
+		if l.IsTable(index) {
+			if n := l.RawLength(index); n > t.Len() {
+				return v, fmt.Errorf("table of length %d overflows %s", n, t)
+			} else if err := c.enter(index); err != nil {
+				return v, err
+			}
+			defer c.leave()
+			return v, c.sequence(index, v)
+		}
+	case reflect.Map:
+		if l.IsTable(index) {
+			if err := c.enter(index); err != nil {
+				return v, err
+			}
+			defer c.leave()
+			v.Set(reflect.MakeMap(t))
+			err := c.table(index, func(k int) error {
+				key, err := c.value(k, t.Key())
+				if err != nil {
+					return fmt.Errorf("key %s: %w", l.describeKey(k), err)
+				}
+				x, err := c.value(k+1, t.Elem())
+				if err != nil {
+					return fmt.Errorf("key %s: %w", l.describeKey(k), err)
+				}
+				v.SetMapIndex(key, x)
+				return nil
+			})
+			return v, err
+		}
+	case reflect.Struct:
+		if l.IsTable(index) {
+			if err := c.enter(index); err != nil {
+				return v, err
+			}
+			defer c.leave()
+			err := c.table(index, func(k int) error {
+				name, ok := l.indexToValue(k).(string)
+				if !ok {
+					return fmt.Errorf("key %s: %s has no such field", l.describeKey(k), t)
+				}
+				f, ok := fieldByName(v, name)
+				if !ok {
+					return fmt.Errorf("%s has no field '%s'", t, name)
+				}
+				x, err := c.value(k+1, f.Type())
+				if err != nil {
+					return fmt.Errorf("field '%s': %w", name, err)
+				}
+				f.Set(x)
+				return nil
+			})
+			return v, err
+		}
+	case reflect.Ptr:
+		if l.IsNil(index) {
+			return v, nil
+		}
+		e, err := c.value(index, t.Elem())
+		if err == nil {
+			v.Set(reflect.New(t.Elem()))
+			v.Elem().Set(e)
+		}
+		return v, err
+	case reflect.Func:
+		if l.IsNil(index) {
+			return v, nil
+		} else if l.IsFunction(index) {
+			return l.luaFunction(l.indexToValue(index), t), nil
+		}
+	case reflect.Chan:
+		if l.IsNil(index) {
+			return v, nil
+		}
+	}
+	return v, l.conversionError(index, t)
+}
+
+func (l *State) describeKey(index int) string {
+	switch k := l.indexToValue(index).(type) {
+	case string:
+		return fmt.Sprintf("'%s'", k)
+	case float64, int64:
+		s, _ := l.valueToString(k)
+		return s
+	}
+	return TypeNameOf(l, index)
+}
+
+// sequence converts the elements 1..#t of the table at index to the
+// elements of the slice or array v.
+func (c *goConversion) sequence(index int, v reflect.Value) error {
+	l := c.l
+	CheckStackWithMessage(l, 1, "table too deeply nested")
+	for i := 0; i < l.RawLength(index) && i < v.Len(); i++ {
+		l.RawGetInt(index, i+1)
+		x, err := c.value(-1, v.Type().Elem())
+		l.Pop(1)
+		if err != nil {
+			return fmt.Errorf("index %d: %w", i+1, err)
+		}
+		v.Index(i).Set(x)
+	}
+	return nil
+}
+
+// table calls f for each key-value pair of the table at index, with the
+// key at stack index k and the value at k+1.
+func (c *goConversion) table(index int, f func(k int) error) error {
+	l := c.l
+	CheckStackWithMessage(l, 2, "table too deeply nested")
+	for l.PushNil(); l.Next(index); l.Pop(1) {
+		if err := f(l.Top() - 1); err != nil {
+			l.Pop(2)
+			return err
+		}
+	}
+	return nil
+}
+
+// toInterface converts the value at index to the natural Go representation
+// for an empty interface.
+func (c *goConversion) toInterface(index int) (interface{}, error) {
+	l := c.l
+	switch x := l.indexToValue(index).(type) {
+	case nil, bool, float64, int64, string:
+		return x, nil
+	case *userData:
+		return x.data, nil
+	case *table:
+		CheckStackWithMessage(l, 2, "table too deeply nested")
+		n, keys, strings := l.RawLength(index), 0, true
+		for l.PushNil(); l.Next(index); l.Pop(1) {
+			_, s := l.indexToValue(-2).(string)
+			keys, strings = keys+1, strings && s
+		}
+		var t reflect.Type
+		switch {
+		case n > 0 && keys == n:
+			t = reflect.TypeOf([]interface{}(nil))
+		case strings:
+			t = reflect.TypeOf(map[string]interface{}(nil))
+		default:
+			t = reflect.TypeOf(map[interface{}]interface{}(nil))
+		}
+		v, err := c.value(index, t)
+		return v.Interface(), err
+	}
+	return nil, fmt.Errorf("cannot convert %s to interface {}", TypeNameOf(l, index))
+}
+
+// luaFunction wraps the Lua function f in a Go func of type t.
+func (l *State) luaFunction(f value, t reflect.Type) reflect.Value {
+	results, returnsError := t.NumOut(), false
+	if results > 0 && t.Out(results-1) == errorType {
+		results, returnsError = results-1, true
+	}
+	return reflect.MakeFunc(t, func(in []reflect.Value) []reflect.Value {
+		out := make([]reflect.Value, t.NumOut())
+		for i := range out {
+			out[i] = reflect.Zero(t.Out(i))
+		}
+		fail := func(err error) []reflect.Value {
+			if !returnsError {
+				panic(err)
+			}
+			out[results] = reflect.ValueOf(&err).Elem()
+			return out
+		}
+		if t.IsVariadic() {
+			last := in[len(in)-1]
+			in = in[:len(in)-1]
+			for i := 0; i < last.Len(); i++ {
+				in = append(in, last.Index(i))
+			}
+		}
+		CheckStackWithMessage(l, len(in)+1, "too many arguments")
+		l.apiPush(f)
+		for _, v := range in {
+			l.pushReflect(v)
+		}
+		if returnsError {
+			if err := l.ProtectedCall(len(in), results, 0); err != nil {
+				l.Pop(1)
+				return fail(err)
+			}
+		} else {
+			l.Call(len(in), results)
+		}
+		defer l.Pop(results)
+		for i := 0; i < results; i++ {
+			v, err := l.toGoValue(i-results, t.Out(i))
+			if err != nil {
+				return fail(fmt.Errorf("result %d: %w", i+1, err))
+			}
+			out[i] = v
+		}
+		return out
+	})
+}
@@ -0,0 +1,198 @@
+package lua
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testPoint struct {
+	X, Y   float64
+	Label  string `lua:"label"`
+	hidden int
+}
+
+func (p *testPoint) Move(dx, dy float64) { p.X, p.Y = p.X+dx, p.Y+dy }
+
+func (p testPoint) Sum() float64 { return p.X + p.Y }
+
+type testShape struct {
+	Name   string
+	Points []testPoint
+	Tags   map[string]int
+	Origin *testPoint
+}
+
+func TestPushGoValue(t *testing.T) {
+	l := NewState()
+	OpenLibraries(l)
+	p := &testPoint{X: 1, Y: 2, Label: "a"}
+	l.PushGoValue(p)
+	l.SetGlobal("p")
+	l.PushGoValue(&testShape{Name: "s", Points: []testPoint{{X: 1}, {X: 2}}, Tags: map[string]int{"x": 1}})
+	l.SetGlobal("s")
+	l.PushGoValue(strings.Repeat)
+	l.SetGlobal("rep")
+	l.PushGoValue(func(xs ...int) (int, error) {
+		if len(xs) == 0 {
+			return 0, errors.New("no arguments")
+		}
+		sum := 0
+		for _, x := range xs {
+			sum += x
+		}
+		return sum, nil
+	})
+	l.SetGlobal("sum")
+	err := DoString(l, `
+		assert(type(p) == "userdata")
+		assert(p.X == 1 and p.Y == 2 and p.label == "a")
+		assert(p.Label == nil and p.hidden == nil)
+		p:Move(1, 1)
+		assert(p.X == 2 and p:Sum() == 5)
+		p.label = "b"
+		assert(not pcall(function() p.X = "x" end))
+		assert(not pcall(function() p.Z = 1 end))
+
+		assert(#s.Points == 2 and s.Points[2].X == 2 and s.Points[3] == nil)
+		s.Points[1].Y = 7
+		assert(s.Tags.x == 1 and s.Tags.y == nil)
+		s.Tags.y = 2
+		s.Tags.x = nil
+		local n = 0
+		for k, v in pairs(s.Tags) do n = n + 1; assert(k == "y" and v == 2) end
+		assert(n == 1 and #s.Tags == 1)
+		assert(s.Origin == nil)
+
+		assert(rep("ab", 3) == "ababab")
+		assert(sum(1, 2, 3) == 6)
+		local ok, err = pcall(sum)
+		assert(not ok and err:find("no arguments"))
+		local ok, err = pcall(rep, "ab", 1.5)
+		assert(not ok and err:find("bad argument #2 .*no integer representation"), err)
+	`)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if p.X != 2 || p.Y != 3 || p.Label != "b" {
+		t.Errorf("point not updated: %+v", p)
+	}
+}
+
+func TestPushGoValueChannel(t *testing.T) {
+	l := NewState()
+	OpenLibraries(l)
+	c := make(chan string, 2)
+	l.PushGoValue(c)
+	l.SetGlobal("c")
+	err := DoString(l, `
+		c:send("hello")
+		assert(#c == 1)
+		local v, ok = c:receive()
+		assert(v == "hello" and ok)
+		c:close()
+		v, ok = c:receive()
+		assert(v == "" and not ok)
+	`)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+}
+
+func TestToGoValue(t *testing.T) {
+	l := NewState()
+	OpenLibraries(l)
+	if err := DoString(l, `return {Name = "s", Points = {{X = 1, label = "a"}, {Y = 2}}, Tags = {a = 1}, Origin = {X = 3}}`); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	var s testShape
+	if err := l.ToGoValue(-1, &s); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	expected := testShape{Name: "s", Points: []testPoint{{X: 1, Label: "a"}, {Y: 2}}, Tags: map[string]int{"a": 1}, Origin: &testPoint{X: 3}}
+	if !reflect.DeepEqual(s, expected) {
+		t.Errorf("expected %+v but found %+v", expected, s)
+	}
+
+	var i interface{}
+	if err := DoString(l, `return {1, "two", {three = 3}}`); err != nil {
+		t.Fatalf("error: %s", err)
+	} else if err := l.ToGoValue(-1, &i); err != nil {
+		t.Fatalf("error: %s", err)
+	} else if expected := []interface{}{1.0, "two", map[string]interface{}{"three": 3.0}}; !reflect.DeepEqual(i, expected) {
+		t.Errorf("expected %v but found %v", expected, i)
+	}
+
+	var f func(int, int) (int, error)
+	if err := DoString(l, `return function(a, b) if b == 0 then error("division by zero", 0) end return math.floor(a / b) end`); err != nil {
+		t.Fatalf("error: %s", err)
+	} else if err := l.ToGoValue(-1, &f); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if q, err := f(7, 2); err != nil || q != 3 {
+		t.Errorf("expected 3, <nil> but found %d, %v", q, err)
+	}
+	if _, err := f(1, 0); err == nil || !strings.Contains(err.Error(), "division by zero") {
+		t.Errorf("expected division by zero error but found %v", err)
+	}
+
+	p := &testPoint{X: 1}
+	l.PushGoValue(p)
+	var q *testPoint
+	if err := l.ToGoValue(-1, &q); err != nil || q != p {
+		t.Errorf("expected userdata to convert to the same pointer, found %v, %v", q, err)
+	}
+}
+
+func TestToGoValueErrors(t *testing.T) {
+	l := NewState()
+	OpenLibraries(l)
+	for _, c := range []struct {
+		source, expected string
+		target           interface{}
+	}{
+		{`return "x"`, "cannot convert string to int", new(int)},
+		{`return 1.5`, "number 1.5 has no integer representation", new(int)},
+		{`return 300`, "number 300 overflows uint8", new(uint8)},
+		{`return -1`, "number -1 overflows uint", new(uint)},
+		{`return true`, "cannot convert boolean to string", new(string)},
+		{`return {1, {}}`, "index 2: cannot convert table to string", new([]string)},
+		{`return {1, 2, 3}`, "table of length 3 overflows [2]int", new([2]int)},
+		{`return {a = "x"}`, "key 'a': cannot convert string to float64", new(map[string]float64)},
+		{`return {Points = {{X = "x"}}}`, "field 'Points': index 1: field 'X': cannot convert string to float64", new(testShape)},
+		{`return {Z = 1}`, "lua.testPoint has no field 'Z'", new(testPoint)},
+		{`return print`, "cannot convert function to interface {}", new(interface{})},
+		{`local t = {} t[1] = {t} return t`, "index 1: index 1: cannot convert a table which contains itself", new(interface{})},
+		{`local t = {} t.t = t return t`, "key 't': cannot convert a table which contains itself", new(map[string]interface{})},
+	} {
+		if err := DoString(l, c.source); err != nil {
+			t.Fatalf("error: %s", err)
+		}
+		if err := l.ToGoValue(-1, c.target); err == nil || err.Error() != c.expected {
+			t.Errorf("%s: expected error %q but found %v", c.source, c.expected, err)
+		}
+		l.Pop(1)
+	}
+}
+
+func TestToGoValueNesting(t *testing.T) {
+	l := NewState()
+	OpenLibraries(l)
+	l.PushGoValue(func(x interface{}) {})
+	l.SetGlobal("f")
+	err := DoString(l, `
+		local t = {} t[1] = t
+		local ok, err = pcall(f, t)
+		assert(not ok and err:find("cannot convert a table which contains itself", 1, true), err)
+		local shared = {1}
+		f({shared, shared})
+		t = {}
+		for i = 1, 1000 do t = {t} end
+		ok, err = pcall(f, t)
+		assert(not ok and err:find("table too deeply nested", 1, true), err)
+	`)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+}
@@ -44,8 +44,12 @@ const (
 	tkLE
 	tkNE
 	tkDoubleColon
+	tkFloorDivide
+	tkShiftLeft
+	tkShiftRight
 	tkEOS
 	tkNumber
+	tkInteger
 	tkName
 	tkString
 	reservedCount = tkWhile - firstReserved + 1
@@ -56,18 +60,20 @@ var tokens []string = []string{
 	"end", "false", "for", "function", "goto", "if",
 	"in", "local", "nil", "not", "or", "repeat",
 	"return", "then", "true", "until", "while",
-	"..", "...", "==", ">=", "<=", "~=", "::", "<eof>",
-	"<number>", "<name>", "<string>",
+	"..", "...", "==", ">=", "<=", "~=", "::", "//", "<<", ">>", "<eof>",
+	"<number>", "<integer>", "<name>", "<string>",
 }
 
 type token struct {
 	t rune
 	n float64
+	i int64
 	s string
 }
 
 type scanner struct {
 	l                    *State
+	dialect              Dialect
 	buffer               bytes.Buffer
 	r                    io.ByteReader
 	current              rune
@@ -81,6 +87,9 @@ func (s *scanner) assert(cond bool)           { s.l.assert(cond) }
 func (s *scanner) syntaxError(message string) { s.scanError(message, s.t) }
 func (s *scanner) errorExpected(t rune)       { s.syntaxError(s.tokenToString(t) + " expected") }
 func (s *scanner) numberError()               { s.scanError("malformed number", tkNumber) }
+func (s *scanner) integers() bool             { return s.dialect&Integers != 0 }
+func (s *scanner) bitwise() bool              { return s.dialect&Bitwise != 0 }
+func (s *scanner) attributes() bool           { return s.dialect&LocalAttributes != 0 }
 func isNewLine(c rune) bool                   { return c == '\n' || c == '\r' }
 func isDecimal(c rune) bool                   { return '0' <= c && c <= '9' }
 
@@ -90,6 +99,8 @@ func (s *scanner) tokenToString(t rune) string {
 		return s.s
 	case t == tkNumber:
 		return fmt.Sprintf("%f", s.n)
+	case t == tkInteger:
+		return fmt.Sprintf("%d", s.i)
 	case t < firstReserved:
 		return string(t) // TODO check for printable rune
 	case t < tkEOS:
@@ -212,9 +223,11 @@ func isHexadecimal(c rune) bool {
 	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
 }
 
-func (s *scanner) readHexNumber(x float64) (n float64, c rune, i int) {
+// readHexNumber reads hexadecimal digits, accumulating them into x as a
+// float, and into u as an integer which wraps around on overflow.
+func (s *scanner) readHexNumber(x float64, u uint64) (n float64, _ uint64, c rune, i int) {
 	if c, n = s.current, x; !isHexadecimal(c) {
-		return
+		return n, u, c, i
 	}
 	for {
 		switch {
@@ -225,10 +238,10 @@ func (s *scanner) readHexNumber(x float64) (n float64, c rune, i int) {
 		case 'A' <= c && c <= 'F':
 			c = c - 'A' + 10
 		default:
-			return
+			return n, u, c, i
 		}
 		s.advance()
-		c, n, i = s.current, n*16.0+float64(c), i+1
+		c, n, u, i = s.current, n*16.0+float64(c), u<<4|uint64(c), i+1
 	}
 }
 
@@ -242,14 +255,18 @@ func (s *scanner) readNumber() token {
 		s.assert(prefix == "0x" || prefix == "0X")
 		s.buffer.Reset()
 		var exponent int
-		fraction, c, i := s.readHexNumber(0)
-		if c == '.' {
+		fraction, integer, c, i := s.readHexNumber(0, 0)
+		isFloat := c == '.'
+		if isFloat {
 			s.advance()
-			fraction, c, exponent = s.readHexNumber(fraction)
+			fraction, _, c, exponent = s.readHexNumber(fraction, 0)
 		}
 		if i == 0 && exponent == 0 {
 			s.numberError()
 		}
+		if !isFloat && c != 'p' && c != 'P' && s.integers() {
+			return token{t: tkInteger, i: int64(integer)}
+		}
 		exponent *= -4
 		if c == 'p' || c == 'P' {
 			s.advance()
@@ -286,6 +303,12 @@ func (s *scanner) readNumber() token {
 		_ = s.readDigits()
 	}
 	str := s.buffer.String()
+	if s.integers() && !strings.ContainsAny(str, ".eE") {
+		if i, err := strconv.ParseInt(str, base10, bits64); err == nil {
+			s.buffer.Reset()
+			return token{t: tkInteger, i: i}
+		} // else too large for an integer, so read it as a float
+	}
 	if strings.HasPrefix(str, "0") {
 		if str = strings.TrimLeft(str, "0"); str == "" || !isDecimal(rune(str[0])) {
 			str = "0" + str
@@ -445,13 +468,19 @@ func (s *scanner) scan() token {
 			s.advance()
 			return token{t: tkEq}
 		case '<':
-			if s.advance(); s.current != '=' {
+			if s.advance(); s.current == '<' && s.bitwise() {
+				s.advance()
+				return token{t: tkShiftLeft}
+			} else if s.current != '=' {
 				return token{t: '<'}
 			}
 			s.advance()
 			return token{t: tkLE}
 		case '>':
-			if s.advance(); s.current != '=' {
+			if s.advance(); s.current == '>' && s.bitwise() {
+				s.advance()
+				return token{t: tkShiftRight}
+			} else if s.current != '=' {
 				return token{t: '>'}
 			}
 			s.advance()
@@ -462,6 +491,12 @@ func (s *scanner) scan() token {
 			}
 			s.advance()
 			return token{t: tkNE}
+		case '/':
+			if s.advance(); s.current != '/' || !s.integers() {
+				return token{t: '/'}
+			}
+			s.advance()
+			return token{t: tkFloorDivide}
 		case ':':
 			if s.advance(); s.current != ':' {
 				return token{t: ':'}
@@ -1,6 +1,10 @@
 package lua
 
-import "log"
+import (
+	"errors"
+	"fmt"
+	"log"
+)
 
 func (l *State) push(v value) {
 	l.stack[l.top] = v
@@ -113,11 +117,66 @@ func (l *State) close(level int) {
 			}
 		}
 	}
+	if n := len(l.toBeClosed); n > 0 && l.toBeClosed[n-1] >= level {
+		l.closeVariables(level, nil)
+	}
+}
+
+// newToBeClosed registers the <close> variable at stack index level. Its
+// value, unless nil or false, must have a __close metamethod.
+func (l *State) newToBeClosed(level int) {
+	if v := l.stack[level]; v != nil && v != false {
+		if l.tagMethodByObject(v, tmClose) == nil {
+			ci := l.callInfo
+			name, _ := l.prototype(ci).localName(ci.frameIndex(level)+1, ci.savedPC-1)
+			l.runtimeError(fmt.Sprintf("variable '%s' got a non-closable value", name))
+		}
+		l.toBeClosed = append(l.toBeClosed, level)
+	}
+}
+
+// closeVariables calls the __close metamethods of the <close> variables at
+// or above level, most recently declared first, with the variable's value
+// and err, which is nil unless the variables are closed by an error.
+func (l *State) closeVariables(level int, err value) {
+	for n := len(l.toBeClosed); n > 0 && l.toBeClosed[n-1] >= level; n = len(l.toBeClosed) {
+		i := l.toBeClosed[n-1]
+		l.toBeClosed = l.toBeClosed[:n-1]
+		if l.top <= i {
+			l.top = i + 1
+		}
+		v := l.stack[i]
+		l.checkStack(3)
+		l.push(l.tagMethodByObject(v, tmClose))
+		l.push(v)
+		l.push(err)
+		l.call(l.top-3, 0, false)
+	}
+}
+
+// closeProtected closes the <close> variables at or above level after err
+// unwound the stack down to the call ci, and returns the resulting error.
+// An error raised by a __close metamethod replaces err, and is passed to
+// the remaining metamethods.
+func (l *State) closeProtected(ci *callInfo, level int, err error) error {
+	if n := len(l.toBeClosed); n == 0 || l.toBeClosed[n-1] < level {
+		return err
+	}
+	e := l.errorObject(err)
+	for n := len(l.toBeClosed); n > 0 && l.toBeClosed[n-1] >= level; n = len(l.toBeClosed) {
+		l.callInfo = ci
+		if closeErr := l.protect(func() { l.closeVariables(level, e) }); closeErr != nil {
+			err, e = closeErr, l.errorObject(closeErr)
+		}
+	}
+	l.push(e) // the error object is expected on top of the stack
+	return err
 }
 
 // information about a call
 type callInfo struct {
 	function, top, resultCount int
+	extra                      int
 	previous, next             *callInfo
 	callStatus                 callStatus
 	*luaCallInfo
@@ -131,10 +190,10 @@ type luaCallInfo struct {
 }
 
 type goCallInfo struct {
-	context, extra, oldErrorFunction int
-	continuation                     Function
-	oldAllowHook, shouldYield        bool
-	error                            error
+	context, oldErrorFunction int
+	continuation              Function
+	oldAllowHook, shouldYield bool
+	error                     error
 }
 
 func (ci *callInfo) setCallStatus(flag callStatus)     { ci.callStatus |= flag }
@@ -209,6 +268,7 @@ func (ci *luaCallInfo) step() instruction {
 }
 
 func (l *State) newLuaClosure(p *prototype) *luaClosure {
+	l.allocate(closureSize + len(p.upValues)*8)
 	return &luaClosure{prototype: p, upValues: make([]*upValue, len(p.upValues))}
 }
 
@@ -358,7 +418,7 @@ func (l *State) postCall(firstResult int) bool {
 		result++
 	}
 	l.top = result
-	if l.hookMask&(MaskReturn|MaskLine) != 0 {
+	if l.hookMask&(MaskReturn|MaskLine) != 0 && l.callInfo.isLua() {
 		l.oldPC = l.callInfo.savedPC // oldPC for caller function
 	}
 	return wanted != MultipleReturns
@@ -482,7 +542,100 @@ func (l *State) growStack(n int) {
 			l.reallocStack(errorStackSize)
 			l.runtimeError("stack overflow")
 		} else {
+			l.allocate((newSize - len(l.stack)) * valueSize)
 			l.reallocStack(newSize)
 		}
 	}
 }
+
+// yieldError is thrown to unwind the Go stack back to Resume when a
+// coroutine yields. It never escapes Resume.
+var yieldError = errors.New("attempt to yield")
+
+func (l *State) resume(firstArg int) {
+	if !l.shouldYield { // starting a coroutine
+		if !l.preCall(firstArg-1, MultipleReturns) { // Lua function?
+			l.execute() // call it
+		}
+		return
+	}
+	// resuming from previous yield
+	l.shouldYield = false
+	ci := l.callInfo
+	ci.function = ci.extra
+	if ci.isLua() { // yielded inside a hook?
+		l.execute() // just continue running Lua code
+	} else { // 'common' yield
+		if ci.continuation != nil { // does it have a continuation?
+			ci.shouldYield, ci.error = true, nil // 'default' status
+			ci.setCallStatus(callStatusYielded)
+			n := ci.continuation(l) // call continuation
+			l.checkElementCount(n)
+			firstArg = l.top - n // yield results come from continuation
+		}
+		l.postCall(firstArg) // finish 'preCall'
+	}
+	l.unroll()
+}
+
+// unroll executes the remaining part of a coroutine after an interruption,
+// either a yield or an error recovered by a yieldable protected call.
+func (l *State) unroll() {
+	for l.callInfo != &l.baseCallInfo { // until the stack is empty
+		if !l.callInfo.isLua() { // Go function?
+			l.finishGoCall()
+		} else { // Lua function
+			l.finishOp() // finish interrupted instruction
+			l.execute()  // execute down to higher Go 'boundary'
+		}
+	}
+}
+
+func (l *State) finishGoCall() {
+	ci := l.callInfo
+	l.assert(ci.continuation != nil) // must have a continuation
+	l.assert(l.nonYieldableCallCount == 0)
+	if ci.isCallStatus(callStatusYieldableProtected) { // was inside a protected call?
+		l.errorFunction = ci.oldErrorFunction
+	}
+	// finish 'CallWithContinuation'/'ProtectedCallWithContinuation'
+	l.adjustResults(ci.resultCount)
+	if !ci.isCallStatus(callStatusError) { // no call status?
+		ci.shouldYield, ci.error = true, nil // 'default' status
+	}
+	ci.clearCallStatus(callStatusYieldableProtected | callStatusError)
+	ci.setCallStatus(callStatusYielded)
+	n := ci.continuation(l)
+	l.checkElementCount(n)
+	l.postCall(l.top - n) // finish 'preCall'
+}
+
+func (l *State) findProtectedCall() *callInfo {
+	for ci := l.callInfo; ci != nil; ci = ci.previous {
+		if ci.isCallStatus(callStatusYieldableProtected) {
+			return ci
+		}
+	}
+	return nil // no pending protected call
+}
+
+// recover finishes a yieldable protected call interrupted by err, so that the
+// coroutine can continue running from its continuation. It returns false if
+// there is no such call.
+func (l *State) recover(err error) bool {
+	ci := l.findProtectedCall()
+	if ci == nil {
+		return false // no recovery point
+	}
+	oldTop := ci.extra // "finish" protectedCall
+	err = l.closeProtected(ci, oldTop, err)
+	l.close(oldTop)
+	l.setErrorObject(err, oldTop)
+	l.callInfo = ci
+	l.allowHook = ci.oldAllowHook
+	l.nonYieldableCallCount = 0 // should be zero to be yieldable
+	l.errorFunction = ci.oldErrorFunction
+	ci.setCallStatus(callStatusError) // call has error status
+	ci.shouldYield, ci.error = false, err
+	return true
+}
@@ -17,29 +17,473 @@ func relativePosition(pos, length int) int {
 	return length + pos + 1
 }
 
+const (
+	maxMatchDepth   = 200 // control for recursive depth (to avoid Go stack overflow)
+	captureUnclosed = -1
+	capturePosition = -2
+	patternEscape   = '%'
+	patternSpecials = "^$*+?.([%-"
+	noMatch         = -1
+)
+
+type capture struct {
+	init, length int
+}
+
+type matchState struct {
+	l            *State
+	src, pattern string
+	depth        int
+	level        int // total number of captures (finished or unfinished)
+	captures     [maxCaptures]capture
+}
+
+func newMatchState(l *State, src, pattern string) *matchState {
+	return &matchState{l: l, src: src, pattern: pattern, depth: maxMatchDepth}
+}
+
+// sourceAt and patternAt mimic reading the terminating '\0' of a C string.
+func (ms *matchState) sourceAt(s int) byte {
+	if s < len(ms.src) {
+		return ms.src[s]
+	}
+	return 0
+}
+
+func (ms *matchState) patternAt(p int) byte {
+	if p < len(ms.pattern) {
+		return ms.pattern[p]
+	}
+	return 0
+}
+
+func (ms *matchState) checkCapture(c byte) int {
+	i := int(c) - '1'
+	if i < 0 || i >= ms.level || ms.captures[i].length == captureUnclosed {
+		Errorf(ms.l, "invalid capture index %%%d", i+1)
+	}
+	return i
+}
+
+func (ms *matchState) captureToClose() int {
+	for level := ms.level - 1; level >= 0; level-- {
+		if ms.captures[level].length == captureUnclosed {
+			return level
+		}
+	}
+	Errorf(ms.l, "invalid pattern capture")
+	panic("unreachable")
+}
+
+func (ms *matchState) classEnd(p int) int {
+	switch ms.pattern[p] {
+	case patternEscape:
+		if p+1 >= len(ms.pattern) {
+			Errorf(ms.l, "malformed pattern (ends with '%%')")
+		}
+		return p + 2
+	case '[':
+		if p++; ms.patternAt(p) == '^' {
+			p++
+		}
+		for { // look for a ']'
+			if p >= len(ms.pattern) {
+				Errorf(ms.l, "malformed pattern (missing ']')")
+			}
+			c := ms.pattern[p]
+			if p++; c == patternEscape && p < len(ms.pattern) {
+				p++ // skip escapes (e.g. '%]')
+			}
+			if ms.patternAt(p) == ']' {
+				return p + 1
+			}
+		}
+	}
+	return p + 1
+}
+
+func isAlpha(c byte) bool        { return isLower(c) || isUpper(c) }
+func isControl(c byte) bool      { return c < 0x20 || c == 0x7f }
+func isDigit(c byte) bool        { return '0' <= c && c <= '9' }
+func isGraphic(c byte) bool      { return 0x20 < c && c < 0x7f }
+func isLower(c byte) bool        { return 'a' <= c && c <= 'z' }
+func isPunctuation(c byte) bool  { return isGraphic(c) && !isAlphaNumeric(c) }
+func isSpace(c byte) bool        { return c == ' ' || '\t' <= c && c <= '\r' }
+func isUpper(c byte) bool        { return 'A' <= c && c <= 'Z' }
+func isAlphaNumeric(c byte) bool { return isAlpha(c) || isDigit(c) }
+func isHexDigit(c byte) bool {
+	return isDigit(c) || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func matchClass(c, class byte) bool {
+	var result bool
+	switch class | 0x20 { // to lower case
+	case 'a':
+		result = isAlpha(c)
+	case 'c':
+		result = isControl(c)
+	case 'd':
+		result = isDigit(c)
+	case 'g':
+		result = isGraphic(c)
+	case 'l':
+		result = isLower(c)
+	case 'p':
+		result = isPunctuation(c)
+	case 's':
+		result = isSpace(c)
+	case 'u':
+		result = isUpper(c)
+	case 'w':
+		result = isAlphaNumeric(c)
+	case 'x':
+		result = isHexDigit(c)
+	case 'z':
+		result = c == 0 // deprecated option
+	default:
+		return class == c
+	}
+	if isLower(class) {
+		return result
+	}
+	return !result
+}
+
+func (ms *matchState) matchBracketClass(c byte, p, ec int) bool {
+	sig := true
+	if ms.patternAt(p+1) == '^' {
+		sig = false
+		p++ // skip the '^'
+	}
+	for p++; p < ec; p++ {
+		if ms.pattern[p] == patternEscape {
+			if p++; matchClass(c, ms.pattern[p]) {
+				return sig
+			}
+		} else if ms.patternAt(p+1) == '-' && p+2 < ec {
+			if p += 2; ms.pattern[p-2] <= c && c <= ms.pattern[p] {
+				return sig
+			}
+		} else if ms.pattern[p] == c {
+			return sig
+		}
+	}
+	return !sig
+}
+
+func (ms *matchState) singleMatch(s, p, ep int) bool {
+	if s >= len(ms.src) {
+		return false
+	}
+	switch c := ms.src[s]; ms.pattern[p] {
+	case '.':
+		return true // matches any char
+	case patternEscape:
+		return matchClass(c, ms.pattern[p+1])
+	case '[':
+		return ms.matchBracketClass(c, p, ep-1)
+	default:
+		return ms.pattern[p] == c
+	}
+}
+
+func (ms *matchState) matchBalance(s, p int) int {
+	if p+1 >= len(ms.pattern) {
+		Errorf(ms.l, "malformed pattern (missing arguments to '%%b')")
+	}
+	if s >= len(ms.src) || ms.src[s] != ms.pattern[p] {
+		return noMatch
+	}
+	b, e, count := ms.pattern[p], ms.pattern[p+1], 1
+	for s++; s < len(ms.src); s++ {
+		if c := ms.src[s]; c == e {
+			if count--; count == 0 {
+				return s + 1
+			}
+		} else if c == b {
+			count++
+		}
+	}
+	return noMatch // string ends out of balance
+}
+
+func (ms *matchState) maxExpand(s, p, ep int) int {
+	i := 0 // counts maximum expand for item
+	for ms.singleMatch(s+i, p, ep) {
+		i++
+	}
+	for ; i >= 0; i-- { // keeps trying to match with the maximum repetitions
+		if result := ms.match(s+i, ep+1); result != noMatch {
+			return result
+		}
+	}
+	return noMatch
+}
+
+func (ms *matchState) minExpand(s, p, ep int) int {
+	for {
+		if result := ms.match(s, ep+1); result != noMatch {
+			return result
+		} else if ms.singleMatch(s, p, ep) {
+			s++ // try with one more repetition
+		} else {
+			return noMatch
+		}
+	}
+}
+
+func (ms *matchState) startCapture(s, p, what int) int {
+	level := ms.level
+	if level >= maxCaptures {
+		Errorf(ms.l, "too many captures")
+	}
+	ms.captures[level] = capture{init: s, length: what}
+	ms.level = level + 1
+	result := ms.match(s, p)
+	if result == noMatch {
+		ms.level-- // undo capture
+	}
+	return result
+}
+
+func (ms *matchState) endCapture(s, p int) int {
+	l := ms.captureToClose()
+	ms.captures[l].length = s - ms.captures[l].init // close capture
+	result := ms.match(s, p)
+	if result == noMatch {
+		ms.captures[l].length = captureUnclosed // undo capture
+	}
+	return result
+}
+
+func (ms *matchState) matchCapture(s int, c byte) int {
+	l := ms.checkCapture(c)
+	if ms.captures[l].length < 0 { // a position capture, which never matches
+		return noMatch
+	}
+	capture := ms.src[ms.captures[l].init : ms.captures[l].init+ms.captures[l].length]
+	if strings.HasPrefix(ms.src[s:], capture) {
+		return s + len(capture)
+	}
+	return noMatch
+}
+
+func (ms *matchState) match(s, p int) int {
+	if ms.depth--; ms.depth == 0 {
+		Errorf(ms.l, "pattern too complex")
+	}
+	defer func() { ms.depth++ }()
+	for p < len(ms.pattern) { // end of pattern?
+		switch ms.pattern[p] {
+		case '(': // start capture
+			if ms.patternAt(p+1) == ')' { // position capture?
+				return ms.startCapture(s, p+2, capturePosition)
+			}
+			return ms.startCapture(s, p+1, captureUnclosed)
+		case ')': // end capture
+			return ms.endCapture(s, p+1)
+		case '$':
+			if p+1 == len(ms.pattern) { // is the '$' the last char in pattern?
+				if s != len(ms.src) { // check end of string
+					return noMatch
+				}
+				return s
+			}
+		case patternEscape: // escaped sequences not in the format class[*+?-]?
+			switch ms.patternAt(p + 1) {
+			case 'b': // balanced string?
+				if s = ms.matchBalance(s, p+2); s == noMatch {
+					return noMatch
+				}
+				p += 4
+				continue
+			case 'f': // frontier?
+				if p += 2; ms.patternAt(p) != '[' {
+					Errorf(ms.l, "missing '[' after '%%f' in pattern")
+				}
+				ep := ms.classEnd(p) // points to what is next
+				var previous byte
+				if s > 0 {
+					previous = ms.src[s-1]
+				}
+				if ms.matchBracketClass(previous, p, ep-1) || !ms.matchBracketClass(ms.sourceAt(s), p, ep-1) {
+					return noMatch
+				}
+				p = ep
+				continue
+			case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9': // capture results (%0-%9)?
+				if s = ms.matchCapture(s, ms.pattern[p+1]); s == noMatch {
+					return noMatch
+				}
+				p += 2
+				continue
+			}
+		}
+		// pattern class plus optional suffix
+		ep := ms.classEnd(p)           // points to optional suffix
+		if !ms.singleMatch(s, p, ep) { // does not match at least once?
+			if c := ms.patternAt(ep); c == '*' || c == '?' || c == '-' { // accept empty?
+				p = ep + 1
+				continue
+			}
+			return noMatch // '+' or no suffix
+		}
+		switch ms.patternAt(ep) { // matched once; handle optional suffix
+		case '?': // optional
+			if result := ms.match(s+1, ep+1); result != noMatch {
+				return result
+			}
+			p = ep + 1
+		case '+': // 1 or more repetitions
+			return ms.maxExpand(s+1, p, ep) // 1 match already done
+		case '*': // 0 or more repetitions
+			return ms.maxExpand(s, p, ep)
+		case '-': // 0 or more repetitions (minimum)
+			return ms.minExpand(s, p, ep)
+		default: // no suffix
+			s, p = s+1, ep
+		}
+	}
+	return s
+}
+
+func (ms *matchState) pushOneCapture(i, s, e int) {
+	if i >= ms.level {
+		if i != 0 { // ms.level == 0, too
+			Errorf(ms.l, "invalid capture index")
+		}
+		ms.l.PushString(ms.src[s:e]) // add whole match
+	} else if c := ms.captures[i]; c.length == captureUnclosed {
+		Errorf(ms.l, "unfinished capture")
+	} else if c.length == capturePosition {
+		ms.l.PushInteger(c.init + 1)
+	} else {
+		ms.l.PushString(ms.src[c.init : c.init+c.length])
+	}
+}
+
+// pushCaptures pushes all captures, or the whole match if there are none. A
+// negative s means that the whole match is not wanted.
+func (ms *matchState) pushCaptures(s, e int) int {
+	n := ms.level
+	if n == 0 && s >= 0 {
+		n = 1
+	}
+	CheckStackWithMessage(ms.l, n, "too many captures")
+	for i := 0; i < n; i++ {
+		ms.pushOneCapture(i, s, e)
+	}
+	return n // number of strings pushed
+}
+
 func findHelper(l *State, isFind bool) int {
 	s, p := CheckString(l, 1), CheckString(l, 2)
 	init := relativePosition(OptInteger(l, 3, 1), len(s))
 	if init < 1 {
 		init = 1
-	} else if init > len(s)+1 {
-		l.PushNil()
+	} else if init > len(s)+1 { // start after string's end?
+		l.PushNil() // cannot find anything
 		return 1
 	}
-	isPlain := l.TypeOf(4) == TypeNone || l.ToBoolean(4)
-	if isFind && (isPlain || !strings.ContainsAny(p, "^$*+?.([%-")) {
+	if isFind && (l.ToBoolean(4) || !strings.ContainsAny(p, patternSpecials)) { // explicit request or no special characters?
 		if start := strings.Index(s[init-1:], p); start >= 0 {
 			l.PushInteger(start + init)
 			l.PushInteger(start + init + len(p) - 1)
 			return 2
 		}
 	} else {
-		l.assert(false) // TODO implement pattern matching
+		anchor := len(p) > 0 && p[0] == '^'
+		if anchor {
+			p = p[1:] // skip anchor character
+		}
+		ms := newMatchState(l, s, p)
+		for s1 := init - 1; ; s1++ {
+			ms.level = 0
+			if e := ms.match(s1, 0); e != noMatch {
+				if isFind {
+					l.PushInteger(s1 + 1) // start
+					l.PushInteger(e)      // end
+					return ms.pushCaptures(-1, 0) + 2
+				}
+				return ms.pushCaptures(s1, e)
+			}
+			if s1 >= len(s) || anchor {
+				break
+			}
+		}
 	}
-	l.PushNil()
+	l.PushNil() // not found
 	return 1
 }
 
+func gmatchAux(l *State) int {
+	s, _ := l.ToString(UpValueIndex(1))
+	p, _ := l.ToString(UpValueIndex(2))
+	start, _ := l.ToInteger(UpValueIndex(3))
+	ms := newMatchState(l, s, p)
+	for src := start; src <= len(s); src++ {
+		ms.level = 0
+		if e := ms.match(src, 0); e != noMatch {
+			newStart := e
+			if e == src { // empty match? go at least one position
+				newStart++
+			}
+			l.PushInteger(newStart)
+			l.Replace(UpValueIndex(3))
+			return ms.pushCaptures(src, e)
+		}
+	}
+	return 0 // not found
+}
+
+func (ms *matchState) addString(b *bytes.Buffer, s, e int) {
+	l := ms.l
+	replacement, _ := l.ToString(3)
+	for i := 0; i < len(replacement); i++ {
+		if c := replacement[i]; c != patternEscape {
+			b.WriteByte(c)
+		} else if i++; i >= len(replacement) || !isDigit(replacement[i]) { // skip escape
+			if i >= len(replacement) || replacement[i] != patternEscape {
+				Errorf(l, "invalid use of '%c' in replacement string", rune(patternEscape))
+			}
+			b.WriteByte(replacement[i])
+		} else if replacement[i] == '0' {
+			b.WriteString(ms.src[s:e])
+		} else {
+			ms.pushOneCapture(int(replacement[i]-'1'), s, e)
+			r, _ := l.ToString(-1) // add capture to accumulated result
+			b.WriteString(r)
+			l.Pop(1)
+		}
+	}
+}
+
+func (ms *matchState) addValue(b *bytes.Buffer, s, e int, t Type) {
+	l := ms.l
+	switch t {
+	case TypeFunction:
+		l.PushValue(3)
+		n := ms.pushCaptures(s, e)
+		l.Call(n, 1)
+	case TypeTable:
+		ms.pushOneCapture(0, s, e)
+		l.Table(3)
+	default: // TypeNumber or TypeString
+		ms.addString(b, s, e)
+		return
+	}
+	if !l.ToBoolean(-1) { // nil or false?
+		l.Pop(1)
+		b.WriteString(ms.src[s:e]) // keep original text
+		return
+	} else if !l.IsString(-1) {
+		Errorf(l, "invalid replacement value (a %s)", TypeNameOf(l, -1))
+	}
+	r, _ := l.ToString(-1) // add result to accumulator
+	b.WriteString(r)
+	l.Pop(1)
+}
+
 func scanFormat(l *State, fs string) string {
 	i := 0
 	skipDigit := func() {
@@ -91,6 +535,10 @@ func formatHelper(l *State, fs string, argCount int) string {
 				f = f[:len(f)-1] + "d"
 				fallthrough
 			case 'd':
+				if i, ok := l.indexToValue(arg).(int64); ok {
+					fmt.Fprintf(&b, f, i)
+					break
+				}
 				n := CheckNumber(l, arg)
 				ArgumentCheck(l, math.Floor(n) == n && -math.Pow(2, 63) <= n && n < math.Pow(2, 63), arg, "number has no integer representation")
 				ni := int(n)
@@ -102,6 +550,10 @@ func formatHelper(l *State, fs string, argCount int) string {
 				ni := uint(n)
 				fmt.Fprintf(&b, f, ni)
 			case 'o', 'x', 'X':
+				if i, ok := l.indexToValue(arg).(int64); ok {
+					fmt.Fprintf(&b, f, uint64(i))
+					break
+				}
 				n := CheckNumber(l, arg)
 				ArgumentCheck(l, 0.0 <= n && n < math.Pow(2, 64), arg, "not a non-negative number in proper range")
 				ni := uint(n)
@@ -175,17 +627,78 @@ var stringLibrary = []RegistryFunction{
 		l.PushString(b.String())
 		return 1
 	}},
-	// {"dump", ...},
+	{"dump", func(l *State) int {
+		CheckType(l, 1, TypeFunction)
+		if l.IsGoFunction(1) {
+			Errorf(l, "unable to dump given function")
+		}
+		strip := l.ToBoolean(2)
+		l.SetTop(1)
+		var b bytes.Buffer
+		var err error
+		if strip {
+			err = l.DumpStripped(&b)
+		} else {
+			err = l.Dump(&b)
+		}
+		if err != nil {
+			Errorf(l, "unable to dump given function")
+		}
+		l.PushString(b.String())
+		return 1
+	}},
 	{"find", func(l *State) int { return findHelper(l, true) }},
 	{"format", func(l *State) int {
 		l.PushString(formatHelper(l, CheckString(l, 1), l.Top()))
 		return 1
 	}},
-	// {"gmatch", ...},
-	// {"gsub", ...},
+	{"gmatch", func(l *State) int {
+		CheckString(l, 1)
+		CheckString(l, 2)
+		l.SetTop(2)
+		l.PushInteger(0)
+		l.PushGoClosure(gmatchAux, 3)
+		return 1
+	}},
+	{"gsub", func(l *State) int {
+		src, p := CheckString(l, 1), CheckString(l, 2)
+		t := l.TypeOf(3)
+		maxS := OptInteger(l, 4, len(src)+1)
+		ArgumentCheck(l, t == TypeNumber || t == TypeString || t == TypeFunction || t == TypeTable, 3, "string/function/table expected")
+		anchor := len(p) > 0 && p[0] == '^'
+		if anchor {
+			p = p[1:] // skip anchor character
+		}
+		var b bytes.Buffer
+		ms := newMatchState(l, src, p)
+		s, n := 0, 0
+		for n < maxS {
+			ms.level = 0
+			e := ms.match(s, 0)
+			if e != noMatch {
+				n++
+				ms.addValue(&b, s, e, t)
+			}
+			if e != noMatch && e > s { // non empty match?
+				s = e // skip it
+			} else if s < len(src) {
+				b.WriteByte(src[s])
+				s++
+			} else {
+				break
+			}
+			if anchor {
+				break
+			}
+		}
+		b.WriteString(src[s:])
+		l.PushString(b.String())
+		l.PushInteger(n) // number of substitutions
+		return 2
+	}},
 	{"len", func(l *State) int { l.PushInteger(len(CheckString(l, 1))); return 1 }},
 	{"lower", func(l *State) int { l.PushString(strings.ToLower(CheckString(l, 1))); return 1 }},
-	// {"match", ...},
+	{"match", func(l *State) int { return findHelper(l, false) }},
 	{"rep", func(l *State) int {
 		s, n, sep := CheckString(l, 1), CheckInteger(l, 2), OptString(l, 3, "")
 		if n <= 0 {
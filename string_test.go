@@ -0,0 +1,124 @@
+package lua
+
+import "testing"
+
+func TestStringFind(t *testing.T) {
+	testString(t, `
+		assert(string.find("hello world", "o w") == 5)
+		assert(string.find("hello world", "l+") == 3)
+		local s, e = string.find("hello world", "l+")
+		assert(s == 3 and e == 4)
+		assert(string.find("a.b", ".", 1, true) == 2)
+		assert(string.find("abc", "^b") == nil)
+		assert(string.find("abc", "c$") == 3)
+		local _, _, k, v = string.find("key = value", "(%w+)%s*=%s*(%w+)")
+		assert(k == "key" and v == "value")
+		assert(string.find("", "") == 1)
+		assert(string.find("abc", "", 10) == nil)
+	`)
+}
+
+func TestStringMatch(t *testing.T) {
+	testString(t, `
+		assert(string.match("  trim  ", "^%s*(.-)%s*$") == "trim")
+		assert(string.match("f(a(b)c)d", "%b()") == "(a(b)c)")
+		assert(string.match("THE (quick) fox", "%f[%a]%a+") == "THE")
+		assert(string.match("hello", "()ll()") == 3)
+		local a, b = string.match("hello", "()ll()")
+		assert(a == 3 and b == 5)
+		assert(string.match("abcabc", "(abc)%1") == "abc")
+		assert(string.match("x = 10", "[%a_][%w_]*") == "x")
+		assert(string.match("2024-01-02", "(%d+)-(%d+)-(%d+)") == "2024")
+		assert(string.match("[]", "[]]") == "]")
+		assert(string.match("a-b", "[a%-]+") == "a-")
+	`)
+}
+
+func TestStringGmatch(t *testing.T) {
+	testString(t, `
+		local words = {}
+		for w in string.gmatch("one two  three", "%a+") do words[#words + 1] = w end
+		assert(#words == 3 and words[3] == "three")
+		local t = {}
+		for k, v in string.gmatch("a=1, b=2", "(%w+)=(%w+)") do t[k] = v end
+		assert(t.a == "1" and t.b == "2")
+		local n = 0
+		for _ in string.gmatch("abc", "") do n = n + 1 end
+		assert(n == 4)
+	`)
+}
+
+func TestStringGsub(t *testing.T) {
+	testString(t, `
+		local s, n = string.gsub("hello world", "o", "0")
+		assert(s == "hell0 w0rld" and n == 2)
+		assert(string.gsub("hello world", "(%w+)", "<%1>") == "<hello> <world>")
+		assert(string.gsub("abc", "%w", "%0%0") == "aabbcc")
+		assert(string.gsub("hello", "", "-") == "-h-e-l-l-o-")
+		assert(string.gsub("hello world", "%w+", "x", 1) == "x world")
+		assert(string.gsub("$name is $age", "%$(%w+)", {name = "bob", age = 3}) == "bob is 3")
+		assert(string.gsub("abc", "%w", function(c) return c:upper() .. "." end) == "A.B.C.")
+		assert(string.gsub("abc", "%w", function(c) if c == "b" then return false end return "x" end) == "xbx")
+		assert(string.gsub("abc", "^a", "z") == "zbc")
+		assert(string.gsub("100%", "%%", "%%%%") == "100%%")
+	`)
+}
+
+func TestStringPatternErrors(t *testing.T) {
+	testString(t, `
+		local function check(pattern, message)
+			local ok, err = pcall(string.match, "abc", pattern)
+			assert(not ok and err:find(message, 1, true), err)
+		end
+		check("%", "malformed pattern (ends with '%')")
+		check("[a", "malformed pattern (missing ']')")
+		check("%b", "malformed pattern (missing arguments to '%b')")
+		check("%f", "missing '[' after '%f' in pattern")
+		check("(a", "unfinished capture")
+		check("a)", "invalid pattern capture")
+		check("%1", "invalid capture index %1")
+		check("(a%1)", "invalid capture index %1")
+		assert(string.find("abc", "()%1") == nil)
+		assert(string.match("abc", "()a%1") == nil)
+		assert(string.gsub("abc", "()%1", "x") == "abc")
+		check(string.rep("(", 40), "too many captures")
+		local ok, err = pcall(string.gsub, "abc", "a", "%2")
+		assert(not ok and err:find("invalid capture index", 1, true), err)
+		ok, err = pcall(string.gsub, "abc", "a", "%")
+		assert(not ok and err:find("invalid use of '%' in replacement string", 1, true), err)
+		ok, err = pcall(string.gsub, "abc", "a", function() return {} end)
+		assert(not ok and err:find("invalid replacement value (a table)", 1, true), err)
+		ok, err = pcall(string.find, string.rep("a", 300), string.rep("a?", 300) .. string.rep("a", 300))
+		assert(not ok and err:find("pattern too complex", 1, true), err)
+	`)
+}
+
+func TestStringDump(t *testing.T) {
+	testString(t, `
+		local function add(a, b) return a + b end
+		local f = load(string.dump(add), "add", "b")
+		assert(f(2, 3) == 5)
+		local g = load(string.dump(function(n)
+			local function fib(n) if n < 2 then return n end return fib(n - 1) + fib(n - 2) end
+			return fib(n)
+		end))
+		assert(g(10) == 55)
+		assert(not pcall(string.dump, print))
+		assert(load(string.dump(add), "add", "t") == nil)
+	`)
+}
+
+func TestStringDumpStripped(t *testing.T) {
+	testString(t, `
+		local function f(x)
+			local y = x * 2
+			return y
+		end
+		local full, stripped = string.dump(f), string.dump(f, true)
+		assert(#stripped < #full)
+		local g = load(stripped, "f", "b")
+		assert(g(21) == 42)
+		local ok, err = pcall(load(string.dump(function() error("boom") end, true), "e", "b"))
+		assert(not ok and err:find("boom", 1, true), err)
+	`)
+}
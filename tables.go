@@ -9,12 +9,31 @@ type table struct {
 	hash          map[value]value
 	metaTable     *table
 	flags         byte
+	mode          byte // weak keys, weak values, finalizable
 	iterationKeys []value
+	sweepAt       int    // size of a weak table's hash part triggering a sweep
+	finalizer     *table // copy of a finalizable table, passed to __gc
 }
 
 func newTable() *table                     { return &table{hash: make(map[value]value)} }
 func (t *table) invalidateTagMethodCache() { t.flags = 0 }
-func (t *table) atString(k string) value   { return t.hash[k] }
+
+func (t *table) atString(k string) value {
+	if t.mode&weakValues != 0 {
+		return strengthen(t.hash[k])
+	}
+	return t.hash[k]
+}
+
+// maxSizeHint bounds the elements preallocated for the size hints of NEWTABLE,
+// which in a corrupted binary chunk can ask for billions of elements. Larger
+// tables grow as they are filled.
+const maxSizeHint = 1 << 20
+
+// sizeHints decodes the array and hash size hints of a NEWTABLE instruction.
+func sizeHints(i instruction) (arraySize, hashSize int) {
+	return min(intFromFloat8(float8(i.b())), maxSizeHint), min(intFromFloat8(float8(i.c())), maxSizeHint)
+}
 
 func newTableWithSize(arraySize, hashSize int) *table {
 	t := new(table)
@@ -53,6 +72,8 @@ func (t *table) extendArray(last int) {
 func (t *table) atInt(k int) value {
 	if 0 < k && k <= len(t.array) {
 		return t.array[k-1]
+	} else if t.mode != 0 {
+		return t.weakAt(float64(k))
 	}
 	return t.hash[float64(k)]
 }
@@ -87,7 +108,9 @@ func (t *table) addOrInsertHash(k, v value) {
 }
 
 func (t *table) putAtInt(k int, v value) {
-	if 0 < k && k <= len(t.array) {
+	if t.mode != 0 {
+		t.weakPut(nil, float64(k), v)
+	} else if 0 < k && k <= len(t.array) {
 		t.array[k-1] = v
 	} else if k > 0 && v != nil && t.maybeResizeArray(k) {
 		t.array[k-1] = v
@@ -98,10 +121,24 @@ func (t *table) putAtInt(k int, v value) {
 	}
 }
 
+// floatKey returns the float64 used as the key of the integer k, if it has an
+// exact representation, so that k and the equal float index the same entry.
+func floatKey(k int64) (float64, bool) {
+	f := float64(k)
+	return f, f < 1<<63 && int64(f) == k
+}
+
 func (t *table) at(k value) value {
+	if t.mode != 0 {
+		return t.weakAt(k)
+	}
 	switch k := k.(type) {
 	case nil:
 		return nil
+	case int64:
+		if f, ok := floatKey(k); ok {
+			return t.at(f)
+		}
 	case float64:
 		if i := int(k); float64(i) == k { // OPT: Inlined copy of atInt.
 			if 0 < i && i <= len(t.array) {
@@ -116,9 +153,21 @@ func (t *table) at(k value) value {
 }
 
 func (t *table) put(l *State, k, v value) {
+	if t.mode != 0 {
+		t.weakPut(l, k, v)
+		return
+	}
 	switch k := k.(type) {
 	case nil:
 		l.runtimeError("table index is nil")
+	case int64:
+		if f, ok := floatKey(k); ok {
+			t.put(l, f, v)
+		} else if v == nil {
+			delete(t.hash, k)
+		} else {
+			t.addOrInsertHash(k, v)
+		}
 	case float64:
 		if i := int(k); float64(i) == k {
 			t.putAtInt(i, v)
@@ -146,8 +195,22 @@ func (t *table) put(l *State, k, v value) {
 
 // OPT: tryPut is an optimized variant of the at/put pair used by setTableAt to avoid hashing the key twice.
 func (t *table) tryPut(l *State, k, v value) bool {
+	if t.mode != 0 {
+		if v != nil && t.weakAt(k) != nil {
+			t.weakPut(l, k, v)
+			return true
+		}
+		return false
+	}
 	switch k := k.(type) {
 	case nil:
+	case int64:
+		if f, ok := floatKey(k); ok {
+			return t.tryPut(l, f, v)
+		} else if t.hash[k] != nil && v != nil {
+			t.hash[k] = v
+			return true
+		}
 	case float64:
 		if i := int(k); float64(i) == k && 0 < i && i <= len(t.array) && t.array[i-1] != nil {
 			t.array[i-1] = v
@@ -223,17 +286,22 @@ func arrayIndex(k value) int {
 
 func (l *State) next(t *table, key int) bool {
 	i, k := 0, l.stack[key]
+	if n, ok := k.(int64); ok {
+		if f, ok := floatKey(n); ok {
+			k = f
+		}
+	}
 	if k == nil { // first iteration
 	} else if i = arrayIndex(k); 0 < i && i <= len(t.array) {
 		k = nil
-	} else if _, ok := t.hash[k]; !ok {
+	} else if _, ok := t.hash[t.weakKey(k)]; !ok {
 		l.runtimeError("invalid key to 'next'") // key not found
 	} else {
 		i = len(t.array)
 	}
 	for ; i < len(t.array); i++ {
 		if t.array[i] != nil {
-			l.stack[key] = float64(i + 1)
+			l.stack[key] = l.integer(int64(i + 1))
 			l.stack[key+1] = t.array[i]
 			return true
 		}
@@ -249,11 +317,17 @@ func (l *State) next(t *table, key int) bool {
 	found := k == nil
 	for i, hk := range t.iterationKeys {
 		if hk == nil { // skip deleted key
-		} else if _, present := t.hash[hk]; !present {
+		} else if hv, present := t.hash[hk]; !present {
 			t.iterationKeys[i] = nil // mark key as deleted
+		} else if hk, hv = t.strong(hk, hv); hk == nil { // skip collected entry
 		} else if found {
 			l.stack[key] = hk
-			l.stack[key+1] = t.hash[hk]
+			if f, ok := hk.(float64); ok && l.integers() {
+				if i, ok := floatToInteger(f); ok {
+					l.stack[key] = i // integral float keys are integers
+				}
+			}
+			l.stack[key+1] = hv
 			return true
 		} else if l.equalObjects(hk, k) {
 			found = true
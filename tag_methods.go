@@ -16,10 +16,18 @@ const (
 	tmMod
 	tmPow
 	tmUnaryMinus
+	tmFloorDivide
+	tmBitwiseAnd
+	tmBitwiseOr
+	tmBitwiseXor
+	tmShiftLeft
+	tmShiftRight
+	tmBitwiseNot
 	tmLT
 	tmLE
 	tmConcat
 	tmCall
+	tmClose
 	tmCount // number of tag methods
 )
 
@@ -37,10 +45,18 @@ var eventNames = []string{
 	"__mod",
 	"__pow",
 	"__unm",
+	"__idiv",
+	"__band",
+	"__bor",
+	"__bxor",
+	"__shl",
+	"__shr",
+	"__bnot",
 	"__lt",
 	"__le",
 	"__concat",
 	"__call",
+	"__close",
 }
 
 var typeNames = []string{
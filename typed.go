@@ -0,0 +1,156 @@
+package lua
+
+import (
+	"errors"
+	"reflect"
+)
+
+// Check returns the function argument at index converted to T, or raises an
+// error with the standard messages of CheckString, CheckNumber and the like,
+// e.g. "bad argument #1 to 'f' (number expected, got string)". Strings and
+// numbers are coerced as by CheckString and CheckNumber, booleans follow the
+// Lua notion of truth, and other types are converted as by ToGoValue.
+func Check[T any](l *State, index int) T {
+	var v T
+	r := reflect.ValueOf(&v).Elem()
+	switch r.Kind() {
+	case reflect.Bool:
+		r.SetBool(l.ToBoolean(index))
+		return v
+	case reflect.String:
+		CheckString(l, index)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		CheckNumber(l, index)
+	case reflect.Struct, reflect.Map, reflect.Array, reflect.Slice:
+		bytes := r.Kind() == reflect.Slice && r.Type().Elem().Kind() == reflect.Uint8
+		if !(bytes && l.IsString(index)) && !l.IsTable(index) && !l.IsUserData(index) {
+			tagError(l, index, TypeTable)
+		}
+	case reflect.Func:
+		if !l.IsFunction(index) && !l.IsUserData(index) {
+			tagError(l, index, TypeFunction)
+		}
+	case reflect.Ptr, reflect.Chan, reflect.Interface:
+		if l.IsNone(index) {
+			ArgumentError(l, index, "value expected")
+		}
+	}
+	r.Set(checkGoValue(l, index, r.Type()))
+	return v
+}
+
+// Opt returns the function argument at index converted to T, as by Check,
+// or def if the argument is absent or nil.
+func Opt[T any](l *State, index int, def T) T {
+	if l.IsNoneOrNil(index) {
+		return def
+	}
+	return Check[T](l, index)
+}
+
+// Pair is the result type of a typed function returning two values.
+type Pair[A, B any] struct {
+	First  A
+	Second B
+}
+
+// Triple is the result type of a typed function returning three values.
+type Triple[A, B, C any] struct {
+	First  A
+	Second B
+	Third  C
+}
+
+type multipleResults interface{ push(l *State) int }
+
+func (p Pair[A, B]) push(l *State) int {
+	l.PushGoValue(p.First)
+	l.PushGoValue(p.Second)
+	return 2
+}
+
+func (t Triple[A, B, C]) push(l *State) int {
+	l.PushGoValue(t.First)
+	l.PushGoValue(t.Second)
+	l.PushGoValue(t.Third)
+	return 3
+}
+
+type failure struct{ error }
+
+func (f failure) Unwrap() error { return f.error }
+
+// Fail marks err so that a typed function returning it reports the error to
+// Lua as results, following the conventions of FileResult (nil, the message,
+// and an error number), rather than raising it. This is the usual Lua style
+// for failures which are not programming errors, e.g. a missing file.
+func Fail(err error) error {
+	if err == nil {
+		return nil
+	}
+	return failure{err}
+}
+
+// results pushes the results of a typed function.
+func results[R any](l *State, r R, err error) int {
+	if err != nil {
+		if f := (failure{}); errors.As(err, &f) {
+			return FileResult(l, f.error, "")
+		}
+		Errorf(l, "%s", err.Error())
+	}
+	switch r := any(r).(type) {
+	case struct{}:
+		return 0
+	case multipleResults:
+		return r.push(l)
+	}
+	l.PushGoValue(r)
+	return 1
+}
+
+// Func0 adapts a typed Go function with no arguments to a Function.
+//
+// The result is pushed with PushGoValue, unless R is a Pair or Triple, whose
+// values are pushed as separate results, or struct{}, which pushes none. A
+// non-nil error is raised as a Lua error, or returned as nil and its message
+// if it was marked with Fail.
+func Func0[R any](f func() (R, error)) Function {
+	return func(l *State) int {
+		r, err := f()
+		return results(l, r, err)
+	}
+}
+
+// Func1 adapts a typed Go function with one argument to a Function. The
+// argument is checked with Check; results are handled as by Func0.
+//
+//	l.Register("repeat", lua.Func1(func(s string) (lua.Pair[string, int], error) {
+//		return lua.Pair[string, int]{s + s, 2 * len(s)}, nil
+//	}))
+func Func1[A, R any](f func(A) (R, error)) Function {
+	return func(l *State) int {
+		r, err := f(Check[A](l, 1))
+		return results(l, r, err)
+	}
+}
+
+// Func2 adapts a typed Go function with two arguments to a Function. The
+// arguments are checked with Check; results are handled as by Func0.
+func Func2[A, B, R any](f func(A, B) (R, error)) Function {
+	return func(l *State) int {
+		r, err := f(Check[A](l, 1), Check[B](l, 2))
+		return results(l, r, err)
+	}
+}
+
+// Func3 adapts a typed Go function with three arguments to a Function. The
+// arguments are checked with Check; results are handled as by Func0.
+func Func3[A, B, C, R any](f func(A, B, C) (R, error)) Function {
+	return func(l *State) int {
+		r, err := f(Check[A](l, 1), Check[B](l, 2), Check[C](l, 3))
+		return results(l, r, err)
+	}
+}
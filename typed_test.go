@@ -0,0 +1,81 @@
+package lua
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestTypedFunctions(t *testing.T) {
+	l := NewState()
+	OpenLibraries(l)
+	l.Register("rep", Func2(func(s string, n int) (string, error) {
+		if n < 0 {
+			return "", errors.New("negative count")
+		}
+		return strings.Repeat(s, n), nil
+	}))
+	l.Register("split", Func2(func(s, sep string) (Pair[string, string], error) {
+		before, after, _ := strings.Cut(s, sep)
+		return Pair[string, string]{before, after}, nil
+	}))
+	l.Register("open", Func1(func(name string) (bool, error) {
+		return false, Fail(fmt.Errorf("%s: no such file", name))
+	}))
+	l.Register("sum", Func1(func(xs []float64) (float64, error) {
+		sum := 0.0
+		for _, x := range xs {
+			sum += x
+		}
+		return sum, nil
+	}))
+	l.Register("nothing", Func0(func() (struct{}, error) { return struct{}{}, nil }))
+	l.Register("greet", Func3(func(greeting string, name string, excited bool) (string, error) {
+		if excited {
+			return greeting + ", " + name + "!", nil
+		}
+		return greeting + ", " + name, nil
+	}))
+	err := DoString(l, `
+		assert(rep("ab", 2) == "abab")
+		assert(rep(1, "2") == "11")
+		local ok, err = pcall(rep, "ab", "x")
+		assert(not ok and err == "bad argument #2 to 'rep' (number expected, got string)", err)
+		ok, err = pcall(rep, "ab")
+		assert(not ok and err:find("number expected, got no value"), err)
+		ok, err = pcall(rep, "ab", 1.5)
+		assert(not ok and err:find("no integer representation"), err)
+		ok, err = pcall(rep, "ab", -1)
+		assert(not ok and err:find("negative count"), err)
+
+		local a, b = split("key=value", "=")
+		assert(a == "key" and b == "value")
+
+		local r, msg, code = open("x")
+		assert(r == nil and msg == "x: no such file" and code == 0)
+
+		assert(sum({1, 2, 3}) == 6)
+		ok, err = pcall(sum, 1)
+		assert(not ok and err:find("table expected, got number"), err)
+		assert(select("#", nothing()) == 0)
+		assert(greet("hello", "world") == "hello, world")
+		assert(greet("hello", "world", 1) == "hello, world!")
+	`)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+}
+
+func TestOpt(t *testing.T) {
+	l := NewState()
+	OpenLibraries(l)
+	l.Register("f", func(l *State) int {
+		l.PushString(Opt(l, 1, "default"))
+		l.PushInteger(Opt(l, 2, 7))
+		return 2
+	})
+	if err := DoString(l, `local s, n = f() assert(s == "default" and n == 7) s, n = f("x", 3) assert(s == "x" and n == 3)`); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"math"
 	"reflect"
 	"runtime"
+	"strconv"
 	"strings"
 )
 
@@ -33,6 +34,8 @@ func debugValue(v value) string {
 		return "'" + v + "'"
 	case float64:
 		return fmt.Sprintf("%f", v)
+	case int64:
+		return fmt.Sprintf("%d", v)
 	case *luaClosure:
 		return fmt.Sprintf("closure %s:%d %v", v.prototype.source, v.prototype.lineDefined, v)
 	case *goClosure:
@@ -71,17 +74,20 @@ func isFalse(s value) bool {
 type localVariable struct {
 	name           string
 	startPC, endPC pc
+	readOnly       bool // <const> or <close>, only known while compiling
 }
 
 type userData struct {
 	metaTable, env *table
 	data           interface{}
+	finalizer      *userData // copy passed to __gc
 }
 
 type upValueDesc struct {
-	name    string
-	isLocal bool
-	index   int
+	name     string
+	isLocal  bool
+	index    int
+	readOnly bool // only known while compiling
 }
 
 type stackLocation struct {
@@ -245,42 +251,154 @@ func arith(op Operator, v1, v2 float64) float64 {
 		return math.Pow(v1, v2)
 	case OpUnaryMinus:
 		return -v1
+	case OpFloorDivide:
+		return math.Floor(v1 / v2)
 	}
 	panic(fmt.Sprintf("not an arithmetic op code (%d)", op))
 }
 
-func (l *State) parseNumber(s string) (v float64, ok bool) { // TODO this is f*cking ugly - scanner.readNumber should be refactored.
+// integerArith performs op on integers, wrapping around on overflow. The
+// caller must check for division by zero.
+func integerArith(op Operator, v1, v2 int64) int64 {
+	switch op {
+	case OpAdd:
+		return v1 + v2
+	case OpSub:
+		return v1 - v2
+	case OpMul:
+		return v1 * v2
+	case OpMod:
+		m := v1 % v2
+		if m != 0 && m^v2 < 0 { // result must have the sign of the divisor
+			m += v2
+		}
+		return m
+	case OpUnaryMinus:
+		return -v1
+	case OpFloorDivide:
+		q := v1 / v2
+		if v1%v2 != 0 && v1^v2 < 0 { // round towards minus infinity
+			q--
+		}
+		return q
+	case OpBitwiseAnd:
+		return v1 & v2
+	case OpBitwiseOr:
+		return v1 | v2
+	case OpBitwiseXor:
+		return v1 ^ v2
+	case OpShiftLeft:
+		return shiftLeft(v1, v2)
+	case OpShiftRight:
+		return shiftLeft(v1, -v2)
+	case OpBitwiseNot:
+		return ^v1
+	}
+	panic(fmt.Sprintf("not an integer op code (%d)", op))
+}
+
+// shiftLeft shifts x left by n bits, or right by -n bits if n is negative.
+// Shifts are logical, so vacated bits are always zero.
+func shiftLeft(x, n int64) int64 {
+	switch {
+	case n <= -64 || n >= 64:
+		return 0
+	case n >= 0:
+		return int64(uint64(x) << uint(n))
+	}
+	return int64(uint64(x) >> uint(-n))
+}
+
+func isBitwise(op Operator) bool { return OpBitwiseAnd <= op && op <= OpBitwiseNot }
+
+// numberToInteger converts a number, a float64 or an int64, to an int64, if
+// it has an exact representation.
+func numberToInteger(n value) (int64, bool) {
+	if i, ok := n.(int64); ok {
+		return i, true
+	}
+	return floatToInteger(n.(float64))
+}
+
+// numberArith performs op on numbers, each a float64 or an int64, producing
+// an integer if both are integers and op is not a division or power.
+func numberArith(op Operator, v1, v2 value) value {
+	if i1, ok := v1.(int64); ok && op != OpDiv && op != OpPow {
+		if i2, ok := v2.(int64); ok {
+			return integerArith(op, i1, i2)
+		}
+	}
+	return arith(op, toFloat(v1), toFloat(v2))
+}
+
+func isNumber(v value) bool {
+	switch v.(type) {
+	case float64, int64:
+		return true
+	}
+	return false
+}
+
+// toFloat converts a number, a float64 or an int64, to a float64.
+func toFloat(n value) float64 {
+	if i, ok := n.(int64); ok {
+		return float64(i)
+	}
+	return n.(float64)
+}
+
+// floatToInteger converts f to an int64, if it has an exact representation.
+func floatToInteger(f float64) (int64, bool) {
+	if -1<<63 <= f && f < 1<<63 {
+		if i := int64(f); float64(i) == f {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (l *State) parseNumber(s string) (v value, ok bool) { // TODO this is f*cking ugly - scanner.readNumber should be refactored.
 	if len(strings.Fields(s)) != 1 || strings.ContainsRune(s, 0) {
 		return
 	}
-	scanner := scanner{l: l, r: strings.NewReader(s)}
+	scanner := scanner{l: l, dialect: l.global.dialect, r: strings.NewReader(s)}
 	t := scanner.scan()
-	if t.t == '-' {
-		if t := scanner.scan(); t.t == tkNumber {
-			v, ok = -t.n, true
-		}
+	negative := t.t == '-'
+	if negative || t.t == '+' {
+		t = scanner.scan()
+	}
+	if t.t == tkNumber && negative {
+		v, ok = -t.n, true
 	} else if t.t == tkNumber {
 		v, ok = t.n, true
-	} else if t.t == '+' {
-		if t := scanner.scan(); t.t == tkNumber {
-			v, ok = t.n, true
-		}
+	} else if t.t == tkInteger && negative {
+		v, ok = -t.i, true
+	} else if t.t == tkInteger {
+		v, ok = t.i, true
 	}
 	if ok && scanner.scan().t != tkEOS {
 		ok = false
-	} else if math.IsInf(v, 0) || math.IsNaN(v) {
+	} else if f, isFloat := v.(float64); isFloat && (math.IsInf(f, 0) || math.IsNaN(f)) {
 		ok = false
 	}
 	return
 }
 
-func (l *State) toNumber(r value) (v float64, ok bool) {
-	if v, ok = r.(float64); ok {
-		return
+func (l *State) toNumber(r value) (float64, bool) {
+	if v, ok := l.toNumeric(r); ok {
+		return toFloat(v), true
 	}
-	var s string
-	if s, ok = r.(string); ok {
-		if err := l.protectedCall(func() { v, ok = l.parseNumber(strings.TrimSpace(s)) }, l.top, l.errorFunction); err != nil {
+	return 0, false
+}
+
+// toNumeric converts r to a number, either a float64 or an int64, keeping
+// the subtype of numbers and parsing strings.
+func (l *State) toNumeric(r value) (v value, ok bool) {
+	switch r := r.(type) {
+	case float64, int64:
+		return r, true
+	case string:
+		if err := l.protectedCall(func() { v, ok = l.parseNumber(strings.TrimSpace(r)) }, l.top, l.errorFunction); err != nil {
 			l.pop() // Remove error message from the stack.
 			ok = false
 		}
@@ -289,7 +407,7 @@ func (l *State) toNumber(r value) (v float64, ok bool) {
 }
 
 func (l *State) toString(index int) (s string, ok bool) {
-	if s, ok = toString(l.stack[index]); ok {
+	if s, ok = l.valueToString(l.stack[index]); ok {
 		l.stack[index] = s
 	}
 	return
@@ -299,12 +417,18 @@ func numberToString(f float64) string {
 	return fmt.Sprintf("%.14g", f)
 }
 
-func toString(r value) (string, bool) {
+func (l *State) valueToString(r value) (string, bool) {
 	switch r := r.(type) {
 	case string:
 		return r, true
 	case float64:
-		return numberToString(r), true
+		s := numberToString(r)
+		if l.integers() && strings.IndexAny(s, ".eEin") < 0 { // looks like an integer
+			s += ".0"
+		}
+		return s, true
+	case int64:
+		return strconv.FormatInt(r, 10), true
 	}
 	return "", false
 }
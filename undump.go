@@ -6,15 +6,17 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"strings"
 	"unsafe"
 )
 
 type loadState struct {
-	in    io.Reader
-	order binary.ByteOrder
+	in     io.Reader
+	format ChunkFormat
+	depth  int // of nested functions, bounded like the parser's nesting
 }
 
-var header struct {
+type chunkHeader struct {
 	Signature                            [4]byte
 	Version, Format, Endianness, IntSize byte
 	PointerSize, InstructionSize         byte
@@ -22,28 +24,145 @@ var header struct {
 	Tail                                 [6]byte
 }
 
+var header chunkHeader
+
+// A ChunkFormat describes the layout of binary chunks on a platform, as
+// given by the byte order and the sizes of C types in the reference
+// implementation which compiled them. Chunks in any such format can be
+// loaded, and DumpWith can write them for other platforms.
+type ChunkFormat struct {
+	ByteOrder      binary.ByteOrder // binary.LittleEndian or binary.BigEndian
+	IntSize        int              // of a C int, 4 or 8 bytes
+	SizeTSize      int              // of a C size_t, 4 or 8 bytes
+	NumberSize     int              // of a lua_Number, 4 or 8 bytes
+	IntegralNumber bool             // if lua_Number is an integer type
+}
+
+// HostFormat is the format of binary chunks compiled on this platform, as
+// written by Dump.
+var HostFormat ChunkFormat
+
+func validSize(n int) bool { return n == 4 || n == 8 }
+
+// header returns the header of chunks in format f, and whether f is a
+// layout that Lua 5.2 can be built with.
+func (f ChunkFormat) header() (h chunkHeader, ok bool) {
+	h = header
+	switch f.ByteOrder {
+	case binary.LittleEndian:
+		h.Endianness = 1
+	case binary.BigEndian:
+		h.Endianness = 0
+	case binary.NativeEndian:
+	default:
+		return h, false
+	}
+	h.IntSize, h.PointerSize, h.NumberSize = byte(f.IntSize), byte(f.SizeTSize), byte(f.NumberSize)
+	h.IntegralNumber = 0
+	if f.IntegralNumber {
+		h.IntegralNumber = 1
+	}
+	return h, validSize(f.IntSize) && validSize(f.SizeTSize) && validSize(f.NumberSize)
+}
+
+// format returns the format of chunks with header h, and whether it is one
+// that can be loaded.
+func (h chunkHeader) format() (f ChunkFormat, ok bool) {
+	switch h.Endianness {
+	case 0:
+		f.ByteOrder = binary.BigEndian
+	case 1:
+		f.ByteOrder = binary.LittleEndian
+	default:
+		return f, false
+	}
+	f.IntSize, f.SizeTSize, f.NumberSize = int(h.IntSize), int(h.PointerSize), int(h.NumberSize)
+	f.IntegralNumber = h.IntegralNumber != 0
+	return f, h.InstructionSize == 4 && h.IntegralNumber <= 1 &&
+		validSize(f.IntSize) && validSize(f.SizeTSize) && validSize(f.NumberSize)
+}
+
+// typeInteger tags integer constants in binary chunks, as in Lua 5.3. Such
+// constants only occur in chunks compiled with the Integers dialect.
+const typeInteger = byte(TypeNumber) | 1<<4
+
 var (
 	errUnknownConstantType = errors.New("lua: unknown constant type in lua binary")
 	errNotPrecompiledChunk = errors.New("lua: is not a precompiled chunk")
 	errVersionMismatch     = errors.New("lua: version mismatch in precompiled chunk")
 	errIncompatible        = errors.New("lua: incompatible precompiled chunk")
 	errCorrupted           = errors.New("lua: corrupted precompiled chunk")
+	errTruncated           = errors.New("lua: truncated precompiled chunk")
 )
 
 func (state *loadState) read(data interface{}) error {
-	return binary.Read(state.in, state.order, data)
+	return binary.Read(state.in, state.format.ByteOrder, data)
 }
 
+// readNumber reads a lua_Number, converting it to a float64.
 func (state *loadState) readNumber() (f float64, err error) {
-	err = state.read(&f)
+	switch format := state.format; {
+	case format.IntegralNumber && format.NumberSize == 4:
+		var i int32
+		err = state.read(&i)
+		f = float64(i)
+	case format.IntegralNumber:
+		var i int64
+		err = state.read(&i)
+		f = float64(i)
+	case format.NumberSize == 4:
+		var g float32
+		err = state.read(&g)
+		f = float64(g)
+	default:
+		err = state.read(&f)
+	}
 	return
 }
 
-func (state *loadState) readInt() (i int32, err error) {
+func (state *loadState) readInteger() (i int64, err error) {
 	err = state.read(&i)
 	return
 }
 
+// readInt reads a C int. Wider ints must fit in 32 bits, as the prototype
+// fields they are read into.
+func (state *loadState) readInt() (i int32, err error) {
+	if state.format.IntSize == 4 {
+		err = state.read(&i)
+		return
+	}
+	var wide int64
+	if err = state.read(&wide); err == nil && wide != int64(int32(wide)) {
+		err = errCorrupted
+	}
+	return int32(wide), err
+}
+
+// readCount reads the length of an array in the chunk.
+func (state *loadState) readCount() (int, error) {
+	n, err := state.readInt()
+	if err == nil && n < 0 {
+		err = errCorrupted
+	}
+	return int(n), err
+}
+
+// maxPrealloc bounds the elements allocated ahead of reading them, so that a
+// corrupted length fails at the end of the chunk instead of exhausting memory.
+const maxPrealloc = 1 << 16
+
+func readArray[T any](state *loadState, n int) (a []T, err error) {
+	a = make([]T, 0, min(n, maxPrealloc))
+	for len(a) < n && err == nil {
+		chunk := make([]T, min(n-len(a), maxPrealloc))
+		if err = state.read(chunk); err == nil {
+			a = append(a, chunk...)
+		}
+	}
+	return
+}
+
 func (state *loadState) readPC() (pc, error) {
 	i, err := state.readInt()
 	return pc(i), err
@@ -60,47 +179,40 @@ func (state *loadState) readBool() (bool, error) {
 }
 
 func (state *loadState) readString() (s string, err error) {
-	// Feel my pain
-	maxUint := ^uint(0)
-	var size uintptr
-	var size64 uint64
-	var size32 uint32
-	if uint64(maxUint) == math.MaxUint64 {
-		err = state.read(&size64)
-		size = uintptr(size64)
-	} else if maxUint == math.MaxUint32 {
+	var size uint64
+	if state.format.SizeTSize == 4 {
+		var size32 uint32
 		err = state.read(&size32)
-		size = uintptr(size32)
+		size = uint64(size32)
 	} else {
-		panic(fmt.Sprintf("unsupported pointer size (%d)", maxUint))
+		err = state.read(&size)
 	}
 	if err != nil || size == 0 {
 		return
+	} else if size > math.MaxInt32 {
+		return "", errCorrupted
 	}
-	ba := make([]byte, size)
-	if err = state.read(ba); err == nil {
+	var ba []byte
+	if ba, err = readArray[byte](state, int(size)); err == nil {
 		s = string(ba[:len(ba)-1])
 	}
 	return
 }
 
 func (state *loadState) readCode() (code []instruction, err error) {
-	n, err := state.readInt()
+	n, err := state.readCount()
 	if err != nil || n == 0 {
 		return
 	}
-	code = make([]instruction, n)
-	err = state.read(code)
-	return
+	return readArray[instruction](state, n)
 }
 
 func (state *loadState) readUpValues() (u []upValueDesc, err error) {
-	n, err := state.readInt()
+	n, err := state.readCount()
 	if err != nil || n == 0 {
 		return
 	}
-	v := make([]struct{ IsLocal, Index byte }, n)
-	err = state.read(v)
+	v, err := readArray[struct{ IsLocal, Index byte }](state, n)
 	if err != nil {
 		return
 	}
@@ -112,37 +224,50 @@ func (state *loadState) readUpValues() (u []upValueDesc, err error) {
 }
 
 func (state *loadState) readLocalVariables() (localVariables []localVariable, err error) {
-	var n int32
-	if n, err = state.readInt(); err != nil || n == 0 {
+	var n int
+	if n, err = state.readCount(); err != nil || n == 0 {
 		return
 	}
-	localVariables = make([]localVariable, n)
-	for i := range localVariables {
-		if localVariables[i].name, err = state.readString(); err != nil {
+	localVariables = make([]localVariable, 0, min(n, maxPrealloc))
+	for range n {
+		var v localVariable
+		if v.name, err = state.readString(); err != nil {
 			return
 		}
-		if localVariables[i].startPC, err = state.readPC(); err != nil {
+		if v.startPC, err = state.readPC(); err != nil {
 			return
 		}
-		if localVariables[i].endPC, err = state.readPC(); err != nil {
+		if v.endPC, err = state.readPC(); err != nil {
 			return
 		}
+		localVariables = append(localVariables, v)
 	}
 	return
 }
 
 func (state *loadState) readLineInfo() (lineInfo []int32, err error) {
-	var n int32
-	if n, err = state.readInt(); err != nil || n == 0 {
+	var n int
+	if n, err = state.readCount(); err != nil || n == 0 {
+		return
+	} else if state.format.IntSize == 4 {
+		return readArray[int32](state, n)
+	}
+	wide, err := readArray[int64](state, n)
+	if err != nil {
 		return
 	}
 	lineInfo = make([]int32, n)
-	err = state.read(lineInfo)
+	for i, line := range wide {
+		if line != int64(int32(line)) {
+			return nil, errCorrupted
+		}
+		lineInfo[i] = int32(line)
+	}
 	return
 }
 
 func (state *loadState) readDebug(p *prototype) (source string, lineInfo []int32, localVariables []localVariable, names []string, err error) {
-	var n int32
+	var n int
 	if source, err = state.readString(); err != nil {
 		return
 	}
@@ -152,58 +277,70 @@ func (state *loadState) readDebug(p *prototype) (source string, lineInfo []int32
 	if localVariables, err = state.readLocalVariables(); err != nil {
 		return
 	}
-	if n, err = state.readInt(); err != nil {
+	if n, err = state.readCount(); err != nil {
 		return
 	}
-	names = make([]string, n)
-	for i := range names {
-		if names[i], err = state.readString(); err != nil {
+	names = make([]string, 0, min(n, maxPrealloc))
+	for range n {
+		var name string
+		if name, err = state.readString(); err != nil {
 			return
 		}
+		names = append(names, name)
 	}
 	return
 }
 
 func (state *loadState) readConstants() (constants []value, prototypes []prototype, err error) {
-	var n int32
-	if n, err = state.readInt(); err != nil || n == 0 {
+	var n int
+	if n, err = state.readCount(); err != nil || n == 0 {
 		return
 	}
 
-	constants = make([]value, n)
-	for i := range constants {
+	constants = make([]value, 0, min(n, maxPrealloc))
+	for range n {
 		var t byte
+		var k value
 		switch t, err = state.readByte(); {
 		case err != nil:
 			return
 		case t == byte(TypeNil):
-			constants[i] = nil
+			k = nil
 		case t == byte(TypeBoolean):
-			constants[i], err = state.readBool()
+			k, err = state.readBool()
 		case t == byte(TypeNumber):
-			constants[i], err = state.readNumber()
+			k, err = state.readNumber()
+		case t == typeInteger:
+			k, err = state.readInteger()
 		case t == byte(TypeString):
-			constants[i], err = state.readString()
+			k, err = state.readString()
 		default:
 			err = errUnknownConstantType
 		}
 		if err != nil {
 			return
 		}
+		constants = append(constants, k)
 	}
 	return
 }
 
 func (state *loadState) readPrototypes() (prototypes []prototype, err error) {
-	var n int32
-	if n, err = state.readInt(); err != nil || n == 0 {
+	var n int
+	if n, err = state.readCount(); err != nil || n == 0 {
 		return
 	}
-	prototypes = make([]prototype, n)
-	for i := range prototypes {
-		if prototypes[i], err = state.readFunction(); err != nil {
+	if state.depth++; state.depth > maxCallCount {
+		return nil, errCorrupted
+	}
+	defer func() { state.depth-- }()
+	prototypes = make([]prototype, 0, min(n, maxPrealloc))
+	for range n {
+		var p prototype
+		if p, err = state.readFunction(); err != nil {
 			return
 		}
+		prototypes = append(prototypes, p)
 	}
 	return
 }
@@ -247,6 +384,9 @@ func (state *loadState) readFunction() (p prototype, err error) {
 	if p.source, p.lineInfo, p.localVariables, names, err = state.readDebug(&p); err != nil {
 		return
 	}
+	if len(names) > len(p.upValues) {
+		return p, errCorrupted
+	}
 	for i, name := range names {
 		p.upValues[i].name = name
 	}
@@ -278,6 +418,7 @@ func init() {
 	if uintptrBitCount != header.PointerSize*8 {
 		panic(fmt.Sprintf("invalid pointer size (%d)", uintptrBitCount))
 	}
+	HostFormat, _ = header.format()
 }
 
 func endianness() binary.ByteOrder {
@@ -287,12 +428,11 @@ func endianness() binary.ByteOrder {
 	return binary.BigEndian
 }
 
+// checkHeader reads the header of a chunk, and the format of the rest.
 func (state *loadState) checkHeader() error {
-	h := header
+	var h chunkHeader
 	if err := state.read(&h); err != nil {
 		return err
-	} else if h == header {
-		return nil
 	} else if string(h.Signature[:]) != Signature {
 		return errNotPrecompiledChunk
 	} else if h.Version != header.Version || h.Format != header.Format {
@@ -300,21 +440,44 @@ func (state *loadState) checkHeader() error {
 	} else if h.Tail != header.Tail {
 		return errCorrupted
 	}
-	return errIncompatible
+	format, ok := h.format()
+	if !ok {
+		return errIncompatible
+	}
+	state.format = format
+	return nil
 }
 
-func (l *State) undump(in io.Reader, name string) (c *luaClosure, err error) {
+// undumpMessage returns the error message for a binary chunk name which
+// could not be undumped because of err.
+func undumpMessage(name string, err error) string {
 	if name[0] == '@' || name[0] == '=' {
 		name = name[1:]
 	} else if name[0] == Signature[0] {
 		name = "binary string"
 	}
-	// TODO assign name to p.source?
-	s := &loadState{in, endianness()}
-	var p prototype
-	if err = s.checkHeader(); err != nil {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = errTruncated
+	}
+	return name + ": " + strings.TrimPrefix(err.Error(), "lua: ")
+}
+
+// readChunk reads the header and main function of a binary chunk.
+func readChunk(in io.Reader) (p prototype, err error) {
+	s := &loadState{in: in, format: HostFormat}
+	if err = s.checkHeader(); err == nil {
+		p, err = s.readFunction()
+	}
+	return
+}
+
+// undump reads a binary chunk and verifies its code, then pushes a closure
+// of its main function.
+func (l *State) undump(in io.Reader, name string) (c *luaClosure, err error) {
+	p, err := readChunk(in)
+	if err != nil {
 		return
-	} else if p, err = s.readFunction(); err != nil {
+	} else if err = verify(&p, nil); err != nil {
 		return
 	}
 	c = l.newLuaClosure(&p)
@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -16,11 +17,7 @@ func TestAllHeaderNoFun(t *testing.T) {
 
 func TestWrongEndian(t *testing.T) {
 	h := header
-	if h.Endianness == 0 {
-		h.Endianness = 1
-	} else {
-		h.Endianness = 0
-	}
+	h.Endianness = 2
 	expectErrorFromUndump(errIncompatible, h, t)
 }
 
@@ -32,10 +29,75 @@ func TestWrongVersion(t *testing.T) {
 
 func TestWrongNumberSize(t *testing.T) {
 	h := header
-	h.NumberSize /= 2
+	h.NumberSize = 2
+	expectErrorFromUndump(errIncompatible, h, t)
+}
+
+func TestWrongInstructionSize(t *testing.T) {
+	h := header
+	h.InstructionSize = 8
 	expectErrorFromUndump(errIncompatible, h, t)
 }
 
+func TestChunkFormats(t *testing.T) {
+	b, err := os.ReadFile("fixtures/fib.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewState()
+	OpenLibraries(l)
+	if err := l.Load(bytes.NewReader(b), "fib", "b"); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	expected := l.ToPrototype(-1)
+	var host bytes.Buffer
+	if err := l.DumpWith(&host, DumpOptions{Format: HostFormat}); err != nil {
+		t.Fatalf("error: %s", err)
+	} else if !bytes.Equal(host.Bytes(), b) {
+		t.Error("expected the host format to reproduce fixtures/fib.bin")
+	}
+	for _, f := range []ChunkFormat{
+		{ByteOrder: binary.BigEndian, IntSize: 4, SizeTSize: 4, NumberSize: 8},
+		{ByteOrder: binary.LittleEndian, IntSize: 8, SizeTSize: 8, NumberSize: 4},
+		{ByteOrder: binary.BigEndian, IntSize: 4, SizeTSize: 8, NumberSize: 4, IntegralNumber: true},
+		{ByteOrder: binary.LittleEndian, IntSize: 8, SizeTSize: 4, NumberSize: 8, IntegralNumber: true},
+	} {
+		var chunk bytes.Buffer
+		if err := l.DumpWith(&chunk, DumpOptions{Format: f}); err != nil {
+			t.Fatalf("%+v: error: %s", f, err)
+		}
+		h := chunk.Bytes()[:binary.Size(header)]
+		if h[6] != map[binary.ByteOrder]byte{binary.BigEndian: 0, binary.LittleEndian: 1}[f.ByteOrder] ||
+			int(h[7]) != f.IntSize || int(h[8]) != f.SizeTSize || h[9] != 4 || int(h[10]) != f.NumberSize || (h[11] == 1) != f.IntegralNumber {
+			t.Errorf("%+v: unexpected header % x", f, h)
+		}
+		m := NewState()
+		OpenLibraries(m)
+		var out bytes.Buffer
+		m.SetStdout(&out)
+		if err := m.Load(&chunk, "fib", "b"); err != nil {
+			t.Fatalf("%+v: error: %s", f, m.ToValue(-1))
+		} else if !reflect.DeepEqual(m.ToPrototype(-1), expected) {
+			t.Errorf("%+v: expected the same prototype after converting the chunk", f)
+		}
+		m.Call(0, 0)
+		if out.String() != "6765\n6765\n6765\n" {
+			t.Errorf("%+v: unexpected output %q", f, out.String())
+		}
+	}
+
+	if err := LoadString(l, "return 0.5"); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	integral := ChunkFormat{ByteOrder: binary.BigEndian, IntSize: 4, SizeTSize: 4, NumberSize: 4, IntegralNumber: true}
+	if err := l.DumpWith(io.Discard, DumpOptions{Format: integral}); err == nil || err.Error() != "lua: number 0.5 not representable in a 32-bit integral chunk format" {
+		t.Errorf("unexpected error %v for a non-integral constant", err)
+	}
+	if err := l.DumpWith(io.Discard, DumpOptions{Format: ChunkFormat{ByteOrder: binary.BigEndian, IntSize: 2, SizeTSize: 4, NumberSize: 8}}); err != errUnsupportedFormat {
+		t.Errorf("expected %v but found %v", errUnsupportedFormat, err)
+	}
+}
+
 func TestCorruptTail(t *testing.T) {
 	h := header
 	h.Tail[3] += 1
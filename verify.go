@@ -0,0 +1,242 @@
+package lua
+
+import (
+	"errors"
+	"fmt"
+)
+
+var errBadCode = errors.New("lua: bad code in precompiled chunk")
+
+// A verifier checks the prototypes of a binary chunk before they are run, so
+// that a malformed chunk is rejected rather than making the virtual machine
+// index out of its registers, constants, up values or code. The checks are
+// those of the Lua 5.1 verifier, adapted to the 5.2 instruction set.
+type verifier struct {
+	p  *prototype
+	pc int // of the instruction being checked, or -1
+}
+
+func (v *verifier) errorf(format string, args ...interface{}) error {
+	where := fmt.Sprintf("function <%s:%d>", chunkID(v.p.source), v.p.lineDefined)
+	if v.pc >= 0 {
+		where = fmt.Sprintf("instruction %d of %s", v.pc+1, where)
+	}
+	return fmt.Errorf("%w: %s in %s", errBadCode, fmt.Sprintf(format, args...), where)
+}
+
+// verify checks p and the functions it defines, whose up values are captured
+// from p. parent is nil for the main function of a chunk.
+func verify(p, parent *prototype) error {
+	v := verifier{p: p, pc: -1}
+	return v.function(parent)
+}
+
+func (v *verifier) function(parent *prototype) error {
+	p := v.p
+	switch {
+	case p.parameterCount > p.maxStackSize:
+		return v.errorf("%d parameters exceed stack size %d", p.parameterCount, p.maxStackSize)
+	case len(p.upValues) > maxUpValue:
+		return v.errorf("too many up values (%d)", len(p.upValues))
+	case len(p.code) == 0 || p.code[len(p.code)-1].opCode() != opReturn:
+		return v.errorf("missing final RETURN")
+	case len(p.lineInfo) != 0 && len(p.lineInfo) != len(p.code):
+		return v.errorf("line information for %d of %d instructions", len(p.lineInfo), len(p.code))
+	}
+	for _, u := range p.upValues {
+		if parent == nil {
+			break
+		} else if u.isLocal && u.index >= parent.maxStackSize {
+			return v.errorf("up value captures register %d out of range", u.index)
+		} else if !u.isLocal && u.index >= len(parent.upValues) {
+			return v.errorf("up value captures up value %d out of range", u.index)
+		}
+	}
+	for _, lv := range p.localVariables {
+		if lv.startPC < 0 || lv.startPC > lv.endPC || int(lv.endPC) > len(p.code) {
+			return v.errorf("scope of local '%s' out of range", lv.name)
+		}
+	}
+	for v.pc = 0; v.pc < len(p.code); v.pc++ {
+		if err := v.instruction(); err != nil {
+			return err
+		}
+	}
+	for i := range p.prototypes {
+		if err := verify(&p.prototypes[i], p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *verifier) register(r int) error {
+	if r < 0 || r >= v.p.maxStackSize {
+		return v.errorf("register %d out of range", r)
+	}
+	return nil
+}
+
+// registers checks the n registers from r, if any.
+func (v *verifier) registers(r, n int) error {
+	if n > 0 {
+		return v.register(r + n - 1)
+	}
+	return nil
+}
+
+func (v *verifier) constant(k int) error {
+	if k >= len(v.p.constants) {
+		return v.errorf("constant %d out of range", k)
+	}
+	return nil
+}
+
+func (v *verifier) rk(x int) error {
+	if isConstant(x) {
+		return v.constant(constantIndex(x))
+	}
+	return v.register(x)
+}
+
+func (v *verifier) upValue(u int) error {
+	if u >= len(v.p.upValues) {
+		return v.errorf("up value %d out of range", u)
+	}
+	return nil
+}
+
+// jump checks the target of a jump by offset from the next instruction,
+// which must not be the argument of a preceding instruction.
+func (v *verifier) jump(offset int) error {
+	target := v.pc + 1 + offset
+	if target < 0 || target >= len(v.p.code) {
+		return v.errorf("jump to %d out of range", target+1)
+	} else if v.p.code[target].opCode() == opExtraArg {
+		return v.errorf("jump to EXTRAARG at %d", target+1)
+	}
+	return nil
+}
+
+// next checks that the instruction following the current one is op.
+func (v *verifier) next(op opCode) error {
+	if v.pc+1 >= len(v.p.code) || v.p.code[v.pc+1].opCode() != op {
+		return v.errorf("%s must be followed by %s", opNames[v.p.code[v.pc].opCode()], opNames[op])
+	}
+	return nil
+}
+
+func (v *verifier) instruction() error {
+	i := v.p.code[v.pc]
+	op := i.opCode()
+	if int(op) >= len(opNames) {
+		return v.errorf("unknown opcode %d", op)
+	}
+	a, b, c := i.a(), i.b(), i.c()
+	check := func(errs ...error) error {
+		for _, err := range errs {
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if testTMode(op) {
+		if err := v.next(opJump); err != nil {
+			return err
+		}
+	}
+	switch op {
+	case opMove, opUnaryMinus, opNot, opLength, opBitwiseNot:
+		return check(v.register(a), v.register(b))
+	case opLoadConstant:
+		return check(v.register(a), v.constant(i.bx()))
+	case opLoadConstantEx:
+		if err := check(v.register(a), v.next(opExtraArg)); err != nil {
+			return err
+		}
+		v.pc++
+		return v.constant(v.p.code[v.pc].ax())
+	case opLoadBool:
+		if c != 0 {
+			if err := v.jump(1); err != nil {
+				return err
+			}
+		}
+		return v.register(a)
+	case opLoadNil:
+		return v.registers(a, b+1)
+	case opGetUpValue:
+		return check(v.register(a), v.upValue(b))
+	case opSetUpValue:
+		return check(v.register(a), v.upValue(b))
+	case opGetTableUp:
+		return check(v.register(a), v.upValue(b), v.rk(c))
+	case opGetTable:
+		return check(v.register(a), v.register(b), v.rk(c))
+	case opSetTableUp:
+		return check(v.upValue(a), v.rk(b), v.rk(c))
+	case opSetTable:
+		return check(v.register(a), v.rk(b), v.rk(c))
+	case opNewTable, opTest, opToBeClosed:
+		return v.register(a)
+	case opSelf:
+		return check(v.registers(a, 2), v.register(b), v.rk(c))
+	case opAdd, opSub, opMul, opDiv, opMod, opPow, opFloorDivide,
+		opBitwiseAnd, opBitwiseOr, opBitwiseXor, opShiftLeft, opShiftRight:
+		return check(v.register(a), v.rk(b), v.rk(c))
+	case opConcat:
+		if b > c {
+			return v.errorf("empty concatenation")
+		}
+		return check(v.register(a), v.register(b), v.register(c))
+	case opJump:
+		if a > 0 {
+			if err := v.register(a - 1); err != nil {
+				return err
+			}
+		}
+		return v.jump(i.sbx())
+	case opEqual, opLessThan, opLessOrEqual:
+		return check(v.rk(b), v.rk(c))
+	case opTestSet:
+		return check(v.register(a), v.register(b))
+	case opCall, opTailCall:
+		if err := check(v.register(a), v.registers(a+1, b-1)); err != nil {
+			return err
+		}
+		return v.registers(a, c-1)
+	case opReturn:
+		return v.registers(a, b-1)
+	case opForLoop, opForPrep:
+		return check(v.registers(a, 4), v.jump(i.sbx()))
+	case opTForCall:
+		return check(v.registers(a, 3+c), v.next(opTForLoop))
+	case opTForLoop:
+		return check(v.registers(a, 2), v.jump(i.sbx()))
+	case opSetList:
+		if err := check(v.register(a), v.registers(a+1, b)); err != nil {
+			return err
+		}
+		if c == 0 {
+			if err := v.next(opExtraArg); err != nil {
+				return err
+			}
+			v.pc++
+		}
+		return nil
+	case opClosure:
+		if i.bx() >= len(v.p.prototypes) {
+			return v.errorf("function %d out of range", i.bx())
+		}
+		return v.register(a)
+	case opVarArg:
+		if !v.p.isVarArg {
+			return v.errorf("VARARG in a function without varargs")
+		}
+		return check(v.register(a), v.registers(a, b-1))
+	case opExtraArg:
+		return v.errorf("EXTRAARG without a preceding LOADKX or SETLIST")
+	}
+	return nil
+}
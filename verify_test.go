@@ -0,0 +1,154 @@
+package lua
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestVerifyCompiledCode(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("local t = {")
+	for i := 0; i < 30000; i++ {
+		fmt.Fprintf(&b, "%d,", i)
+	}
+	b.WriteString("}\n")
+	b.WriteString(`
+		local s = 0
+		for i, v in ipairs(t) do s = s + v end
+		for i = 1, 10, 2 do s = s - i end
+		local function f(a, ...)
+			local x, y = ..., select('#', ...)
+			while a > 0 do
+				a = a - 1
+				if a % 2 == 0 and x or not y then goto continue end
+				s = s .. "" .. a
+				::continue::
+			end
+			return function() return a, x, y, s end
+		end
+		local o = {f = f}
+		return o.f(3, 4), #t, t[30000] == 29999
+	`)
+	l := NewState()
+	OpenLibraries(l)
+	if err := LoadBuffer(l, b.String(), "=big", "t"); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	var chunk bytes.Buffer
+	if err := l.Dump(&chunk); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if err := l.Load(&chunk, "=chunk", "b"); err != nil {
+		t.Fatalf("error: %s", l.ToValue(-1))
+	}
+	if err := l.ProtectedCall(0, 3, 0); err != nil {
+		t.Fatalf("error: %s", l.ToValue(-1))
+	}
+	if n, _ := l.ToInteger(-2); n != 30000 || !l.ToBoolean(-1) {
+		t.Errorf("unexpected results %v %v", l.ToValue(-2), l.ToValue(-1))
+	}
+}
+
+func TestVerifyRejectsBadCode(t *testing.T) {
+	source := "local u = 1\nreturn function(x) return x + u, 'k' end"
+	for _, c := range []struct {
+		name     string
+		corrupt  func(main, f *prototype)
+		expected string
+	}{
+		{"register", func(_, f *prototype) { f.code[0].setA(200) }, "register 200 out of range in instruction 1 of function <chunk:2>"},
+		{"constant", func(_, f *prototype) { f.code[2].setBx(7) }, "constant 7 out of range"},
+		{"rk constant", func(_, f *prototype) { f.code[1].setB(asConstant(9)) }, "constant 9 out of range"},
+		{"up value", func(_, f *prototype) { f.code[0].setOpCode(opGetUpValue); f.code[0].setB(3) }, "up value 3 out of range"},
+		{"captured register", func(_, f *prototype) { f.upValues[0].index = 100 }, "up value captures register 100 out of range"},
+		{"jump", func(_, f *prototype) { f.code[0] = createABx(opJump, 0, 100+maxArgSBx) }, "jump to 102 out of range"},
+		{"test", func(_, f *prototype) { f.code[0] = createABC(opEqual, 0, 0, 0) }, "EQ must be followed by JMP"},
+		{"extra argument", func(_, f *prototype) { f.code[0] = createAx(opExtraArg, 0) }, "EXTRAARG without a preceding LOADKX or SETLIST"},
+		{"load constant", func(_, f *prototype) { f.code[0] = createABx(opLoadConstantEx, 0, 0) }, "LOADKX must be followed by EXTRAARG"},
+		{"return", func(_, f *prototype) { f.code[len(f.code)-1] = createABC(opMove, 0, 0, 0) }, "missing final RETURN in function <chunk:2>"},
+		{"closure", func(main, _ *prototype) { main.code[1].setBx(1) }, "function 1 out of range"},
+		{"vararg", func(_, f *prototype) { f.code[0] = createABC(opVarArg, 0, 2, 0) }, "VARARG in a function without varargs"},
+		{"opcode", func(_, f *prototype) { f.code[0].setOpCode(60) }, "unknown opcode 60"},
+		{"line information", func(_, f *prototype) { f.lineInfo = f.lineInfo[:1] }, "line information for 1 of"},
+	} {
+		l := NewState()
+		if err := l.Load(strings.NewReader(source), "=chunk", "t"); err != nil {
+			t.Fatalf("error: %s", err)
+		}
+		main := l.stack[l.top-1].(*luaClosure).prototype
+		f := &main.prototypes[0]
+		f.code = append([]instruction(nil), f.code...)
+		c.corrupt(main, f)
+		var chunk bytes.Buffer
+		if err := l.dump(main, &chunk, false, HostFormat); err != nil {
+			t.Fatalf("error: %s", err)
+		}
+		if err := l.Load(&chunk, "=chunk", "b"); err != SyntaxError {
+			t.Errorf("%s: expected a syntax error but found %v", c.name, err)
+		} else if msg, _ := l.ToString(-1); !strings.HasPrefix(msg, "chunk: bad code in precompiled chunk: ") || !strings.Contains(msg, c.expected) {
+			t.Errorf("%s: expected error %q but found %q", c.name, c.expected, msg)
+		}
+	}
+}
+
+func TestUndumpRejectsCorruptChunks(t *testing.T) {
+	l := NewState()
+	if err := LoadString(l, "return {1, 2, 'three'}"); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	var chunk bytes.Buffer
+	if err := l.Dump(&chunk); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	b := chunk.Bytes()
+	codeSize := binary.Size(header) + 11 // after the line numbers, parameters, vararg flag and stack size
+	for _, c := range []struct {
+		chunk    []byte
+		expected string
+	}{
+		{b[:len(b)-3], "chunk: truncated precompiled chunk"},
+		{append(b[:codeSize:codeSize], 0xff, 0xff, 0xff, 0xff), "chunk: corrupted precompiled chunk"},
+		{append(b[:codeSize:codeSize], 0xff, 0xff, 0xff, 0x7f), "chunk: truncated precompiled chunk"},
+	} {
+		if err := l.Load(bytes.NewReader(c.chunk), "=chunk", "b"); err != SyntaxError {
+			t.Errorf("expected a syntax error but found %v", err)
+		} else if msg, _ := l.ToString(-1); msg != c.expected {
+			t.Errorf("expected error %q but found %q", c.expected, msg)
+		}
+		l.Pop(1)
+	}
+}
+
+func TestLargeTableConstructor(t *testing.T) {
+	l := NewState()
+	OpenLibraries(l)
+	err := DoString(l, `
+		local f = load("return {" .. string.rep("1,", 1100000) .. "}")
+		local g = assert(load(string.dump(f), "d", "b"))
+		assert(#g() == 1100000)
+	`)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+
+	// A corrupted size hint only bounds the preallocation.
+	if err := LoadString(l, "local t = {} t[1] = 1 return t"); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	p := l.stack[l.top-1].(*luaClosure).prototype
+	p.code[0] = createABC(opNewTable, 0, 0x1ff, 0x1ff)
+	var chunk bytes.Buffer
+	if err := l.Dump(&chunk); err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	if err := l.Load(&chunk, "=chunk", "b"); err != nil {
+		t.Fatalf("error: %s", l.ToValue(-1))
+	} else if err := l.ProtectedCall(0, 1, 0); err != nil {
+		t.Fatalf("error: %s", l.ToValue(-1))
+	} else if n := l.RawLength(-1); n != 1 {
+		t.Errorf("unexpected table length %d", n)
+	}
+}
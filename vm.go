@@ -7,13 +7,29 @@ import (
 )
 
 func (l *State) arith(rb, rc value, op tm) value {
-	if b, ok := l.toNumber(rb); ok {
-		if c, ok := l.toNumber(rc); ok {
-			return arith(Operator(op-tmAdd)+OpAdd, b, c)
+	o := Operator(op-tmAdd) + OpAdd
+	if b, ok := l.toNumeric(rb); ok {
+		if c, ok := l.toNumeric(rc); ok {
+			if !isBitwise(o) {
+				if _, ok := b.(int64); ok && c == int64(0) {
+					if o == OpMod {
+						l.runtimeError("attempt to perform 'n%0'")
+					} else if o == OpFloorDivide {
+						l.runtimeError("attempt to perform 'n//0'")
+					}
+				}
+				return numberArith(o, b, c)
+			} else if i, ok := numberToInteger(b); ok {
+				if j, ok := numberToInteger(c); ok {
+					return l.integer(integerArith(o, i, j))
+				}
+			}
 		}
 	}
 	if result, ok := l.callBinaryTagMethod(rb, rc, op); ok {
 		return result
+	} else if isBitwise(o) {
+		l.bitwiseError(rb, rc)
 	}
 	l.arithError(rb, rc)
 	return nil
@@ -51,7 +67,7 @@ func (l *State) setTableAt(t value, key value, val value) {
 				return
 			} else if tm = l.fastTagMethod(table.metaTable, tmNewIndex); tm == nil {
 				// no metamethod
-				table.put(l, key, val)
+				l.putAndAllocate(table, key, val)
 				table.invalidateTagMethodCache()
 				return
 			}
@@ -73,10 +89,10 @@ func (l *State) objectLength(v value) value {
 	switch v := v.(type) {
 	case *table:
 		if tm = l.fastTagMethod(v.metaTable, tmLen); tm == nil {
-			return float64(v.length())
+			return l.integer(int64(v.length()))
 		}
 	case string:
-		return float64(len(v))
+		return l.integer(int64(len(v)))
 	default:
 		if tm = l.tagMethodByObject(v, tmLen); tm == nil {
 			l.typeError(v, "get length of")
@@ -112,11 +128,73 @@ func (l *State) equalObjects(t1, t2 value) bool {
 			tm = l.equalTagMethod(t1.metaTable, t2.metaTable, tmEq)
 		}
 	default:
-		return t1 == t2
+		return rawEqual(t1, t2)
 	}
 	return tm != nil && !isFalse(l.callTagMethod(tm, t1, t2))
 }
 
+// rawEqual compares values without metamethods. An integer equals a float
+// with the same mathematical value.
+func rawEqual(t1, t2 value) bool {
+	switch n := t1.(type) {
+	case int64:
+		if f, ok := t2.(float64); ok {
+			i, ok := floatToInteger(f)
+			return ok && i == n
+		}
+	case float64:
+		if i, ok := t2.(int64); ok {
+			j, ok := floatToInteger(n)
+			return ok && i == j
+		}
+	}
+	return t1 == t2
+}
+
+// numberLess compares numbers, either of which may be an integer, exactly.
+func numberLess(n1, n2 value, orEqual bool) (result, ok bool) {
+	switch n1 := n1.(type) {
+	case float64:
+		switch n2 := n2.(type) {
+		case float64:
+			return n1 < n2 || orEqual && n1 == n2, true
+		case int64:
+			if orEqual { // f <= i iff ceil(f) <= i
+				return integerLess(n2, math.Ceil(n1), true, true), true
+			}
+			return integerLess(n2, math.Floor(n1), false, true), true // f < i iff floor(f) < i
+		}
+	case int64:
+		switch n2 := n2.(type) {
+		case int64:
+			return n1 < n2 || orEqual && n1 == n2, true
+		case float64:
+			if orEqual { // i <= f iff i <= floor(f)
+				return integerLess(n1, math.Floor(n2), true, false), true
+			}
+			return integerLess(n1, math.Ceil(n2), false, false), true // i < f iff i < ceil(f)
+		}
+	}
+	return false, false
+}
+
+// integerLess compares i with the integral float f, as i < f or i <= f, or
+// reversed as f < i or f <= i.
+func integerLess(i int64, f float64, orEqual, reversed bool) bool {
+	if math.IsNaN(f) {
+		return false
+	} else if f >= 1<<63 {
+		return !reversed
+	} else if f < -1<<63 {
+		return reversed
+	}
+	j := int64(f)
+	if reversed {
+		i, j = j, i
+	}
+	return i < j || orEqual && i == j
+}
+
 func (l *State) callBinaryTagMethod(p1, p2 value, event tm) (value, bool) {
 	tm := l.tagMethodByObject(p1, event)
 	if tm == nil {
@@ -138,6 +216,9 @@ func (l *State) lessThan(left, right value) bool {
 		if rf, ok := right.(float64); ok {
 			return lf < rf
 		}
+	}
+	if result, ok := numberLess(left, right, false); ok {
+		return result
 	} else if ls, ok := left.(string); ok {
 		if rs, ok := right.(string); ok {
 			return ls < rs
@@ -155,6 +236,9 @@ func (l *State) lessOrEqual(left, right value) bool {
 		if rf, ok := right.(float64); ok {
 			return lf <= rf
 		}
+	}
+	if result, ok := numberLess(left, right, true); ok {
+		return result
 	} else if ls, ok := left.(string); ok {
 		if rs, ok := right.(string); ok {
 			return ls <= rs
@@ -169,6 +253,81 @@ func (l *State) lessOrEqual(left, right value) bool {
 	return false
 }
 
+// forPrep converts the initial value, limit and step of a numeric for loop
+// in r, and subtracts the step from the initial value. The loop uses
+// integers if the initial value and step are integers, and floats otherwise.
+func (l *State) forPrep(r []value) {
+	init, ok := l.toNumeric(r[0])
+	if !ok {
+		l.runtimeError("'for' initial value must be a number")
+	}
+	limit, ok := l.toNumeric(r[1])
+	if !ok {
+		l.runtimeError("'for' limit must be a number")
+	}
+	step, ok := l.toNumeric(r[2])
+	if !ok {
+		l.runtimeError("'for' step must be a number")
+	}
+	if i, ok := init.(int64); ok {
+		if s, ok := step.(int64); ok {
+			if s == 0 {
+				l.runtimeError("'for' step is zero")
+			}
+			// As in Lua 5.4, the number of iterations is computed up front, so
+			// that the loop never wraps around. It is kept in place of the limit.
+			var count uint64
+			if limit, ok := forLimit(limit, s); ok {
+				count = forCount(i, limit, s)
+			}
+			r[0], r[1], r[2] = i-s, int64(count), s
+			return
+		}
+	}
+	r[0], r[1], r[2] = toFloat(init)-toFloat(step), toFloat(limit), toFloat(step)
+}
+
+// forLimit converts the limit of an integer for loop to an integer, rounding
+// towards the initial value. It fails if the loop must not run at all, or if
+// the limit is NaN.
+func forLimit(limit value, step int64) (int64, bool) {
+	f, ok := limit.(float64)
+	if !ok {
+		return limit.(int64), true
+	} else if step > 0 {
+		f = math.Floor(f)
+	} else {
+		f = math.Ceil(f)
+	}
+	switch {
+	case math.IsNaN(f):
+		return 0, false
+	case f >= 1<<63:
+		return math.MaxInt64, step > 0
+	case f < -1<<63:
+		return math.MinInt64, step < 0
+	}
+	return int64(f), true
+}
+
+// forCount returns the number of iterations of an integer for loop, as an
+// unsigned number saturating at the maximum uint64.
+func forCount(init, limit, step int64) uint64 {
+	var n uint64
+	switch {
+	case step > 0 && init <= limit:
+		n = (uint64(limit) - uint64(init)) / uint64(step)
+	case step < 0 && limit <= init:
+		n = (uint64(init) - uint64(limit)) / (uint64(-(step + 1)) + 1) // avoid overflow with math.MinInt64
+	default:
+		return 0
+	}
+	if n == math.MaxUint64 {
+		return n
+	}
+	return n + 1
+}
+
 func (l *State) concat(total int) {
 	t := func(i int) value { return l.stack[l.top-i] }
 	put := func(i int, v value) { l.stack[l.top-i] = v }
@@ -184,7 +343,7 @@ func (l *State) concat(total int) {
 		n := 2 // # of elements handled in this pass (at least 2)
 		s2, ok := t(2).(string)
 		if !ok {
-			_, ok = t(2).(float64)
+			ok = isNumber(t(2))
 		}
 		if !ok {
 			concatTagMethod()
@@ -209,7 +368,9 @@ func (l *State) concat(total int) {
 			for i, j := 0, len(ss)-1; i < j; i, j = i+1, j-1 {
 				ss[i], ss[j] = ss[j], ss[i]
 			}
-			put(len(ss), strings.Join(ss, ""))
+			s := strings.Join(ss, "")
+			l.allocate(stringSize + len(s))
+			put(len(ss), s)
 		}
 		total -= n - 1 // created 1 new string from `n` strings
 		l.top -= n - 1 // popped `n` strings and pushed 1
@@ -227,26 +388,83 @@ func (l *State) traceExecution() {
 		callInfo.clearCallStatus(callStatusHookYielded)
 		return
 	}
+	// Hooks run before the instruction at savedPC is fetched. While they run,
+	// savedPC is moved past it, as while executing an instruction, so that
+	// the hooks see it as the current instruction, e.g. in debug.getinfo.
+	npc := callInfo.savedPC
+	callInfo.savedPC++
 	if countHook {
 		l.hook(HookCount, -1)
 	}
-	if mask&MaskLine != 0 {
-		p := l.prototype(callInfo)
-		npc := callInfo.savedPC - 1
+	if p := l.prototype(callInfo); mask&MaskLine != 0 && len(p.lineInfo) > 0 { // stripped chunks have no line information
 		newline := p.lineInfo[npc]
-		if npc == 0 || callInfo.savedPC <= l.oldPC || newline != p.lineInfo[l.oldPC-1] {
+		if npc == 0 || callInfo.savedPC <= l.oldPC || l.oldPC == 0 || newline != p.lineInfo[l.oldPC-1] {
 			l.hook(HookLine, int(newline))
 		}
 	}
 	l.oldPC = callInfo.savedPC
-	if l.shouldYield {
+	callInfo.savedPC--
+	if l.shouldYield { // did hook yield?
 		if countHook {
-			l.hookCount = 1
+			l.hookCount = 1 // undo decrement to zero
+		}
+		// Hooks run before the next instruction is fetched, so savedPC is
+		// already positioned for the resumed execution.
+		callInfo.setCallStatus(callStatusHookYielded) // mark that it yielded
+		callInfo.function = l.top - 1                 // protect stack below results
+		l.throw(yieldError)
+	}
+}
+
+// finishOp completes the instruction interrupted by a yield inside a
+// metamethod or a Go function, so that execute can resume after it.
+func (l *State) finishOp() {
+	ci := l.callInfo
+	constants := l.prototype(ci).constants
+	i := ci.code[ci.savedPC-1] // interrupted instruction
+	switch op := i.opCode(); op {
+	case opAdd, opSub, opMul, opDiv, opMod, opPow, opUnaryMinus, opLength, opGetTableUp, opGetTable, opSelf,
+		opFloorDivide, opBitwiseAnd, opBitwiseOr, opBitwiseXor, opShiftLeft, opShiftRight, opBitwiseNot:
+		l.top--
+		ci.frame[i.a()] = l.stack[l.top]
+	case opLessOrEqual, opLessThan, opEqual:
+		result := !isFalse(l.stack[l.top-1])
+		l.top--
+		if op == opLessOrEqual { // "<=" using "<" instead?
+			b, c := k(i.b(), constants, ci.frame), k(i.c(), constants, ci.frame)
+			if l.tagMethodByObject(b, tmLE) == nil && l.tagMethodByObject(c, tmLE) == nil {
+				result = !result
+			}
+		}
+		if result != (i.a() != 0) { // condition failed?
+			ci.skip() // skip jump instruction
 		}
-		callInfo.savedPC--
-		callInfo.setCallStatus(callStatusHookYielded)
-		callInfo.function = l.top - 1
-		panic("Not implemented - use goroutines to emulate yield")
+	case opConcat:
+		top := l.top - 1                    // top when 'callBinaryTagMethod' was called
+		b := i.b()                          // first element to concatenate
+		total := top - 1 - ci.stackIndex(b) // yet to concatenate
+		l.stack[top-2] = l.stack[top]       // put tag method result in proper position
+		if total > 1 {                      // are there elements to concat?
+			l.top = top - 1 // top is one after last element (at top-2)
+			l.concat(total) // concat them (may yield again)
+		}
+		a := i.a()
+		ci.frame[a] = l.stack[l.top-1] // move final result to final position
+		if a >= b {                    // limit of live values
+			clear(ci.frame[a+1:])
+		} else {
+			clear(ci.frame[b:])
+		}
+		l.top = ci.top
+	case opTForCall:
+		l.top = ci.top // correct top
+	case opCall:
+		if i.c()-1 >= 0 { // resultCount >= 0?
+			l.top = ci.top // adjust results
+		}
+	case opTailCall, opSetTableUp, opSetTable:
+	default:
+		l.assert(false)
 	}
 }
 
@@ -289,9 +507,17 @@ func (e *engine) newFrame() {
 	e.constants = e.closure.prototype.constants
 }
 
-func (e *engine) hooked() bool { return e.l.hookMask&(MaskLine|MaskCount) != 0 }
+func (e *engine) hooked() bool {
+	return e.l.hookMask&(MaskLine|MaskCount) != 0 || e.l.global.budget != nil
+}
 
 func (e *engine) hook() {
+	if e.l.global.budget != nil {
+		e.l.checkBudget()
+	}
+	if e.l.hookMask&(MaskLine|MaskCount) == 0 {
+		return
+	}
 	if e.l.hookCount--; e.l.hookCount == 0 || e.l.hookMask&MaskLine != 0 {
 		e.l.traceExecution()
 		e.frame = e.callInfo.frame
@@ -404,12 +630,18 @@ func init() {
 		},
 		func(e *engine, i instruction) (engineOp, instruction) { // opNewTable
 			a := i.a()
-			if b, c := float8(i.b()), float8(i.c()); b != 0 || c != 0 {
-				e.frame[a] = newTableWithSize(intFromFloat8(b), intFromFloat8(c))
+			if b, c := sizeHints(i); b != 0 || c != 0 {
+				e.l.allocate(tableSize + b*valueSize + c*hashEntrySize)
+				e.frame[a] = newTableWithSize(b, c)
 			} else {
+				e.l.allocate(tableSize)
 				e.frame[a] = newTable()
 			}
 			clear(e.frame[a+1:])
+			if e.l.finalizersPending() {
+				e.l.runFinalizers()
+				e.frame = e.callInfo.frame
+			}
 			if e.hooked() {
 				e.hook()
 			}
@@ -612,6 +844,7 @@ func init() {
 		func(e *engine, i instruction) (engineOp, instruction) { // opJump
 			if a := i.a(); a > 0 {
 				e.l.close(e.callInfo.stackIndex(a - 1))
+				e.frame = e.callInfo.frame // __close metamethods may have grown the stack
 			}
 			e.callInfo.jump(i.sbx())
 			if e.hooked() {
@@ -627,6 +860,7 @@ func init() {
 				i := e.callInfo.step()
 				if a := i.a(); a > 0 {
 					e.l.close(e.callInfo.stackIndex(a - 1))
+					e.frame = e.callInfo.frame
 				}
 				e.callInfo.jump(i.sbx())
 			} else {
@@ -646,6 +880,7 @@ func init() {
 				i := e.callInfo.step()
 				if a := i.a(); a > 0 {
 					e.l.close(e.callInfo.stackIndex(a - 1))
+					e.frame = e.callInfo.frame
 				}
 				e.callInfo.jump(i.sbx())
 			} else {
@@ -665,6 +900,7 @@ func init() {
 				i := e.callInfo.step()
 				if a := i.a(); a > 0 {
 					e.l.close(e.callInfo.stackIndex(a - 1))
+					e.frame = e.callInfo.frame
 				}
 				e.callInfo.jump(i.sbx())
 			} else {
@@ -683,6 +919,7 @@ func init() {
 				i := e.callInfo.step()
 				if a := i.a(); a > 0 {
 					e.l.close(e.callInfo.stackIndex(a - 1))
+					e.frame = e.callInfo.frame
 				}
 				e.callInfo.jump(i.sbx())
 			} else {
@@ -702,6 +939,7 @@ func init() {
 				i := e.callInfo.step()
 				if a := i.a(); a > 0 {
 					e.l.close(e.callInfo.stackIndex(a - 1))
+					e.frame = e.callInfo.frame
 				}
 				e.callInfo.jump(i.sbx())
 			} else {
@@ -777,7 +1015,7 @@ func init() {
 			if b := i.b(); b != 0 {
 				e.l.top = e.callInfo.stackIndex(a + b - 1)
 			}
-			if len(e.closure.prototype.prototypes) > 0 {
+			if len(e.closure.prototype.prototypes) > 0 || len(e.l.toBeClosed) > 0 {
 				e.l.close(e.callInfo.base())
 			}
 			n := e.l.postCall(e.callInfo.stackIndex(a))
@@ -798,11 +1036,21 @@ func init() {
 		},
 		func(e *engine, i instruction) (engineOp, instruction) { // opForLoop
 			a := i.a()
-			index, limit, step := e.frame[a+0].(float64), e.frame[a+1].(float64), e.frame[a+2].(float64)
-			if index += step; (0 < step && index <= limit) || (step <= 0 && limit <= index) {
-				e.callInfo.jump(i.sbx())
-				e.frame[a+0] = index // update internal index...
-				e.frame[a+3] = index // ... and external index
+			if index, ok := e.frame[a+0].(int64); ok {
+				if count := uint64(e.frame[a+1].(int64)); count > 0 {
+					index += e.frame[a+2].(int64)
+					e.frame[a+1] = int64(count - 1)
+					e.callInfo.jump(i.sbx())
+					e.frame[a+0] = index // update internal index...
+					e.frame[a+3] = index // ... and external index
+				}
+			} else {
+				index, limit, step := e.frame[a+0].(float64), e.frame[a+1].(float64), e.frame[a+2].(float64)
+				if index += step; (0 < step && index <= limit) || (step <= 0 && limit <= index) {
+					e.callInfo.jump(i.sbx())
+					e.frame[a+0] = index // update internal index...
+					e.frame[a+3] = index // ... and external index
+				}
 			}
 			if e.hooked() {
 				e.hook()
@@ -812,16 +1060,8 @@ func init() {
 		},
 		func(e *engine, i instruction) (engineOp, instruction) { // opForPrep
 			a := i.a()
-			if init, ok := e.l.toNumber(e.frame[a+0]); !ok {
-				e.l.runtimeError("'for' initial value must be a number")
-			} else if limit, ok := e.l.toNumber(e.frame[a+1]); !ok {
-				e.l.runtimeError("'for' limit must be a number")
-			} else if step, ok := e.l.toNumber(e.frame[a+2]); !ok {
-				e.l.runtimeError("'for' step must be a number")
-			} else {
-				e.frame[a+0], e.frame[a+1], e.frame[a+2] = init-step, limit, step
-				e.callInfo.jump(i.sbx())
-			}
+			e.l.forPrep(e.frame[a : a+3])
+			e.callInfo.jump(i.sbx())
 			if e.hooked() {
 				e.hook()
 			}
@@ -870,6 +1110,7 @@ func init() {
 			start := (c - 1) * listItemsPerFlush
 			last := start + n
 			if last > len(h.array) {
+				e.l.allocate((last - len(h.array)) * valueSize)
 				h.extendArray(last)
 			}
 			copy(h.array[start:last], e.frame[a+1:a+1+n])
@@ -888,6 +1129,10 @@ func init() {
 				e.frame[a] = ncl
 			}
 			clear(e.frame[a+1:])
+			if e.l.finalizersPending() {
+				e.l.runFinalizers()
+				e.frame = e.callInfo.frame
+			}
 			if e.hooked() {
 				e.hook()
 			}
@@ -924,6 +1169,84 @@ func init() {
 		func(e *engine, i instruction) (engineOp, instruction) { // opExtraArg
 			panic(fmt.Sprintf("unexpected opExtraArg instruction, '%s'", i.String()))
 		},
+		func(e *engine, i instruction) (engineOp, instruction) { // opFloorDivide
+			tmp := e.l.arith(e.k(i.b()), e.k(i.c()), tmFloorDivide)
+			e.frame = e.callInfo.frame
+			e.frame[i.a()] = tmp
+			if e.hooked() {
+				e.hook()
+			}
+			i = e.callInfo.step()
+			return jumpTable[i.opCode()], i
+		},
+		func(e *engine, i instruction) (engineOp, instruction) { // opBitwiseAnd
+			tmp := e.l.arith(e.k(i.b()), e.k(i.c()), tmBitwiseAnd)
+			e.frame = e.callInfo.frame
+			e.frame[i.a()] = tmp
+			if e.hooked() {
+				e.hook()
+			}
+			i = e.callInfo.step()
+			return jumpTable[i.opCode()], i
+		},
+		func(e *engine, i instruction) (engineOp, instruction) { // opBitwiseOr
+			tmp := e.l.arith(e.k(i.b()), e.k(i.c()), tmBitwiseOr)
+			e.frame = e.callInfo.frame
+			e.frame[i.a()] = tmp
+			if e.hooked() {
+				e.hook()
+			}
+			i = e.callInfo.step()
+			return jumpTable[i.opCode()], i
+		},
+		func(e *engine, i instruction) (engineOp, instruction) { // opBitwiseXor
+			tmp := e.l.arith(e.k(i.b()), e.k(i.c()), tmBitwiseXor)
+			e.frame = e.callInfo.frame
+			e.frame[i.a()] = tmp
+			if e.hooked() {
+				e.hook()
+			}
+			i = e.callInfo.step()
+			return jumpTable[i.opCode()], i
+		},
+		func(e *engine, i instruction) (engineOp, instruction) { // opShiftLeft
+			tmp := e.l.arith(e.k(i.b()), e.k(i.c()), tmShiftLeft)
+			e.frame = e.callInfo.frame
+			e.frame[i.a()] = tmp
+			if e.hooked() {
+				e.hook()
+			}
+			i = e.callInfo.step()
+			return jumpTable[i.opCode()], i
+		},
+		func(e *engine, i instruction) (engineOp, instruction) { // opShiftRight
+			tmp := e.l.arith(e.k(i.b()), e.k(i.c()), tmShiftRight)
+			e.frame = e.callInfo.frame
+			e.frame[i.a()] = tmp
+			if e.hooked() {
+				e.hook()
+			}
+			i = e.callInfo.step()
+			return jumpTable[i.opCode()], i
+		},
+		func(e *engine, i instruction) (engineOp, instruction) { // opBitwiseNot
+			tmp := e.l.arith(e.frame[i.b()], e.frame[i.b()], tmBitwiseNot)
+			e.frame = e.callInfo.frame
+			e.frame[i.a()] = tmp
+			if e.hooked() {
+				e.hook()
+			}
+			i = e.callInfo.step()
+			return jumpTable[i.opCode()], i
+		},
+		func(e *engine, i instruction) (engineOp, instruction) { // opToBeClosed
+			e.l.newToBeClosed(e.callInfo.stackIndex(i.a()))
+			if e.hooked() {
+				e.hook()
+			}
+			i = e.callInfo.step()
+			return jumpTable[i.opCode()], i
+		},
 	}
 }
 
@@ -933,11 +1256,8 @@ func (l *State) executeFunctionTable() {
 	ci := l.callInfo
 	closure, _ := l.stack[ci.function].(*luaClosure)
 	e := engine{callInfo: ci, frame: ci.frame, closure: closure, constants: closure.prototype.constants, l: l}
-	if l.hookMask&(MaskLine|MaskCount) != 0 {
-		if l.hookCount--; l.hookCount == 0 || l.hookMask&MaskLine != 0 {
-			l.traceExecution()
-			e.frame = e.callInfo.frame
-		}
+	if e.hooked() {
+		e.hook()
 	}
 	i := e.callInfo.step()
 	f := jumpTable[i.opCode()]
@@ -1013,12 +1333,18 @@ func (l *State) executeSwitch() {
 			frame = ci.frame
 		case opNewTable:
 			a := i.a()
-			if b, c := float8(i.b()), float8(i.c()); b != 0 || c != 0 {
-				frame[a] = newTableWithSize(intFromFloat8(b), intFromFloat8(c))
+			if b, c := sizeHints(i); b != 0 || c != 0 {
+				l.allocate(tableSize + b*valueSize + c*hashEntrySize)
+				frame[a] = newTableWithSize(b, c)
 			} else {
+				l.allocate(tableSize)
 				frame[a] = newTable()
 			}
 			clear(frame[a+1:])
+			if l.finalizersPending() {
+				l.runFinalizers()
+				frame = ci.frame
+			}
 		case opSelf:
 			a, t := i.a(), frame[i.b()]
 			tmp := l.tableAt(t, k(i.c(), constants, frame))
@@ -1125,6 +1451,7 @@ func (l *State) executeSwitch() {
 		case opJump:
 			if a := i.a(); a > 0 {
 				l.close(ci.stackIndex(a - 1))
+				frame = ci.frame // __close metamethods may have grown the stack
 			}
 			ci.jump(i.sbx())
 		case opEqual:
@@ -1133,6 +1460,7 @@ func (l *State) executeSwitch() {
 				i := ci.step()
 				if a := i.a(); a > 0 {
 					l.close(ci.stackIndex(a - 1))
+					frame = ci.frame
 				}
 				ci.jump(i.sbx())
 			} else {
@@ -1145,6 +1473,7 @@ func (l *State) executeSwitch() {
 				i := ci.step()
 				if a := i.a(); a > 0 {
 					l.close(ci.stackIndex(a - 1))
+					frame = ci.frame
 				}
 				ci.jump(i.sbx())
 			} else {
@@ -1157,6 +1486,7 @@ func (l *State) executeSwitch() {
 				i := ci.step()
 				if a := i.a(); a > 0 {
 					l.close(ci.stackIndex(a - 1))
+					frame = ci.frame
 				}
 				ci.jump(i.sbx())
 			} else {
@@ -1169,6 +1499,7 @@ func (l *State) executeSwitch() {
 				i := ci.step()
 				if a := i.a(); a > 0 {
 					l.close(ci.stackIndex(a - 1))
+					frame = ci.frame
 				}
 				ci.jump(i.sbx())
 			} else {
@@ -1182,6 +1513,7 @@ func (l *State) executeSwitch() {
 				i := ci.step()
 				if a := i.a(); a > 0 {
 					l.close(ci.stackIndex(a - 1))
+					frame = ci.frame
 				}
 				ci.jump(i.sbx())
 			} else {
@@ -1239,7 +1571,7 @@ func (l *State) executeSwitch() {
 			if b := i.b(); b != 0 {
 				l.top = ci.stackIndex(a + b - 1)
 			}
-			if len(closure.prototype.prototypes) > 0 {
+			if len(closure.prototype.prototypes) > 0 || len(l.toBeClosed) > 0 {
 				l.close(ci.base())
 			}
 			n := l.postCall(ci.stackIndex(a))
@@ -1254,6 +1586,16 @@ func (l *State) executeSwitch() {
 			frame, closure, constants = newFrame(l, ci)
 		case opForLoop:
 			a := i.a()
+			if index, ok := frame[a+0].(int64); ok {
+				if count := uint64(frame[a+1].(int64)); count > 0 {
+					index += frame[a+2].(int64)
+					frame[a+1] = int64(count - 1)
+					ci.jump(i.sbx())
+					frame[a+0] = index // update internal index...
+					frame[a+3] = index // ... and external index
+				}
+				break
+			}
 			index, limit, step := frame[a+0].(float64), frame[a+1].(float64), frame[a+2].(float64)
 			if index += step; (0 < step && index <= limit) || (step <= 0 && limit <= index) {
 				ci.jump(i.sbx())
@@ -1262,16 +1604,8 @@ func (l *State) executeSwitch() {
 			}
 		case opForPrep:
 			a := i.a()
-			if init, ok := l.toNumber(frame[a+0]); !ok {
-				l.runtimeError("'for' initial value must be a number")
-			} else if limit, ok := l.toNumber(frame[a+1]); !ok {
-				l.runtimeError("'for' limit must be a number")
-			} else if step, ok := l.toNumber(frame[a+2]); !ok {
-				l.runtimeError("'for' step must be a number")
-			} else {
-				frame[a+0], frame[a+1], frame[a+2] = init-step, limit, step
-				ci.jump(i.sbx())
-			}
+			l.forPrep(frame[a : a+3])
+			ci.jump(i.sbx())
 		case opTForCall:
 			a := i.a()
 			callBase := a + 3
@@ -1299,6 +1633,7 @@ func (l *State) executeSwitch() {
 			start := (c - 1) * listItemsPerFlush
 			last := start + n
 			if last > len(h.array) {
+				l.allocate((last - len(h.array)) * valueSize)
 				h.extendArray(last)
 			}
 			copy(h.array[start:last], frame[a+1:a+1+n])
@@ -1311,6 +1646,10 @@ func (l *State) executeSwitch() {
 				frame[a] = ncl
 			}
 			clear(frame[a+1:])
+			if l.finalizersPending() {
+				l.runFinalizers()
+				frame = ci.frame
+			}
 		case opVarArg:
 			a, b := i.a(), i.b()-1
 			n := ci.base() - ci.function - closure.prototype.parameterCount - 1
@@ -1333,6 +1672,16 @@ func (l *State) executeSwitch() {
 			}
 		case opExtraArg:
 			panic(fmt.Sprintf("unexpected opExtraArg instruction, '%s'", i.String()))
+		case opFloorDivide, opBitwiseAnd, opBitwiseOr, opBitwiseXor, opShiftLeft, opShiftRight:
+			tmp := l.arith(k(i.b(), constants, frame), k(i.c(), constants, frame), tm(arithOperator(i.opCode())-OpAdd)+tmAdd)
+			frame = ci.frame
+			frame[i.a()] = tmp
+		case opBitwiseNot:
+			tmp := l.arith(frame[i.b()], frame[i.b()], tmBitwiseNot)
+			frame = ci.frame
+			frame[i.a()] = tmp
+		case opToBeClosed:
+			l.newToBeClosed(ci.stackIndex(i.a()))
 		}
 	}
 }